@@ -0,0 +1,115 @@
+package main
+
+import (
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v6"
+)
+
+// aliasesKey is the metadata store key of the stack aliases
+const aliasesKey = "aliases"
+
+// stackAliases maps the former names of renamed stacks to the name they
+// were renamed to, so that "plex" still designates the stack after its
+// directory became "media-plex"
+type stackAliases map[string]string
+
+// loadAliases returns the stack aliases of a repository, none when they
+// can't be read
+func loadAliases(repo *git.Repository) stackAliases {
+	aliases := stackAliases{}
+	store, err := openMetadataStore(repo)
+	if err == nil {
+		_, err = store.load(aliasesKey, &aliases)
+	}
+	if err != nil {
+		opsLog.Warn("Failed to read the stack aliases", "error", err)
+	}
+	return aliases
+}
+
+// current returns the name a stack has now, following its renames
+func (a stackAliases) current(name string) string {
+	seen := map[string]bool{}
+	for !seen[name] {
+		seen[name] = true
+		next, ok := a[name]
+		if !ok {
+			break
+		}
+		name = next
+	}
+	return name
+}
+
+// former returns the past names of the stack a name designates, sorted
+func (a stackAliases) former(name string) []string {
+	name = a.current(name)
+	var names []string
+	for old := range a {
+		if old != name && a.current(old) == name {
+			names = append(names, old)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// names returns the current name of the stack a name designates, followed
+// by its past names
+func (a stackAliases) names(name string) []string {
+	return append([]string{a.current(name)}, a.former(name)...)
+}
+
+// recordAlias remembers that a stack was renamed. A stack renamed back to a
+// former name drops that alias.
+func recordAlias(repo *git.Repository, oldName, newName string) error {
+	if oldName == newName {
+		return nil
+	}
+	store, err := openMetadataStore(repo)
+	if err != nil {
+		return err
+	}
+	aliases := stackAliases{}
+	if _, err := store.load(aliasesKey, &aliases); err != nil {
+		return err
+	}
+	for old, current := range aliases {
+		if current == oldName {
+			aliases[old] = newName
+		}
+	}
+	aliases[oldName] = newName
+	delete(aliases, newName)
+	return store.save(aliasesKey, aliases)
+}
+
+// withAliases sets the past names of the changes' stacks
+func withAliases(repo *git.Repository, changes []Change) []Change {
+	aliases := loadAliases(repo)
+	if len(aliases) == 0 {
+		return changes
+	}
+	for i := range changes {
+		changes[i].Aliases = aliases.former(changes[i].StackName)
+	}
+	return changes
+}
+
+// aliasTrailer returns the Stack-Alias trailer of a renamed stack's commits,
+// listing its past names for history searches
+func aliasTrailer(change Change) string {
+	if len(change.Aliases) == 0 {
+		return ""
+	}
+	return "Stack-Alias: " + strings.Join(change.Aliases, ", ")
+}
+
+// belongsToStacks reports whether a repository path is part of a stack under
+// any of its names
+func belongsToStacks(filePath string, names []string, dirs map[string]string) bool {
+	return slices.ContainsFunc(names, func(name string) bool { return belongsToStack(filePath, name, dirs) })
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/object"
+)
+
+var (
+	notesRefFlag      string
+	annotateLimitFlag int
+)
+
+func annotateFlags(fs *flag.FlagSet) {
+	fs.StringVar(&notesRefFlag, "notes-ref", "refs/notes/commits", "Notes ref the summaries are written to")
+	fs.IntVar(&annotateLimitFlag, "limit", 0, "Maximum number of commits to annotate (0 for all)")
+}
+
+// stackImpact summarizes the stack changes of a commit, one line per changed
+// compose file such as "updated nginx (nginx/compose.yml)", followed by its
+// image and environment changes
+func stackImpact(commit *object.Commit) ([]string, error) {
+	changes, err := commitChanges(commit)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(changes, func(i, j int) bool { return changeName(changes[i]) < changeName(changes[j]) })
+
+	var lines []string
+	for _, change := range changes {
+		filePath := changeName(change)
+		if !isWatchedFile(filePath) || isArchived(filePath) {
+			continue
+		}
+
+		changeType := Updated
+		switch {
+		case change.From.Name == "":
+			changeType = Created
+		case change.To.Name == "":
+			changeType = Deleted
+		}
+		lines = append(lines, fmt.Sprintf("%s %s (%s)", changeType, getStackName(filePath), filePath))
+		if isComposeFile(filePath) {
+			for _, detail := range composeChangeDetails(change) {
+				lines = append(lines, "  "+detail)
+			}
+		}
+	}
+	return lines, nil
+}
+
+// runAnnotateHistory writes a git note summarizing the stack changes of each
+// past commit made by hand, so older history reads like the watcher's own
+// commits. Commits of the watcher and commits already annotated are skipped.
+func runAnnotateHistory(repo *git.Repository, args []string) error {
+	ref := plumbing.ReferenceName(notesRefFlag)
+	if !strings.HasPrefix(notesRefFlag, "refs/notes/") {
+		return fmt.Errorf("--notes-ref %s must be under refs/notes/", notesRefFlag)
+	}
+
+	existing, err := readNotes(repo, ref)
+	if err != nil {
+		return err
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		return fmt.Errorf("failed to resolve HEAD: %w", err)
+	}
+	commits, err := repo.Log(&git.LogOptions{From: head.Hash()})
+	if err != nil {
+		return err
+	}
+	defer commits.Close()
+
+	annotated := 0
+	for annotateLimitFlag == 0 || annotated < annotateLimitFlag {
+		commit, err := commits.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			if historyTruncated(repo, err) {
+				break
+			}
+			return err
+		}
+
+		if _, ok := existing.byHash[commit.Hash]; ok || isOwnCommit(commit) {
+			continue
+		}
+
+		lines, err := stackImpact(commit)
+		if err != nil {
+			if historyTruncated(repo, err) {
+				break
+			}
+			return err
+		}
+		if len(lines) == 0 {
+			continue
+		}
+
+		existing.byHash[commit.Hash] = "Stack changes:\n" + strings.Join(lines, "\n") + "\n"
+		subject, _, _ := strings.Cut(commit.Message, "\n")
+		fmt.Printf("%s %s\n", commit.Hash.String()[:7], subject)
+		for _, line := range lines {
+			fmt.Printf("    %s\n", line)
+		}
+		annotated++
+	}
+
+	if annotated == 0 {
+		fmt.Println("No commits to annotate")
+		return nil
+	}
+	if err := existing.write(repo, fmt.Sprintf("Annotate %d commits with their stack changes", annotated)); err != nil {
+		return fmt.Errorf("failed to write notes: %w", err)
+	}
+	fmt.Printf("Annotated %d commits in %s, see them with 'git log --notes=%s'\n", annotated, ref, strings.TrimPrefix(notesRefFlag, "refs/notes/"))
+
+	if pushFlag {
+		return pushNotes(repo, ref)
+	}
+	return nil
+}
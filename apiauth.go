@@ -0,0 +1,188 @@
+package main
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// apiAllowed are the parsed --api-allow networks, nil to allow any client
+var apiAllowed []*net.IPNet
+
+// parseAPIAllow parses the comma separated networks and addresses of
+// --api-allow, such as 192.168.1.0/24 or 10.0.0.5
+func parseAPIAllow() error {
+	apiAllowed = nil
+	for _, item := range strings.Split(apiAllowFlag, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return fmt.Errorf("invalid address %q", item)
+			}
+			bits := 8 * len(ip.To4())
+			if bits == 0 {
+				bits = 128
+			}
+			item = fmt.Sprintf("%s/%d", item, bits)
+		}
+		_, network, err := net.ParseCIDR(item)
+		if err != nil {
+			return fmt.Errorf("invalid network %q", item)
+		}
+		apiAllowed = append(apiAllowed, network)
+	}
+	return nil
+}
+
+// apiRole is the access an HTTP endpoint requires, and the one a token grants
+type apiRole int
+
+const (
+	// roleNone endpoints, the health ones, are open to any allowed client
+	roleNone apiRole = iota
+	// roleViewer endpoints only read the watcher's state: status, logs and
+	// metrics. --api-viewer-token and --api-token both reach them.
+	roleViewer
+	// roleAdmin endpoints change what the watcher does, only --api-token
+	// reaches them
+	roleAdmin
+)
+
+// apiSecured reports whether clients of the HTTP endpoints must prove who
+// they are, with a token or a client certificate
+func apiSecured() bool {
+	return apiTokensSet() || apiClientCAFlag != ""
+}
+
+// apiTokensSet reports whether any API token is configured; without one the
+// endpoints are open to every allowed client
+func apiTokensSet() bool {
+	return secretValue(apiTokenFlag, "API_TOKEN") != "" || secretValue(apiViewerTokenFlag, "API_VIEWER_TOKEN") != ""
+}
+
+// tokenRole returns the role a bearer token grants, roleNone when it matches
+// no configured token
+func tokenRole(given string) apiRole {
+	matches := func(token string) bool {
+		return token != "" && subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+	}
+	switch {
+	case matches(secretValue(apiTokenFlag, "API_TOKEN")):
+		return roleAdmin
+	case matches(secretValue(apiViewerTokenFlag, "API_VIEWER_TOKEN")):
+		return roleViewer
+	}
+	return roleNone
+}
+
+// apiTLSConfig returns the TLS configuration of the HTTP endpoints, nil
+// without --api-tls-cert. --api-client-ca requires clients to present a
+// certificate it signed (mTLS).
+func apiTLSConfig() (*tls.Config, error) {
+	if apiTLSCertFlag == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(apiTLSCertFlag, apiTLSKeyFlag)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+
+	if apiClientCAFlag != "" {
+		data, err := os.ReadFile(apiClientCAFlag)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, errors.New("no PEM certificate in --api-client-ca")
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// apiListen listens for the HTTP endpoints, over TLS when configured
+func apiListen(addr string) (net.Listener, error) {
+	config, err := apiTLSConfig()
+	if err != nil {
+		return nil, fmt.Errorf("TLS: %w", err)
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if config != nil {
+		listener = tls.NewListener(listener, config)
+	}
+	return listener, nil
+}
+
+// apiURL returns the URL of a path served by a listener of apiListen
+func apiURL(listener net.Listener, path string) string {
+	scheme := "http"
+	if apiTLSCertFlag != "" {
+		scheme = "https"
+	}
+	return scheme + "://" + listener.Addr().String() + path
+}
+
+// protectAPI rejects the requests of clients outside of --api-allow and,
+// once tokens are configured, those without a bearer token granting role
+func protectAPI(next http.Handler, role apiRole) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+
+		if !allowedClient(net.ParseIP(host)) {
+			processLog.Warn("Rejected API request from a client outside of --api-allow", "client", host, "path", r.URL.Path)
+			http.Error(rw, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		if role > roleNone && apiTokensSet() {
+			given, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			granted := tokenRole(given)
+			if granted == roleNone {
+				processLog.Warn("Rejected API request without a valid token", "client", host, "path", r.URL.Path)
+				rw.Header().Set("WWW-Authenticate", `Bearer realm="git-stack-watch"`)
+				http.Error(rw, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if granted < role {
+				processLog.Warn("Rejected API request of a viewer token to an admin endpoint", "client", host, "path", r.URL.Path)
+				http.Error(rw, "forbidden", http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// allowedClient reports whether --api-allow lets a client in
+func allowedClient(ip net.IP) bool {
+	if len(apiAllowed) == 0 {
+		return true
+	}
+	if ip == nil {
+		return false
+	}
+	for _, network := range apiAllowed {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
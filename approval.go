@@ -0,0 +1,286 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v6"
+)
+
+// approvalsKey is the state store key of the deletions waiting for approval
+const approvalsKey = "approvals"
+
+// approver is a person allowed to approve deletions, known by the token
+// they send
+type approver struct {
+	name  string
+	token string
+}
+
+// approvers are the parsed --approvers
+var approvers []approver
+
+// approvalsMu serializes the reads and writes of the approvals of every
+// repository, so that the approval endpoints don't wait for cycles. It also
+// covers reopenIfDue replacing the repository the endpoints read them from.
+var approvalsMu sync.Mutex
+
+// pendingDeletion is a stack deletion held until enough distinct approvers
+// approve it
+type pendingDeletion struct {
+	Stack     string    `json:"stack"`
+	Since     time.Time `json:"since"`
+	Approvers []string  `json:"approvers"`
+}
+
+// parseApprovers parses the comma separated name:token pairs of
+// --approvers. Names and tokens must be distinct, so that every approval
+// comes from a different person.
+func parseApprovers() error {
+	approvers = nil
+	for i, item := range strings.Split(secretValue(approversFlag, "APPROVERS"), ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		// The item holds a token, so only its position is reported
+		name, token, ok := strings.Cut(item, ":")
+		name, token = strings.TrimSpace(name), strings.TrimSpace(token)
+		if !ok || name == "" || token == "" {
+			return fmt.Errorf("invalid approver #%d, expected name:token", i+1)
+		}
+		for _, other := range approvers {
+			if other.name == name {
+				return fmt.Errorf("approver %q is listed twice", name)
+			}
+			if other.token == token {
+				return fmt.Errorf("approvers %q and %q share a token", other.name, name)
+			}
+		}
+		approvers = append(approvers, approver{name: name, token: token})
+	}
+
+	if approveDeletionsFlag < 0 {
+		return fmt.Errorf("--approve-deletions must be positive, got %d", approveDeletionsFlag)
+	}
+	if approveDeletionsFlag > len(approvers) {
+		return fmt.Errorf("--approve-deletions %d needs as many --approvers, got %d", approveDeletionsFlag, len(approvers))
+	}
+	return nil
+}
+
+// approverOf returns the name of the approver a bearer token belongs to
+func approverOf(token string) (string, bool) {
+	for _, approver := range approvers {
+		if token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(approver.token)) == 1 {
+			return approver.name, true
+		}
+	}
+	return "", false
+}
+
+// loadApprovals returns the deletions waiting for approval, by path
+func loadApprovals(repo *git.Repository) (stateStore, map[string]pendingDeletion, error) {
+	store, err := openStateStore(repo)
+	if err != nil {
+		return nil, nil, err
+	}
+	pending := map[string]pendingDeletion{}
+	if _, err := store.load(approvalsKey, &pending); err != nil {
+		return nil, nil, err
+	}
+	return store, pending, nil
+}
+
+// deletionApprovers returns the approvers of a deletion once --approve-deletions
+// of them approved it. Until then the deletion is recorded as pending and
+// errCommitHeld is returned.
+func deletionApprovers(repo *git.Repository, change Change) ([]string, error) {
+	if approveDeletionsFlag == 0 || change.ChangeType != Deleted {
+		return nil, nil
+	}
+	approvalsMu.Lock()
+	defer approvalsMu.Unlock()
+
+	store, pending, err := loadApprovals(repo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load approvals: %w", err)
+	}
+	deletion, ok := pending[change.FilePath]
+	if ok && len(deletion.Approvers) >= approveDeletionsFlag {
+		return deletion.Approvers, nil
+	}
+	if !ok {
+		pending[change.FilePath] = pendingDeletion{Stack: change.StackName, Since: time.Now()}
+		if err := store.save(approvalsKey, pending); err != nil {
+			return nil, fmt.Errorf("failed to save approvals: %w", err)
+		}
+	}
+	return nil, fmt.Errorf("%w: deletion of %s approved by %d of %d", errCommitHeld, change.FilePath, len(deletion.Approvers), approveDeletionsFlag)
+}
+
+// forgetApprovals drops the deletions waiting for approval that forget
+// reports as settled
+func forgetApprovals(repo *git.Repository, forget func(path string) bool) {
+	if approveDeletionsFlag == 0 {
+		return
+	}
+	approvalsMu.Lock()
+	defer approvalsMu.Unlock()
+
+	store, pending, err := loadApprovals(repo)
+	if err != nil || len(pending) == 0 {
+		return
+	}
+
+	forgotten := false
+	for path := range pending {
+		if forget(path) {
+			delete(pending, path)
+			forgotten = true
+		}
+	}
+	if !forgotten {
+		return
+	}
+	if err := store.save(approvalsKey, pending); err != nil {
+		opsLog.Warn("Failed to save approvals", "error", err)
+	}
+}
+
+// pruneApprovals forgets the deletions of compose files that were restored
+// before being approved, so that deleting them again starts over
+func pruneApprovals(repo *git.Repository, repoPath string) {
+	forgetApprovals(repo, func(path string) bool {
+		_, err := os.Lstat(filepath.Join(repoPath, path))
+		return err == nil
+	})
+}
+
+// approvalTrailer returns the Approved-by trailer of an approved deletion
+func approvalTrailer(change Change) string {
+	if len(change.Approvers) == 0 {
+		return ""
+	}
+	return "Approved-by: " + strings.Join(change.Approvers, ", ")
+}
+
+// approvalView is a pending deletion as served by the status API
+type approvalView struct {
+	Repository string    `json:"repository"`
+	Path       string    `json:"path"`
+	Stack      string    `json:"stack"`
+	Since      time.Time `json:"since"`
+	Approvers  []string  `json:"approvers"`
+	Required   int       `json:"required"`
+}
+
+// pendingApprovals returns the deletions waiting for approval in every
+// repository, the one of the repo query parameter if set
+func pendingApprovals(repoPath string) []approvalView {
+	approvalsMu.Lock()
+	defer approvalsMu.Unlock()
+
+	views := []approvalView{}
+	for _, w := range statusRepos {
+		if repoPath != "" && w.path != repoPath {
+			continue
+		}
+		_, pending, err := loadApprovals(w.repo)
+		if err != nil {
+			continue
+		}
+		for path, deletion := range pending {
+			views = append(views, approvalView{
+				Repository: w.path,
+				Path:       path,
+				Stack:      deletion.Stack,
+				Since:      deletion.Since,
+				Approvers:  deletion.Approvers,
+				Required:   approveDeletionsFlag,
+			})
+		}
+	}
+	slices.SortFunc(views, func(a, b approvalView) int {
+		return strings.Compare(a.Repository+"\x00"+a.Path, b.Repository+"\x00"+b.Path)
+	})
+	return views
+}
+
+// handleApprovals serves the deletions waiting for approval
+func handleApprovals(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(pendingApprovals(r.URL.Query().Get("repo")))
+}
+
+// handleApprove records the approval of a pending deletion, ?stack= being its
+// stack or compose file, by the approver of the bearer token. The deletion is
+// committed by the next cycle once enough approvers approved it.
+func handleApprove(rw http.ResponseWriter, r *http.Request) {
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	name, ok := approverOf(token)
+	if !ok {
+		rw.Header().Set("WWW-Authenticate", `Bearer realm="git-stack-watch"`)
+		http.Error(rw, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	stack := r.URL.Query().Get("stack")
+	repoPath := r.URL.Query().Get("repo")
+	if stack == "" {
+		http.Error(rw, "stack is required", http.StatusBadRequest)
+		return
+	}
+
+	approvalsMu.Lock()
+	defer approvalsMu.Unlock()
+
+	var matches []approvalView
+	for _, w := range statusRepos {
+		if repoPath != "" && w.path != repoPath {
+			continue
+		}
+		store, pending, err := loadApprovals(w.repo)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for path, deletion := range pending {
+			if deletion.Stack != stack && path != stack {
+				continue
+			}
+			if !slices.Contains(deletion.Approvers, name) {
+				deletion.Approvers = append(deletion.Approvers, name)
+				pending[path] = deletion
+				if err := store.save(approvalsKey, pending); err != nil {
+					http.Error(rw, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				withScope(eventLog, logScope{repo: w.label}).Info("Deletion approved", "stack", deletion.Stack, "path", path, "approver", name,
+					"approvals", len(deletion.Approvers), "required", approveDeletionsFlag)
+			}
+			matches = append(matches, approvalView{
+				Repository: w.path,
+				Path:       path,
+				Stack:      deletion.Stack,
+				Since:      deletion.Since,
+				Approvers:  deletion.Approvers,
+				Required:   approveDeletionsFlag,
+			})
+		}
+	}
+
+	if len(matches) == 0 {
+		http.Error(rw, "no deletion of this stack is waiting for approval", http.StatusNotFound)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(matches)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v6"
+)
+
+var (
+	archiveRemoveFlag bool
+	archiveReasonFlag string
+)
+
+func archiveFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&archiveRemoveFlag, "remove", false, "Remove the stack instead of moving it to --archive-dir")
+	fs.StringVar(&archiveReasonFlag, "reason", "", "Why the stack is retired, recorded in the commit message")
+}
+
+// isArchived reports whether a repository path is inside --archive-dir,
+// which the watcher leaves alone
+func isArchived(filePath string) bool {
+	return archiveDirFlag != "" && strings.HasPrefix(filePath, archiveDirFlag+"/")
+}
+
+// stackDirectory returns the directory of the stack with the given name
+// among the compose files tracked in the index
+func stackDirectory(repo *git.Repository, stack string) (string, error) {
+	idx, err := repo.Storer.Index()
+	if err != nil {
+		return "", err
+	}
+
+	var dirs []string
+	for _, entry := range idx.Entries {
+		if isWatchedFile(entry.Name) && !isArchived(entry.Name) && getStackName(entry.Name) == stack {
+			dirs = append(dirs, path.Dir(entry.Name))
+		}
+	}
+
+	switch {
+	case len(dirs) == 0:
+		return "", fmt.Errorf("no tracked stack named %s", stack)
+	case len(dirs) > 1:
+		return "", fmt.Errorf("stack name %s is ambiguous: %s", stack, strings.Join(dirs, ", "))
+	case dirs[0] == ".":
+		return "", errors.New("the root stack can't be archived")
+	}
+	return dirs[0], nil
+}
+
+// runArchive retires a stack: its directory is moved to --archive-dir, or
+// removed with --remove, in a single commit carrying the reason
+func runArchive(repo *git.Repository, args []string) error {
+	if len(args) != 1 {
+		return errors.New("expected a stack name")
+	}
+	stack := args[0]
+
+	dir, err := stackDirectory(repo, stack)
+	if err != nil {
+		return err
+	}
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return fmt.Errorf("failed to get worktree: %w", err)
+	}
+	root := worktree.Filesystem.Root()
+
+	idx, err := repo.Storer.Index()
+	if err != nil {
+		return err
+	}
+	var tracked []string
+	for _, entry := range idx.Entries {
+		if strings.HasPrefix(entry.Name, dir+"/") {
+			tracked = append(tracked, entry.Name)
+		}
+	}
+
+	target := path.Join(archiveDirFlag, dir)
+	if archiveRemoveFlag {
+		if err := os.RemoveAll(filepath.Join(root, filepath.FromSlash(dir))); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", dir, err)
+		}
+	} else {
+		if _, err := os.Stat(filepath.Join(root, filepath.FromSlash(target))); err == nil {
+			return fmt.Errorf("%s is already archived", target)
+		}
+		if err := os.MkdirAll(filepath.Join(root, filepath.FromSlash(path.Dir(target))), 0o755); err != nil {
+			return err
+		}
+		if err := os.Rename(filepath.Join(root, filepath.FromSlash(dir)), filepath.Join(root, filepath.FromSlash(target))); err != nil {
+			return fmt.Errorf("failed to move %s: %w", dir, err)
+		}
+	}
+
+	for _, file := range tracked {
+		if _, err := worktree.Remove(file); err != nil {
+			return fmt.Errorf("failed to unstage %s: %w", file, err)
+		}
+		if !archiveRemoveFlag {
+			if _, err := worktree.Add(path.Join(archiveDirFlag, file)); err != nil {
+				return fmt.Errorf("failed to add %s: %w", file, err)
+			}
+		}
+	}
+
+	change := Change{StackName: stack, FilePath: dir, ChangeType: Archived, Class: ClassDeployment}
+	message := commitSubject(change)
+	if archiveReasonFlag != "" {
+		message += "\n\n" + archiveReasonFlag
+	}
+	commit, err := worktree.Commit(withTrailers(message, ownTrailer(change)), commitOptions())
+	if err != nil {
+		return fmt.Errorf("failed to commit: %w", err)
+	}
+
+	if archiveRemoveFlag {
+		fmt.Printf("Removed stack %s (%s)\n", stack, commit.String()[:7])
+	} else {
+		fmt.Printf("Archived stack %s to %s (%s)\n", stack, target, commit.String()[:7])
+	}
+
+	if pushFlag {
+		return pushToRemote(repo)
+	}
+	return nil
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing/transport"
+	githttp "github.com/go-git/go-git/v6/plumbing/transport/http"
+	"github.com/go-git/go-git/v6/plumbing/transport/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// authProviders creates the credentials of each --auth method for a remote
+// URL. "none" talks to the remote without credentials.
+var authProviders = map[string]func(url string) (transport.AuthMethod, error){
+	"none":  func(string) (transport.AuthMethod, error) { return nil, nil },
+	"ssh":   func(string) (transport.AuthMethod, error) { return sshKeyAuth() },
+	"http":  func(string) (transport.AuthMethod, error) { return httpBasicAuth() },
+	"token": httpTokenAuth,
+}
+
+// authMethods returns the --auth methods in the order they are tried
+func authMethods() []string {
+	var chain []string
+	for _, method := range strings.Split(authMethodFlag, ",") {
+		if method = strings.TrimSpace(method); method != "" {
+			chain = append(chain, method)
+		}
+	}
+	return chain
+}
+
+// authChain returns the auth methods tried for a remote URL: the --auth
+// methods, or the one matching its scheme
+func authChain(url string) []string {
+	if chain := authMethods(); len(chain) > 0 {
+		return chain
+	}
+	return []string{autoAuthMethod(url)}
+}
+
+// autoAuthMethod picks the auth method of a remote URL: SSH keys for SSH
+// remotes, the token or basic auth credentials given for HTTP(S) remotes,
+// and none for local paths
+func autoAuthMethod(url string) string {
+	switch {
+	case strings.HasPrefix(url, "ssh://"), !strings.Contains(url, "://") && strings.Contains(url, "@") && strings.Contains(url, ":"):
+		return "ssh"
+	case strings.HasPrefix(url, "https://"), strings.HasPrefix(url, "http://"):
+		if secretValue(httpsTokenFlag, "GIT_TOKEN") != "" {
+			return "token"
+		}
+		if httpUserFlag != "" {
+			return "http"
+		}
+	}
+	return "none"
+}
+
+// remoteURL returns the first URL of the repository's origin remote, or ""
+func remoteURL(repo *git.Repository) string {
+	if repo == nil {
+		return ""
+	}
+	remote, err := repo.Remote(git.DefaultRemoteName)
+	if err != nil || len(remote.Config().URLs) == 0 {
+		return ""
+	}
+	return remote.Config().URLs[0]
+}
+
+// configuredSSHKeyPath returns the SSH key given with --ssh-key or
+// SSHKEY_PATH, or ""
+func configuredSSHKeyPath() string {
+	if sshKeyFlag != "" {
+		return sshKeyFlag
+	}
+	return os.Getenv("SSHKEY_PATH")
+}
+
+// sshKeyPath returns the SSH key used to push, /root/.ssh/id_ed25519 unless
+// configured
+func sshKeyPath() string {
+	if keypath := configuredSSHKeyPath(); keypath != "" {
+		return keypath
+	}
+	return "/root/.ssh/id_ed25519"
+}
+
+// sshKeyPassphrase returns the passphrase of the SSH keys, read from
+// --ssh-key-passphrase-file or the SSHKEY_PASSPHRASE env var
+func sshKeyPassphrase() (string, error) {
+	if sshKeyPassphraseFileFlag != "" {
+		data, err := os.ReadFile(sshKeyPassphraseFileFlag)
+		if err != nil {
+			return "", fmt.Errorf("failed to read the SSH key passphrase: %w", err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+	return os.Getenv("SSHKEY_PASSPHRASE"), nil
+}
+
+// readSSHKey reads an SSH private key file, decrypting it with the
+// configured passphrase when it's protected
+func readSSHKey(path string) (gossh.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := gossh.ParsePrivateKey(data)
+	var missing *gossh.PassphraseMissingError
+	if !errors.As(err, &missing) {
+		return signer, err
+	}
+
+	passphrase, err := sshKeyPassphrase()
+	if err != nil {
+		return nil, err
+	}
+	if passphrase == "" {
+		return nil, fmt.Errorf("%s is protected by a passphrase, set SSHKEY_PASSPHRASE or --ssh-key-passphrase-file", path)
+	}
+	signer, err = gossh.ParsePrivateKeyWithPassphrase(data, []byte(passphrase))
+	if errors.Is(err, x509.IncorrectPasswordError) {
+		return nil, fmt.Errorf("wrong passphrase for %s", path)
+	}
+	return signer, err
+}
+
+// sshKeyAuth authenticates with the keys of the SSH agent at SSH_AUTH_SOCK,
+// if any, then with the SSH private key file
+func sshKeyAuth() (transport.AuthMethod, error) {
+	var keyAuth *ssh.PublicKeys
+	signer, keyErr := readSSHKey(sshkeyPath)
+	if keyErr == nil {
+		keyAuth = &ssh.PublicKeys{User: "git", Signer: signer}
+	}
+	if os.Getenv("SSH_AUTH_SOCK") == "" {
+		if keyErr != nil {
+			return nil, fmt.Errorf("%w: failed to create SSH auth: %w", errAuth, keyErr)
+		}
+		return keyAuth, nil
+	}
+
+	agentAuth, agentErr := ssh.NewSSHAgentAuth("git")
+	if agentErr != nil && keyErr != nil {
+		return nil, fmt.Errorf("%w: failed to create SSH auth: %w", errAuth, errors.Join(agentErr, keyErr))
+	}
+
+	return &ssh.PublicKeysCallback{
+		User: "git",
+		Callback: func() ([]gossh.Signer, error) {
+			var signers []gossh.Signer
+			if agentAuth != nil {
+				agentSigners, err := agentAuth.Callback()
+				if err != nil {
+					opsLog.Warn("Failed to list the SSH agent's keys", "error", err)
+				}
+				signers = append(signers, agentSigners...)
+			}
+			if keyAuth != nil {
+				signers = append(signers, keyAuth.Signer)
+			}
+			return signers, nil
+		},
+	}, nil
+}
+
+// httpBasicAuth authenticates to HTTP(S) remotes with --http-user and the
+// password from --http-password or GIT_HTTP_PASSWORD
+func httpBasicAuth() (transport.AuthMethod, error) {
+	if httpUserFlag == "" {
+		return nil, fmt.Errorf("%w: --http-user is required for HTTP auth", errAuth)
+	}
+	return &githttp.BasicAuth{Username: httpUserFlag, Password: secretValue(httpPasswordFlag, "GIT_HTTP_PASSWORD")}, nil
+}
+
+// httpTokenAuth authenticates to an HTTPS remote with the access token from
+// --https-token or GIT_TOKEN, sent as the basic auth password of the user
+// tokenUser picks for the remote
+func httpTokenAuth(url string) (transport.AuthMethod, error) {
+	token := secretValue(httpsTokenFlag, "GIT_TOKEN")
+	if token == "" {
+		return nil, fmt.Errorf("%w: --https-token or GIT_TOKEN is required for token auth", errAuth)
+	}
+	return &githttp.BasicAuth{Username: tokenUser(url), Password: token}, nil
+}
+
+// tokenUser returns the username sent with the access token to a remote:
+// --http-user, or the one its provider expects. GitHub, GitLab and Gitea
+// accept x-access-token, Bitbucket repository access tokens need
+// x-token-auth, and Azure DevOps takes personal access tokens with an empty
+// user.
+func tokenUser(url string) string {
+	if httpUserFlag != "" {
+		return httpUserFlag
+	}
+	switch urlProvider(url) {
+	case providerAzure:
+		return ""
+	case providerBitbucket:
+		return "x-token-auth"
+	default:
+		return "x-access-token"
+	}
+}
+
+// secretValue returns a secret given as a flag, falling back to an env var
+// so it doesn't have to appear on the command line
+func secretValue(flagValue string, envName string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+	return os.Getenv(envName)
+}
+
+// proxyAuth adds the credentials of a basic auth reverse proxy to HTTP
+// requests, on top of the git credentials if any
+type proxyAuth struct {
+	git    githttp.AuthMethod
+	header string
+	value  string
+}
+
+// withProxyAuth wraps HTTP (or missing) credentials with the reverse proxy
+// credentials when --proxy-user is set. SSH credentials are left untouched.
+func withProxyAuth(auth transport.AuthMethod) transport.AuthMethod {
+	if proxyUserFlag == "" {
+		return auth
+	}
+
+	var git githttp.AuthMethod
+	if auth != nil {
+		var ok bool
+		if git, ok = auth.(githttp.AuthMethod); !ok {
+			return auth
+		}
+	}
+
+	credentials := proxyUserFlag + ":" + secretValue(proxyPasswordFlag, "PROXY_PASSWORD")
+	return &proxyAuth{
+		git:    git,
+		header: proxyAuthHeaderFlag,
+		value:  "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials)),
+	}
+}
+
+func (a *proxyAuth) SetAuth(r *http.Request) {
+	if a.git != nil {
+		a.git.SetAuth(r)
+	}
+	r.Header.Set(a.header, a.value)
+}
+
+func (a *proxyAuth) Name() string {
+	return "http-proxy-auth"
+}
+
+func (a *proxyAuth) String() string {
+	if a.git == nil {
+		return fmt.Sprintf("%s - proxy %s: %s:*******", a.Name(), a.header, proxyUserFlag)
+	}
+	return fmt.Sprintf("%s - proxy %s: %s:*******, git: %s", a.Name(), a.header, proxyUserFlag, a.git)
+}
+
+// withAuth runs a remote operation with each method of the auth chain in
+// turn, moving on to the next method only when credentials were the problem
+func withAuth(repo *git.Repository, op func(auth transport.AuthMethod) error) error {
+	return withURLAuth(remoteURL(repo), op)
+}
+
+// withURLAuth is withAuth for a remote URL, before there is a repository
+func withURLAuth(url string, op func(auth transport.AuthMethod) error) error {
+	chain := authChain(url)
+
+	var err error
+	for i, method := range chain {
+		opsLog.Debug("Trying auth method", "method", method, "remote", redact(url, nil), "chain", strings.Join(chain, ","), "auto", len(authMethods()) == 0)
+		var auth transport.AuthMethod
+		auth, err = authProviders[method](url)
+		if err == nil {
+			err = op(withProxyAuth(auth))
+		}
+		if err == nil || !isAuthError(err) {
+			return err
+		}
+		if i < len(chain)-1 {
+			opsLog.Warn("Auth method failed, trying the next one", "method", method, "next", chain[i+1], "error", err)
+		}
+	}
+	return err
+}
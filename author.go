@@ -0,0 +1,30 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing/object"
+)
+
+// commitOptions returns the options of the watcher's commits, authored and
+// committed by --author-name and --author-email when set, and by the git
+// configuration otherwise, signed when a signing key is set up
+func commitOptions() *git.CommitOptions {
+	options := &git.CommitOptions{SignKey: signKey, Signer: commitSigner}
+	if authorNameFlag != "" {
+		signature := &object.Signature{Name: authorNameFlag, Email: authorEmailFlag, When: time.Now()}
+		options.Author, options.Committer = signature, signature
+	}
+	return options
+}
+
+// checkAuthor validates that --author-name and --author-email are set
+// together
+func checkAuthor() error {
+	if (authorNameFlag == "") != (authorEmailFlag == "") {
+		return errors.New("--author-name and --author-email must be set together")
+	}
+	return nil
+}
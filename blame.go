@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/go-git/go-git/v6"
+)
+
+// trailerPattern matches a git trailer line such as "Run-Id: 1a2b3c"
+var trailerPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9-]*: .+$`)
+
+// commitTrailers returns the trailer lines of the last paragraph of a commit
+// message, which carry provenance metadata
+func commitTrailers(message string) []string {
+	paragraphs := strings.Split(strings.TrimSpace(message), "\n\n")
+	if len(paragraphs) < 2 {
+		return nil
+	}
+
+	var trailers []string
+	for _, line := range strings.Split(paragraphs[len(paragraphs)-1], "\n") {
+		if !trailerPattern.MatchString(line) {
+			return nil
+		}
+		trailers = append(trailers, line)
+	}
+	return trailers
+}
+
+// runBlame reports the commits that changed a service's definition in a
+// isStackCompose reports whether a repository path is the compose file of a
+// stack under any of its names. A compose file is owned by the stack of its
+// own directory, not by the stacks of the directories above it.
+func isStackCompose(filePath string, names []string) bool {
+	return isComposeFile(filePath) && slices.Contains(names, getStackName(filePath))
+}
+
+// stack's compose file, newest first
+func runBlame(repo *git.Repository, args []string) error {
+	if len(args) != 2 {
+		return errors.New("expected a stack name and a service name")
+	}
+	stack, service := args[0], args[1]
+	names := loadAliases(repo).names(stack)
+
+	head, err := repo.Head()
+	if err != nil {
+		return fmt.Errorf("failed to resolve HEAD: %w", err)
+	}
+
+	commits, err := repo.Log(&git.LogOptions{From: head.Hash()})
+	if err != nil {
+		return err
+	}
+	defer commits.Close()
+
+	found := 0
+walk:
+	for {
+		commit, err := commits.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			if historyTruncated(repo, err) {
+				break walk
+			}
+			return err
+		}
+
+		changes, err := commitChanges(commit)
+		if err != nil {
+			if historyTruncated(repo, err) {
+				break walk
+			}
+			return err
+		}
+
+		for _, change := range changes {
+			filePath := changeName(change)
+			if !isStackCompose(filePath, names) {
+				continue
+			}
+
+			before, after, err := changeContents(change)
+			if err != nil {
+				if historyTruncated(repo, err) {
+					break walk
+				}
+				return err
+			}
+
+			// Unparsable versions count as having no services
+			oldServices, _ := serviceDefinitions(before)
+			newServices, _ := serviceDefinitions(after)
+			old, hadService := oldServices[service]
+			cur, hasService := newServices[service]
+
+			var what string
+			switch {
+			case !hadService && hasService:
+				what = "added"
+			case hadService && !hasService:
+				what = "removed"
+			case hadService && !reflect.DeepEqual(old, cur):
+				what = "changed"
+			default:
+				continue
+			}
+
+			subject, _, _ := strings.Cut(commit.Message, "\n")
+			fmt.Printf("%s %s %s  %s (%s in %s)\n", commit.Hash.String()[:7], commit.Author.When.Format("2006-01-02 15:04"), commit.Author.Name, subject, what, filePath)
+			for _, trailer := range commitTrailers(commit.Message) {
+				fmt.Printf("    %s\n", trailer)
+			}
+			found++
+		}
+	}
+
+	if found == 0 {
+		fmt.Printf("No commits changed service %s of stack %s\n", service, stack)
+	}
+	return nil
+}
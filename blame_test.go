@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestIsStackCompose(t *testing.T) {
+	tests := []struct {
+		filePath string
+		names    []string
+		want     bool
+	}{
+		{"web/compose.yml", []string{"web"}, true},
+		{"a/web/compose.yml", []string{"web"}, true},
+		{"a/web/compose.yml", []string{"a"}, false},
+		{"web/compose.yml", []string{"a"}, false},
+		{"a/compose.yml", []string{"a"}, true},
+		{"a/web/compose.yml", []string{"frontend", "web"}, true},
+		{"web/.env", []string{"web"}, false},
+		{"compose.yml", []string{"root"}, true},
+	}
+
+	for _, tt := range tests {
+		if got := isStackCompose(tt.filePath, tt.names); got != tt.want {
+			t.Errorf("isStackCompose(%q, %v) = %v, want %v", tt.filePath, tt.names, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/config"
+	"github.com/go-git/go-git/v6/plumbing"
+)
+
+// headBranch names the branch HEAD is on, or "detached HEAD"
+func headBranch(repo *git.Repository) (string, error) {
+	head, err := repo.Reference(plumbing.HEAD, false)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve HEAD: %w", err)
+	}
+	if head.Type() != plumbing.SymbolicReference {
+		return "detached HEAD", nil
+	}
+	return head.Target().Short(), nil
+}
+
+// checkoutBranch checks out --branch, keeping the uncommitted changes of the
+// worktree so that they're committed to it. A missing branch is created from
+// its remote branch, or from HEAD for a new one.
+func checkoutBranch(repo *git.Repository) error {
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return fmt.Errorf("failed to get worktree: %w", err)
+	}
+
+	name := plumbing.NewBranchReferenceName(branchFlag)
+	options := &git.CheckoutOptions{Branch: name, Keep: true}
+	if _, err := repo.Reference(name, false); errors.Is(err, plumbing.ErrReferenceNotFound) {
+		options.Create = true
+		start, err := repo.Reference(plumbing.NewRemoteReferenceName(git.DefaultRemoteName, branchFlag), true)
+		if err != nil {
+			start, err = repo.Head()
+		}
+		if err != nil {
+			return fmt.Errorf("failed to resolve HEAD: %w", err)
+		}
+		options.Hash = start.Hash()
+	} else if err != nil {
+		return err
+	}
+
+	if err := worktree.Checkout(options); err != nil {
+		return err
+	}
+	if options.Create {
+		// Let 'git pull' and 'git status' track the remote branch
+		err := repo.CreateBranch(&config.Branch{Name: branchFlag, Remote: git.DefaultRemoteName, Merge: name})
+		if err != nil && !errors.Is(err, git.ErrBranchExists) {
+			return err
+		}
+	}
+	eventLog.Info("✓ Checked out the target branch", "branch", branchFlag, "created", options.Create)
+	return nil
+}
+
+// onTargetBranch reports whether the cycle may commit: HEAD is on --branch,
+// or was just switched to it with --checkout-branch. Commits never land on
+// another branch, such as a feature branch checked out by hand.
+func onTargetBranch(repo *git.Repository) bool {
+	if branchFlag == "" {
+		return true
+	}
+
+	branch, err := headBranch(repo)
+	if err != nil {
+		opsLog.Error("Failed to read the checked out branch", "error", err)
+		return false
+	}
+	if branch == branchFlag {
+		return true
+	}
+
+	if !checkoutBranchFlag {
+		opsLog.Error("Not on the target branch, skipping cycle", "branch", branch, "expected", branchFlag)
+		return false
+	}
+	if dryRunFlag {
+		opsLog.Info("Dry run, not checking out the target branch", "branch", branch, "expected", branchFlag)
+		return true
+	}
+	if err := checkoutBranch(repo); err != nil {
+		opsLog.Error("Failed to check out the target branch, skipping cycle", "branch", branch, "expected", branchFlag, "error", err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"io"
+	"time"
+
+	"github.com/go-git/go-billy/v6"
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing/cache"
+	"github.com/go-git/go-git/v6/storage/filesystem"
+)
+
+// tuneStorage reopens a repository's storage with the --object-cache-size
+// cache and the --large-object-threshold limit
+func tuneStorage(repo *git.Repository) (*git.Repository, error) {
+	storage, ok := repo.Storer.(*filesystem.Storage)
+	if !ok {
+		return repo, nil
+	}
+
+	var worktreeFs billy.Filesystem
+	if worktree, err := repo.Worktree(); err == nil {
+		worktreeFs = worktree.Filesystem
+	}
+
+	tuned := filesystem.NewStorageWithOptions(
+		storage.Filesystem(),
+		cache.NewObjectLRU(cache.FileSize(objectCacheSizeFlag)),
+		filesystem.Options{LargeObjectThreshold: int64(largeObjectThresholdFlag)},
+	)
+	return git.Open(tuned, worktreeFs)
+}
+
+// closeRepository releases the packfiles and caches held by a repository
+func closeRepository(repo *git.Repository) error {
+	if closer, ok := repo.Storer.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// reopenIfDue reopens the repository once it has been open for
+// --reopen-interval, dropping everything go-git accumulated in memory
+func (w *watchedRepo) reopenIfDue() {
+	if reopenIntervalFlag <= 0 || time.Since(w.openedAt) < reopenIntervalFlag {
+		return
+	}
+
+	repo, err := openRepository(w.path)
+	if err != nil {
+		opsLog.Warn("Failed to reopen repository, keeping the open one", "error", err)
+		return
+	}
+	approvalsMu.Lock()
+	if err := closeRepository(w.repo); err != nil {
+		opsLog.Debug("Failed to close repository", "error", err)
+	}
+	w.repo, w.openedAt = repo, time.Now()
+	approvalsMu.Unlock()
+	opsLog.Debug("Reopened repository to release its caches")
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/go-git/go-git/v6"
+)
+
+// Change classes, from least to most impactful
+const (
+	ClassCosmetic      = "cosmetic"
+	ClassConfiguration = "configuration"
+	ClassDeployment    = "deployment"
+)
+
+// deploymentKeys are the service keys whose change redeploys the service
+var deploymentKeys = []string{"image", "build"}
+
+// classifyContents compares two versions of a compose file: cosmetic when
+// they parse to the same content (comments, whitespace, key order),
+// deployment when a service was added or removed or its image or build
+// changed, configuration otherwise (environment, ports, volumes...)
+func classifyContents(before, after []byte) string {
+	normalizedBefore, errBefore := normalizeYAML(before)
+	normalizedAfter, errAfter := normalizeYAML(after)
+	if errBefore == nil && errAfter == nil && bytes.Equal(normalizedBefore, normalizedAfter) {
+		return ClassCosmetic
+	}
+
+	// Unparsable versions count as having no services
+	oldServices, _ := serviceDefinitions(before)
+	newServices, _ := serviceDefinitions(after)
+	for name := range oldServices {
+		if _, ok := newServices[name]; !ok {
+			return ClassDeployment
+		}
+	}
+	for name, cur := range newServices {
+		old, ok := oldServices[name]
+		if !ok {
+			return ClassDeployment
+		}
+		for _, key := range deploymentKeys {
+			if !reflect.DeepEqual(serviceField(old, key), serviceField(cur, key)) {
+				return ClassDeployment
+			}
+		}
+	}
+
+	return ClassConfiguration
+}
+
+// serviceField returns a top-level key of a service definition, nil when the
+// definition isn't a mapping or lacks the key
+func serviceField(definition any, key string) any {
+	fields, ok := definition.(map[string]any)
+	if !ok {
+		return nil
+	}
+	return fields[key]
+}
+
+// classifyChange returns the class of a stack change. Created and deleted
+// stacks are deployments, as are changes whose versions can't be read.
+// Changes to files other than compose files are configuration, as are
+// changes to the other files of a stack directory.
+func classifyChange(repo *git.Repository, repoPath string, change Change) string {
+	if change.ChangeType != Updated {
+		return ClassDeployment
+	}
+	if !isComposeFile(change.FilePath) {
+		return ClassConfiguration
+	}
+
+	before, err := headFileContents(repo, change.FilePath)
+	if err != nil {
+		return ClassDeployment
+	}
+	after, err := readWorktreeFile(repoPath, change.FilePath)
+	if err != nil {
+		return ClassDeployment
+	}
+
+	// The stack and included files committed along aren't cosmetic
+	class := classifyContents(before, after)
+	if class == ClassCosmetic && (len(change.Extras) > 0 || len(change.Included) > 0) {
+		return ClassConfiguration
+	}
+	return class
+}
+
+// skippedClasses returns the classes listed in --skip-classes
+func skippedClasses() map[string]bool {
+	classes := map[string]bool{}
+	for _, class := range strings.Split(skipClassesFlag, ",") {
+		if class = strings.TrimSpace(class); class != "" {
+			classes[class] = true
+		}
+	}
+	return classes
+}
+
+// parseClasses validates the classes listed in --skip-classes
+func parseClasses() error {
+	for class := range skippedClasses() {
+		switch class {
+		case ClassCosmetic, ClassConfiguration, ClassDeployment:
+		default:
+			return fmt.Errorf("unknown class %q, expected '%s', '%s' or '%s'", class, ClassCosmetic, ClassConfiguration, ClassDeployment)
+		}
+	}
+	return nil
+}
+
+// classifyChanges sets the class of each change and drops those of the
+// classes listed in --skip-classes
+func classifyChanges(repo *git.Repository, repoPath string, changes []Change) []Change {
+	skipped := skippedClasses()
+
+	var kept []Change
+	for _, change := range changes {
+		change.Class = classifyChange(repo, repoPath, change)
+		if skipped[change.Class] {
+			eventLog.Info("Ignoring change of a skipped class", "stack", change.StackName, "path", change.FilePath, "class", change.Class)
+			continue
+		}
+		kept = append(kept, change)
+	}
+	return kept
+}
@@ -0,0 +1,262 @@
+// Package client calls the HTTP API of a running git-stack-watch, as
+// described by the OpenAPI document it serves on /openapi.json.
+//
+// The endpoints are served on up to three addresses: --status-addr for the
+// status, the logs, the approvals and the document, --metrics-addr for the metrics and
+// --health-addr for the health checks. A Client talks to one of them, so
+// reaching all three takes a Client each.
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client calls the endpoints served on one address of a watcher
+type Client struct {
+	// BaseURL is the URL of the address, such as http://nas:8080
+	BaseURL string
+
+	// Token is sent as a bearer token when set: the watcher's --api-token,
+	// or --api-viewer-token since every endpoint but Approve only reads.
+	// Approve takes the token of one of the watcher's --approvers.
+	Token string
+
+	// HTTPClient sends the requests, http.DefaultClient when nil. Set one
+	// with a TLS configuration for watchers set up with --api-client-ca.
+	HTTPClient *http.Client
+}
+
+// New returns a client of the endpoints served on baseURL, sending token
+// when it isn't empty
+func New(baseURL string, token string) *Client {
+	return &Client{BaseURL: baseURL, Token: token}
+}
+
+// Error is an answer of the watcher other than 200 OK
+type Error struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Body)
+}
+
+// Status is the document of GET /status
+type Status struct {
+	Started      time.Time    `json:"started"`
+	Interval     string       `json:"interval"`
+	Push         bool         `json:"push"`
+	Repositories []Repository `json:"repositories"`
+	Remote       Remote       `json:"remote"`
+}
+
+// Repository is the state of a watched repository as of its last cycle
+type Repository struct {
+	Path         string    `json:"path"`
+	LastCycle    time.Time `json:"last_cycle"`
+	PushFailures int       `json:"push_failures"`
+	PushPending  bool      `json:"push_pending"`
+	Diverged     bool      `json:"diverged"`
+	Corrupted    bool      `json:"corrupted"`
+}
+
+// Remote is the outcome of the last remote probe, zero when the remote was
+// never probed
+type Remote struct {
+	Probed    time.Time `json:"probed"`
+	Reachable bool      `json:"reachable"`
+	LatencyMs int64     `json:"latency_ms"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Health is the document of GET /healthz and GET /readyz
+type Health struct {
+	Status       string             `json:"status"`
+	Reason       string             `json:"reason,omitempty"`
+	Repositories []RepositoryHealth `json:"repositories"`
+}
+
+// Approval is a stack deletion waiting for its approvers
+type Approval struct {
+	Repository string    `json:"repository"`
+	Path       string    `json:"path"`
+	Stack      string    `json:"stack"`
+	Since      time.Time `json:"since"`
+	Approvers  []string  `json:"approvers"`
+	Required   int       `json:"required"`
+}
+
+// RepositoryHealth is the health of a watched repository
+type RepositoryHealth struct {
+	Path       string    `json:"path"`
+	Open       bool      `json:"open"`
+	LastCycle  time.Time `json:"last_cycle,omitzero"`
+	LastPushOK bool      `json:"last_push_ok"`
+}
+
+// do sends a request for path and returns the response, which the caller
+// closes, checking its status against the accepted ones
+func (c *Client) do(ctx context.Context, method string, path string, accepted ...int) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(c.BaseURL, "/")+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, status := range append([]int{http.StatusOK}, accepted...) {
+		if resp.StatusCode == status {
+			return resp, nil
+		}
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return nil, &Error{StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(body))}
+}
+
+// getJSON decodes the JSON document of path into v
+func (c *Client) getJSON(ctx context.Context, path string, v any, accepted ...int) (int, error) {
+	return c.doJSON(ctx, http.MethodGet, path, v, accepted...)
+}
+
+// doJSON sends a request for path and decodes the JSON document answered
+// into v
+func (c *Client) doJSON(ctx context.Context, method string, path string, v any, accepted ...int) (int, error) {
+	resp, err := c.do(ctx, method, path, accepted...)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return 0, fmt.Errorf("%s: %w", path, err)
+	}
+	return resp.StatusCode, nil
+}
+
+// getText returns the text document of path
+func (c *Client) getText(ctx context.Context, path string) (string, error) {
+	resp, err := c.do(ctx, http.MethodGet, path)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return string(body), err
+}
+
+// Status returns the state of every repository and the last remote probe
+func (c *Client) Status(ctx context.Context) (*Status, error) {
+	var status Status
+	if _, err := c.getJSON(ctx, "/status", &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// Logs returns the last lines of the logs kept by the watcher, all of them
+// when lines is 0
+func (c *Client) Logs(ctx context.Context, lines int) ([]string, error) {
+	path := "/logs"
+	if lines > 0 {
+		path += "?lines=" + strconv.Itoa(lines)
+	}
+	text, err := c.getText(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	var logs []string
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		logs = append(logs, scanner.Text())
+	}
+	return logs, scanner.Err()
+}
+
+// Approvals returns the stack deletions waiting for approval, of every
+// repository or of repo if set
+func (c *Client) Approvals(ctx context.Context, repo string) ([]Approval, error) {
+	path := "/approvals"
+	if repo != "" {
+		path += "?repo=" + url.QueryEscape(repo)
+	}
+	var approvals []Approval
+	if _, err := c.getJSON(ctx, path, &approvals); err != nil {
+		return nil, err
+	}
+	return approvals, nil
+}
+
+// Approve approves the pending deletion of a stack, named or given by the
+// path of its compose file, as the approver of the client's Token. repo
+// picks the repository when the watcher watches several. It returns the
+// approved deletions with their approvers so far.
+func (c *Client) Approve(ctx context.Context, stack string, repo string) ([]Approval, error) {
+	query := url.Values{"stack": {stack}}
+	if repo != "" {
+		query.Set("repo", repo)
+	}
+	var approvals []Approval
+	if _, err := c.doJSON(ctx, http.MethodPost, "/approve?"+query.Encode(), &approvals); err != nil {
+		return nil, err
+	}
+	return approvals, nil
+}
+
+// Metrics returns the metrics in the Prometheus text format
+func (c *Client) Metrics(ctx context.Context) (string, error) {
+	return c.getText(ctx, "/metrics")
+}
+
+// health returns the report of a health endpoint, along with an error when
+// the check fails
+func (c *Client) health(ctx context.Context, path string) (*Health, error) {
+	var health Health
+	status, err := c.getJSON(ctx, path, &health, http.StatusServiceUnavailable)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return &health, &Error{StatusCode: status, Body: health.Reason}
+	}
+	return &health, nil
+}
+
+// Liveness returns the report of GET /healthz, with an *Error when a
+// repository went without a cycle for too long
+func (c *Client) Liveness(ctx context.Context) (*Health, error) {
+	return c.health(ctx, "/healthz")
+}
+
+// Readiness returns the report of GET /readyz, with an *Error when a
+// repository isn't open, had no cycle yet or failed its last push
+func (c *Client) Readiness(ctx context.Context) (*Health, error) {
+	return c.health(ctx, "/readyz")
+}
+
+// OpenAPI returns the OpenAPI document of the API
+func (c *Client) OpenAPI(ctx context.Context) ([]byte, error) {
+	text, err := c.getText(ctx, "/openapi.json")
+	return []byte(text), err
+}
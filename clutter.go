@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/go-git/go-git/v6"
+)
+
+// stackClutter is what untracked files take up in a stack directory
+type stackClutter struct {
+	files   int
+	size    byteSize
+	largest string
+	top     byteSize
+}
+
+// reportClutter warns about stack directories whose untracked files add up
+// to --clutter-size or more, such as databases and logs written next to a
+// compose file. The watcher never commits them, but a 'git add .' by hand or
+// --stack-dir would, so they're better gitignored or moved out of the
+// repository.
+func reportClutter(repo *git.Repository, repoPath string, status git.Status) {
+	dirs := stackDirs(repo, status)
+	clutter := map[string]*stackClutter{}
+	for filePath, fileStatus := range status {
+		if fileStatus.Worktree != git.Untracked || isWatchedFile(filePath) || isArchived(filePath) {
+			continue
+		}
+		dir, ok := owningStack(filePath, dirs)
+		if !ok {
+			continue
+		}
+		info, err := os.Lstat(filepath.Join(repoPath, filepath.FromSlash(filePath)))
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+
+		c := clutter[dir]
+		if c == nil {
+			c = &stackClutter{}
+			clutter[dir] = c
+		}
+		size := byteSize(info.Size())
+		c.files++
+		c.size += size
+		if size > c.top {
+			c.largest, c.top = filePath, size
+		}
+	}
+
+	reported := make([]string, 0, len(clutter))
+	for dir, c := range clutter {
+		if c.size >= clutterSizeFlag {
+			reported = append(reported, dir)
+		}
+	}
+	sort.Strings(reported)
+	for _, dir := range reported {
+		c := clutter[dir]
+		opsLog.Warn("Untracked files accumulating in stack directory, gitignore or move them", "stack", getStackName(dirs[dir]), "dir", dir, "files", c.files, "size", c.size.String(), "largest", c.largest, "largest_size", c.top.String())
+	}
+}
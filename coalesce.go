@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// pushWindow is a daily --push-window, in minutes since midnight. It wraps
+// around midnight when end is before start.
+type pushWindow struct {
+	start int
+	end   int
+}
+
+// pushWindows are the parsed --push-window values
+var pushWindows []pushWindow
+
+// parseClock parses a HH:MM time of day into minutes since midnight
+func parseClock(value string) (int, error) {
+	clock, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", value)
+	}
+	return clock.Hour()*60 + clock.Minute(), nil
+}
+
+// parsePushWindows parses the comma separated HH:MM-HH:MM windows of
+// --push-window
+func parsePushWindows() error {
+	pushWindows = nil
+	for _, item := range strings.Split(pushWindowFlag, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		from, to, ok := strings.Cut(item, "-")
+		if !ok {
+			return fmt.Errorf("invalid window %q, expected HH:MM-HH:MM", item)
+		}
+		start, err := parseClock(from)
+		if err != nil {
+			return err
+		}
+		end, err := parseClock(to)
+		if err != nil {
+			return err
+		}
+		if start == end {
+			return fmt.Errorf("window %q is empty", item)
+		}
+		pushWindows = append(pushWindows, pushWindow{start: start, end: end})
+	}
+	return nil
+}
+
+// inPushWindow reports whether pushes are allowed at a time: always without
+// --push-window, otherwise within one of the windows
+func inPushWindow(t time.Time) bool {
+	if len(pushWindows) == 0 {
+		return true
+	}
+	minute := t.Hour()*60 + t.Minute()
+	for _, window := range pushWindows {
+		if window.start < window.end && minute >= window.start && minute < window.end {
+			return true
+		}
+		if window.start > window.end && (minute >= window.start || minute < window.end) {
+			return true
+		}
+	}
+	return false
+}
+
+// pushesDeferred reports whether pushes run on their own schedule,
+// --push-interval or --push-window, instead of after every cycle
+func pushesDeferred() bool {
+	return pushFlag && (pushIntervalFlag > 0 || len(pushWindows) > 0)
+}
+
+// pushTick returns how often deferred commits are pushed: every
+// --push-interval, or as soon as a --push-window opens
+func pushTick() time.Duration {
+	if pushIntervalFlag > 0 {
+		return pushIntervalFlag
+	}
+	return time.Minute
+}
+
+// deferPush marks the repository's new commits to be pushed on the next
+// push tick, together with those of the other cycles until then
+func deferPush() {
+	if !current.pushPending {
+		opsLog.Info("Deferring push until the next scheduled push", "push_interval", pushIntervalFlag, "push_window", pushWindowFlag)
+	}
+	current.pushPending = true
+}
+
+// pushPending pushes the commits deferred since the last push, within the
+// push windows. They stay pending when the push fails, for the next tick.
+func pushPending(w *watchedRepo) {
+	if !current.pushPending {
+		opsLog.Debug("No deferred commits to push")
+		return
+	}
+	if !inPushWindow(time.Now()) {
+		opsLog.Debug("Outside of the push windows, keeping commits local", "push_window", pushWindowFlag)
+		return
+	}
+
+	err := pushToRemote(w.repo)
+	if err != nil {
+		opsLog.Error("Failed to push to remote", "error", err)
+	} else {
+		current.pushPending = false
+	}
+	recordPushResult(err)
+}
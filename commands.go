@@ -0,0 +1,167 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/go-git/go-git/v6"
+)
+
+// command is a git-stack-watch subcommand. It accepts the same options as the
+// watcher plus its own, and positional arguments after the options. Commands
+// flagged noRepo run without --repo, with a nil repository.
+type command struct {
+	usage   string
+	summary string
+	flags   func(fs *flag.FlagSet)
+	run     func(repo *git.Repository, args []string) error
+	noRepo  bool
+}
+
+var commands = map[string]command{
+	"annotate-history": {
+		usage:   "annotate-history [OPTIONS] --repo <repository-path>",
+		summary: "Add git notes summarizing the stack changes of past hand-made commits",
+		flags:   annotateFlags,
+		run:     runAnnotateHistory,
+	},
+	"archive": {
+		usage:   "archive [OPTIONS] --repo <repository-path> <stack>",
+		summary: "Move a retired stack to the archive, or remove it, in one commit",
+		flags:   archiveFlags,
+		run:     runArchive,
+	},
+	"blame": {
+		usage:   "blame [OPTIONS] --repo <repository-path> <stack> <service>",
+		summary: "Show the commits that changed a service's definition",
+		run:     runBlame,
+	},
+	"deployment": {
+		usage:   "deployment [OPTIONS] --repo <repository-path> <commit> <succeeded|failed> [details]",
+		summary: "Record the result of deploying a commit in a git note",
+		flags:   deploymentFlags,
+		run:     runDeployment,
+	},
+	"export-dashboard": {
+		usage:   "export-dashboard [OPTIONS]",
+		summary: "Write a Grafana dashboard and Prometheus alert rules for the watcher's metrics",
+		flags:   exportDashboardFlags,
+		run:     runExportDashboard,
+		noRepo:  true,
+	},
+	"import": {
+		usage:   "import [OPTIONS] --repo <repository-path>",
+		summary: "Commit every untracked compose file as a new stack, in dependency order",
+		run:     runImport,
+	},
+	"log": {
+		usage:   "log [OPTIONS] --repo <repository-path> <stack>",
+		summary: "Show the commits touching a stack, with its image and environment changes",
+		flags:   logFlags,
+		run:     runLog,
+	},
+	"migrate": {
+		usage:   "migrate [OPTIONS] --repo <repository-path> --to <other-repository-path> <stack>",
+		summary: "Move a stack to another repository, optionally with its history",
+		flags:   migrateFlags,
+		run:     runMigrate,
+	},
+	"new": {
+		usage:   "new [OPTIONS] --repo <repository-path> <stack>",
+		summary: "Scaffold a stack from a template and commit it",
+		flags:   newFlags,
+		run:     runNew,
+	},
+	"report": {
+		usage:   "report [OPTIONS] --repo <repository-path>",
+		summary: "Print per-stack change counts and image bumps over a time window",
+		flags:   reportFlags,
+		run:     runReport,
+	},
+	"selftest": {
+		usage:   "selftest [OPTIONS]",
+		summary: "Run a full detect, commit, push and verify cycle against a throwaway in-process remote",
+		run:     runSelftest,
+		noRepo:  true,
+	},
+	"simulate": {
+		usage:   "simulate [OPTIONS] --repo <repository-path> --patch <file.diff>",
+		summary: "Report how the changes of a patch would be classified, committed and gated",
+		flags:   simulateFlags,
+		run:     runSimulate,
+	},
+	"stacks": {
+		usage:   "stacks [OPTIONS] --repo <repository-path>",
+		summary: "List the stacks of the repository with their last auto-commit and drift",
+		flags:   stacksFlags,
+		run:     runStacks,
+	},
+	"status": {
+		usage:   "status [OPTIONS]",
+		summary: "Show the state and recent logs of a running watcher from its status API",
+		flags:   statusFlags,
+		run:     runStatus,
+		noRepo:  true,
+	},
+}
+
+// printCommands prints the name and summary of every subcommand
+func printCommands() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Printf("  %-17s %s\n", name, commands[name].summary)
+	}
+}
+
+// runCommand parses a subcommand's options, opens the repository and runs it
+func runCommand(name string, cmd command, args []string) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	activeFlags = fs
+	defineFlags(fs)
+	if cmd.flags != nil {
+		cmd.flags(fs)
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: git-stack-watch %s\n\n%s\n\nOptions:\n", cmd.usage, cmd.summary)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if err := loadConfig(fs); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid config file %s: %v\n", configFlag, err)
+		os.Exit(1)
+	}
+
+	if len(repoFlag) != 1 && !cmd.noRepo {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	initOptions()
+	if cmd.noRepo {
+		if err := cmd.run(nil, fs.Args()); err != nil {
+			fatal("Command failed", "command", name, "error", err)
+		}
+		return
+	}
+
+	if unshallowFlag || pushFlag {
+		setupAuth()
+	}
+
+	repo, err := openRepository(repoFlag[0])
+	if err != nil {
+		fatal("Failed to open repository", "repo", repoFlag[0], "error", err)
+	}
+	unshallowOnDemand(repo)
+
+	if err := cmd.run(repo, fs.Args()); err != nil {
+		fatal("Command failed", "command", name, "error", err)
+	}
+}
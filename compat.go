@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing/transport"
+)
+
+// Remote hosting providers with known go-git quirks
+const (
+	providerGeneric   = "generic"
+	providerAzure     = "azure"
+	providerBitbucket = "bitbucket"
+)
+
+// detectProvider guesses the hosting provider from a remote URL
+func detectProvider(url string) string {
+	url = strings.ToLower(url)
+	switch {
+	case strings.Contains(url, "dev.azure.com"), strings.Contains(url, ".visualstudio.com"):
+		return providerAzure
+	case strings.Contains(url, "bitbucket.org"), strings.Contains(url, "bitbucket"):
+		return providerBitbucket
+	default:
+		return providerGeneric
+	}
+}
+
+// remoteProvider returns the provider of the default remote, see
+// urlProvider
+func remoteProvider(repo *git.Repository) string {
+	return urlProvider(remoteURL(repo))
+}
+
+// urlProvider returns the provider of a remote URL selected by
+// --remote-compat, detecting it from the URL in 'auto' mode. The provider
+// picks the username of token auth, see tokenUser; go-git negotiates the
+// multi_ack capabilities Azure DevOps requires on its own.
+func urlProvider(url string) string {
+	if remoteCompatFlag != "auto" {
+		return remoteCompatFlag
+	}
+	return detectProvider(url)
+}
+
+// compatHint translates the obscure transport errors some providers cause
+// into actionable guidance, or returns an empty string
+func compatHint(provider string, err error) string {
+	message := strings.ToLower(err.Error())
+
+	switch provider {
+	case providerAzure:
+		switch {
+		case isAuthError(err), strings.Contains(message, "tf401019"), strings.Contains(message, "tf400813"):
+			return "Azure DevOps needs a personal access token with Code (Read & Write) scope over HTTPS, or an RSA key over SSH"
+		case strings.Contains(message, "unexpected eof"), strings.Contains(message, "status code: 400"):
+			return "Azure DevOps remotes should look like https://dev.azure.com/<org>/<project>/_git/<repo> or git@ssh.dev.azure.com:v3/<org>/<project>/<repo>"
+		}
+	case providerBitbucket:
+		switch {
+		case isAuthError(err):
+			return "Bitbucket app passwords need the Repositories: Write permission; repository access tokens use the x-token-auth username"
+		case errors.Is(err, transport.ErrRepositoryNotFound):
+			return "Bitbucket reports private repositories as not found when credentials are missing or lack access"
+		}
+	}
+
+	return ""
+}
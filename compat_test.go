@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v6/plumbing/transport"
+)
+
+func TestDetectProvider(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://dev.azure.com/org/project/_git/repo", providerAzure},
+		{"git@ssh.dev.azure.com:v3/org/project/repo", providerAzure},
+		{"https://org.visualstudio.com/project/_git/repo", providerAzure},
+		{"HTTPS://DEV.AZURE.COM/org/project/_git/repo", providerAzure},
+		{"https://bitbucket.org/team/repo.git", providerBitbucket},
+		{"git@bitbucket.org:team/repo.git", providerBitbucket},
+		{"https://bitbucket.example.com/scm/team/repo.git", providerBitbucket},
+		{"https://github.com/iwa/git-stack-watch.git", providerGeneric},
+		{"/srv/git/stacks.git", providerGeneric},
+		{"", providerGeneric},
+	}
+
+	for _, tt := range tests {
+		if got := detectProvider(tt.url); got != tt.want {
+			t.Errorf("detectProvider(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestCompatHint(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider string
+		err      error
+		want     string
+	}{
+		{"azure auth", providerAzure, transport.ErrAuthenticationRequired, "personal access token"},
+		{"azure wrapped auth", providerAzure, fmt.Errorf("push: %w", transport.ErrAuthorizationFailed), "personal access token"},
+		{"azure TF401019", providerAzure, errors.New("TF401019: The Git repository does not exist"), "personal access token"},
+		{"azure TF400813", providerAzure, errors.New("TF400813: not authorized"), "personal access token"},
+		{"azure unexpected EOF", providerAzure, errors.New("unexpected EOF"), "remotes should look like"},
+		{"azure bad request", providerAzure, errors.New("unexpected client error: unexpected requesting status code: 400"), "remotes should look like"},
+		{"azure other", providerAzure, errors.New("connection refused"), ""},
+		{"bitbucket auth", providerBitbucket, errors.New("ssh: unable to authenticate"), "app passwords"},
+		{"bitbucket not found", providerBitbucket, transport.ErrRepositoryNotFound, "not found when credentials are missing"},
+		{"bitbucket other", providerBitbucket, errors.New("unexpected EOF"), ""},
+		{"generic auth", providerGeneric, transport.ErrAuthenticationRequired, ""},
+		{"generic not found", providerGeneric, transport.ErrRepositoryNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := compatHint(tt.provider, tt.err)
+			switch {
+			case tt.want == "" && got != "":
+				t.Errorf("compatHint() = %q, want no hint", got)
+			case tt.want != "" && !strings.Contains(got, tt.want):
+				t.Errorf("compatHint() = %q, want a hint containing %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenUser(t *testing.T) {
+	tests := []struct {
+		name     string
+		compat   string
+		httpUser string
+		url      string
+		want     string
+	}{
+		{"github", "auto", "", "https://github.com/iwa/git-stack-watch.git", "x-access-token"},
+		{"azure", "auto", "", "https://dev.azure.com/org/project/_git/repo", ""},
+		{"bitbucket", "auto", "", "https://bitbucket.org/team/repo.git", "x-token-auth"},
+		{"forced azure", "azure", "", "https://git.example.com/stacks.git", ""},
+		{"forced generic", "generic", "", "https://bitbucket.org/team/repo.git", "x-access-token"},
+		{"--http-user", "auto", "deploy", "https://bitbucket.org/team/repo.git", "deploy"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compat, httpUser := remoteCompatFlag, httpUserFlag
+			remoteCompatFlag, httpUserFlag = tt.compat, tt.httpUser
+			t.Cleanup(func() { remoteCompatFlag, httpUserFlag = compat, httpUser })
+
+			if got := tokenUser(tt.url); got != tt.want {
+				t.Errorf("tokenUser(%q) = %q, want %q", tt.url, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// composeFile is the subset of a compose file the watcher understands
+type composeFile struct {
+	Services map[string]composeService `yaml:"services"`
+}
+
+// composeService is the subset of a compose service the watcher understands
+type composeService struct {
+	Image       string   `yaml:"image"`
+	Environment envVars  `yaml:"environment"`
+	Profiles    []string `yaml:"profiles"`
+}
+
+// envVars holds a service environment, written either as a mapping or as a
+// list of KEY=VALUE entries
+type envVars map[string]string
+
+func (e *envVars) UnmarshalYAML(node *yaml.Node) error {
+	vars := envVars{}
+
+	switch node.Kind {
+	case yaml.MappingNode:
+		var values map[string]any
+		if err := node.Decode(&values); err != nil {
+			return err
+		}
+		for key, value := range values {
+			if value == nil {
+				vars[key] = ""
+			} else {
+				vars[key] = fmt.Sprint(value)
+			}
+		}
+	case yaml.SequenceNode:
+		var entries []string
+		if err := node.Decode(&entries); err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			key, value, _ := strings.Cut(entry, "=")
+			vars[key] = value
+		}
+	default:
+		return fmt.Errorf("line %d: environment must be a mapping or a list", node.Line)
+	}
+
+	*e = vars
+	return nil
+}
+
+// parseCompose parses compose file content. Empty content parses to an empty
+// compose file, so added and deleted files diff against nothing.
+func parseCompose(data []byte) (*composeFile, error) {
+	compose := &composeFile{}
+	if err := decodeDocuments(data, compose); err != nil {
+		return nil, err
+	}
+	return compose, nil
+}
+
+// imageAndEnvChanges describes, one line per change, the images and
+// environment variables that differ between two versions of a compose file
+func imageAndEnvChanges(before, after *composeFile) []string {
+	var lines []string
+
+	for _, name := range serviceNames(before, after) {
+		old, hadService := before.Services[name]
+		cur, hasService := after.Services[name]
+
+		if old.Image != cur.Image {
+			switch {
+			case !hadService:
+				lines = append(lines, fmt.Sprintf("%s: image %s", name, cur.Image))
+			case !hasService:
+				lines = append(lines, fmt.Sprintf("%s: image %s removed", name, old.Image))
+			default:
+				lines = append(lines, fmt.Sprintf("%s: image %s -> %s", name, old.Image, cur.Image))
+			}
+		}
+
+		for _, key := range envKeys(old.Environment, cur.Environment) {
+			oldValue, hadKey := old.Environment[key]
+			value, hasKey := cur.Environment[key]
+			switch {
+			case !hadKey:
+				lines = append(lines, fmt.Sprintf("%s: env %s=%s", name, key, value))
+			case !hasKey:
+				lines = append(lines, fmt.Sprintf("%s: env %s removed", name, key))
+			case oldValue != value:
+				lines = append(lines, fmt.Sprintf("%s: env %s=%s -> %s", name, key, oldValue, value))
+			}
+		}
+	}
+
+	return lines
+}
+
+// serviceNames returns the sorted union of the services of two compose files
+func serviceNames(before, after *composeFile) []string {
+	seen := map[string]bool{}
+	for name := range before.Services {
+		seen[name] = true
+	}
+	for name := range after.Services {
+		seen[name] = true
+	}
+	return sortedKeys(seen)
+}
+
+// envKeys returns the sorted union of the keys of two environments
+func envKeys(before, after envVars) []string {
+	seen := map[string]bool{}
+	for key := range before {
+		seen[key] = true
+	}
+	for key := range after {
+		seen[key] = true
+	}
+	return sortedKeys(seen)
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// serviceDefinitions returns the raw definition of every service of a compose
+// file, for comparisons that must cover every key
+func serviceDefinitions(data []byte) (map[string]any, error) {
+	var compose struct {
+		Services map[string]any `yaml:"services"`
+	}
+	if err := decodeDocuments(data, &compose); err != nil {
+		return nil, err
+	}
+	return compose.Services, nil
+}
+
+// imageChange is a service whose image changed between two compose versions
+type imageChange struct {
+	Service string `json:"service"`
+	From    string `json:"from"`
+	To      string `json:"to"`
+}
+
+// imageChanges returns the services present in both versions whose image
+// changed
+func imageChanges(before, after *composeFile) []imageChange {
+	var changes []imageChange
+	for _, name := range serviceNames(before, after) {
+		old, hadService := before.Services[name]
+		cur, hasService := after.Services[name]
+		if hadService && hasService && old.Image != cur.Image {
+			changes = append(changes, imageChange{Service: name, From: old.Image, To: cur.Image})
+		}
+	}
+	return changes
+}
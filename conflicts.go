@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v6"
+)
+
+// hostPort is a port published on the host by a service
+type hostPort struct {
+	IP       string
+	Port     int
+	Protocol string
+}
+
+// overlaps reports whether two published ports would collide, ports bound to
+// all interfaces colliding with any address
+func (p hostPort) overlaps(other hostPort) bool {
+	if p.Port != other.Port || p.Protocol != other.Protocol {
+		return false
+	}
+	return p.IP == other.IP || p.IP == "" || other.IP == "" || p.IP == "0.0.0.0" || other.IP == "0.0.0.0"
+}
+
+func (p hostPort) String() string {
+	if p.IP == "" {
+		return fmt.Sprintf("%d/%s", p.Port, p.Protocol)
+	}
+	return fmt.Sprintf("%s:%d/%s", p.IP, p.Port, p.Protocol)
+}
+
+// hostClaim is a service of a compose file along with the host resources it
+// claims: published ports and a container name
+type hostClaim struct {
+	Stack         string
+	File          string
+	Service       string
+	Ports         []hostPort
+	ContainerName string
+}
+
+// parsePorts returns the host ports published by a service's 'ports' entries,
+// in short ("127.0.0.1:8080-8081:80/udp") or long syntax. Entries without a
+// host port or with unresolved variables are skipped.
+func parsePorts(entries any) []hostPort {
+	list, _ := entries.([]any)
+
+	var ports []hostPort
+	for _, entry := range list {
+		var ip, published, protocol string
+		switch entry := entry.(type) {
+		case string:
+			spec, proto, _ := strings.Cut(entry, "/")
+			protocol = proto
+			colon := strings.LastIndex(spec, ":")
+			if colon < 0 {
+				continue
+			}
+			host := spec[:colon]
+			if colon := strings.LastIndex(host, ":"); colon >= 0 {
+				ip, host = strings.Trim(host[:colon], "[]"), host[colon+1:]
+			}
+			published = host
+		case map[string]any:
+			ip = fmt.Sprint(orEmpty(entry["host_ip"]))
+			published = fmt.Sprint(orEmpty(entry["published"]))
+			protocol = fmt.Sprint(orEmpty(entry["protocol"]))
+		default:
+			continue
+		}
+		if protocol == "" {
+			protocol = "tcp"
+		}
+
+		first, last, isRange := strings.Cut(published, "-")
+		if !isRange {
+			last = first
+		}
+		from, err := strconv.Atoi(first)
+		if err != nil {
+			continue
+		}
+		to, err := strconv.Atoi(last)
+		if err != nil {
+			continue
+		}
+		for port := from; port <= to; port++ {
+			ports = append(ports, hostPort{IP: ip, Port: port, Protocol: protocol})
+		}
+	}
+	return ports
+}
+
+// orEmpty turns a missing YAML value into an empty string
+func orEmpty(value any) any {
+	if value == nil {
+		return ""
+	}
+	return value
+}
+
+// hostClaims returns the host resources claimed by the services of a compose
+// file. Unparsable files claim nothing.
+func hostClaims(filePath string, data []byte) []hostClaim {
+	services, _ := serviceDefinitions(data)
+
+	var claims []hostClaim
+	for _, name := range sortedKeys(keySet(services)) {
+		containerName, _ := serviceField(services[name], "container_name").(string)
+		claims = append(claims, hostClaim{
+			Stack:         getStackName(filePath),
+			File:          filePath,
+			Service:       name,
+			Ports:         parsePorts(serviceField(services[name], "ports")),
+			ContainerName: containerName,
+		})
+	}
+	return claims
+}
+
+// keySet returns the keys of a map as a set
+func keySet[V any](m map[string]V) map[string]bool {
+	set := map[string]bool{}
+	for key := range m {
+		set[key] = true
+	}
+	return set
+}
+
+// conflictsBetween describes the host resources claimed by both services
+func conflictsBetween(claim hostClaim, other hostClaim) []string {
+	var conflicts []string
+	for _, port := range claim.Ports {
+		for _, otherPort := range other.Ports {
+			if port.overlaps(otherPort) {
+				conflicts = append(conflicts, fmt.Sprintf("service %s publishes host port %s, also published by service %s of stack %s (%s)",
+					claim.Service, port, other.Service, other.Stack, other.File))
+				break
+			}
+		}
+	}
+	if claim.ContainerName != "" && claim.ContainerName == other.ContainerName {
+		conflicts = append(conflicts, fmt.Sprintf("service %s uses container name %s, also used by service %s of stack %s (%s)",
+			claim.Service, claim.ContainerName, other.Service, other.Stack, other.File))
+	}
+	return conflicts
+}
+
+// findConflicts returns the conflicts between the services of a compose file
+// and every other service of the repository
+func findConflicts(claims []hostClaim, others []hostClaim) []string {
+	var conflicts []string
+	for i, claim := range claims {
+		for _, other := range claims[i+1:] {
+			conflicts = append(conflicts, conflictsBetween(claim, other)...)
+		}
+		for _, other := range others {
+			conflicts = append(conflicts, conflictsBetween(claim, other)...)
+		}
+	}
+	return conflicts
+}
+
+// walkComposeFiles calls fn with the contents of every compose file of the
+// worktree other than skip, within the scan limits
+func walkComposeFiles(repoPath string, skip string, fn func(filePath string, data []byte)) error {
+	skipped := skipDirs()
+	return filepath.WalkDir(repoPath, func(osPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+
+		rel, _ := filepath.Rel(repoPath, osPath)
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			if d.Name() == ".git" || skipped[d.Name()] {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if rel == skip || !isComposeFile(rel) || !inScanScope(rel, skipped) {
+			return nil
+		}
+
+		data, err := readWorktreeFile(repoPath, rel)
+		if err != nil {
+			return err
+		}
+		fn(rel, data)
+		return nil
+	})
+}
+
+// otherClaims returns the host resources claimed by the compose files of
+// the worktree other than skip
+func otherClaims(repoPath string, skip string) ([]hostClaim, error) {
+	var claims []hostClaim
+	err := walkComposeFiles(repoPath, skip, func(filePath string, data []byte) {
+		claims = append(claims, hostClaims(filePath, data)...)
+	})
+	return claims, err
+}
+
+// checkConflicts warns about, or with 'block' holds, a change whose compose
+// file claims host ports or container names already claimed elsewhere.
+// Conflicts its HEAD version already had are not reported again.
+func checkConflicts(repo *git.Repository, repoPath string, change Change) error {
+	after, err := readWorktreeFile(repoPath, change.FilePath)
+	if err != nil {
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+	others, err := otherClaims(repoPath, change.FilePath)
+	if err != nil {
+		return fmt.Errorf("failed to scan compose files: %w", err)
+	}
+
+	known := map[string]bool{}
+	if before, err := headFileContents(repo, change.FilePath); err == nil {
+		for _, conflict := range findConflicts(hostClaims(change.FilePath, before), others) {
+			known[conflict] = true
+		}
+	}
+
+	var introduced []string
+	for _, conflict := range findConflicts(hostClaims(change.FilePath, after), others) {
+		if !known[conflict] {
+			introduced = append(introduced, conflict)
+		}
+	}
+	if len(introduced) == 0 {
+		return nil
+	}
+
+	for _, conflict := range introduced {
+		eventLog.Warn("Change conflicts with another service", "stack", change.StackName, "path", change.FilePath, "conflict", conflict)
+	}
+
+	if conflictsFlag == "block" {
+		return fmt.Errorf("%w: %d conflict(s) in %s", errCommitHeld, len(introduced), change.FilePath)
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import "fmt"
+
+// conventionalType is the Conventional Commits type and description of a
+// change type's commits, the stack being their scope
+type conventionalType struct {
+	kind, description string
+}
+
+var conventionalTypes = map[ChangeType]conventionalType{
+	Created:  {"feat", "create stack"},
+	Updated:  {"chore", "update compose"},
+	Deleted:  {"revert", "delete stack"},
+	Renamed:  {"refactor", "rename stack"},
+	Archived: {"chore", "archive stack"},
+}
+
+// parseCommitStyle validates --commit-style
+func parseCommitStyle() error {
+	switch commitStyleFlag {
+	case "plain", "conventional":
+		return nil
+	}
+	return fmt.Errorf("invalid --commit-style %q, expected plain or conventional", commitStyleFlag)
+}
+
+// conventionalSubject returns the subject of a stack commit with
+// --commit-style conventional, such as "feat(komodo): create stack", for
+// commitlint and semantic-release to accept it. The gitmoji of --gitmoji
+// goes after the colon, where it doesn't break the type.
+func conventionalSubject(change Change) string {
+	conventional := conventionalTypes[change.ChangeType]
+	description := conventional.description
+	if change.ChangeType == Renamed && change.OldPath != "" {
+		description += " from " + getStackName(change.OldPath)
+	}
+	if gitmojiFlag {
+		description = gitmojis[change.ChangeType] + " " + description
+	}
+	return fmt.Sprintf("%s(%s): %s", conventional.kind, change.StackName, description)
+}
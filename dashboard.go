@@ -0,0 +1,96 @@
+package main
+
+import (
+	"embed"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/template"
+	"time"
+
+	"github.com/go-git/go-git/v6"
+)
+
+// Metric names, all labelled with the repository, that the exported
+// dashboard and alert rules query
+const (
+	metricCycles          = "git_stack_watch_cycles_total"
+	metricCycleDuration   = "git_stack_watch_cycle_duration_seconds"
+	metricLastCycle       = "git_stack_watch_last_cycle_timestamp_seconds"
+	metricCommits         = "git_stack_watch_commits_total"
+	metricPushes          = "git_stack_watch_pushes_total"
+	metricPending         = "git_stack_watch_pending_changes"
+	metricRemoteReachable = "git_stack_watch_remote_reachable"
+)
+
+// monitoringTemplates holds the Grafana dashboard and the Prometheus alert
+// rules, with [[ ]] delimiters since both use {{ }} themselves
+//
+//go:embed templates/monitoring
+var monitoringTemplates embed.FS
+
+// monitoringFiles lists each embedded template with the file it is exported
+// as
+var monitoringFiles = [][2]string{
+	{"dashboard.json", "git-stack-watch-dashboard.json"},
+	{"alerts.yml", "git-stack-watch-alerts.yml"},
+}
+
+var exportDirFlag string
+
+func exportDashboardFlags(fs *flag.FlagSet) {
+	fs.StringVar(&exportDirFlag, "output-dir", ".", "Directory to write the dashboard and alert rules to")
+}
+
+// runExportDashboard writes the Grafana dashboard and the Prometheus alert
+// rules, flagging the watcher as stale after three --interval without a
+// cycle
+func runExportDashboard(_ *git.Repository, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("expected no arguments, got %d", len(args))
+	}
+
+	stale := 3 * intervalFlag.Round(time.Second)
+	data := map[string]any{
+		"Metrics": map[string]string{
+			"Cycles":          metricCycles,
+			"CycleDuration":   metricCycleDuration,
+			"LastCycle":       metricLastCycle,
+			"Commits":         metricCommits,
+			"Pushes":          metricPushes,
+			"Pending":         metricPending,
+			"RemoteReachable": metricRemoteReachable,
+		},
+		"StaleSeconds": int64(stale.Seconds()),
+		"StaleFor":     fmt.Sprintf("%ds", int64(stale.Seconds())),
+	}
+
+	if err := os.MkdirAll(exportDirFlag, 0o755); err != nil {
+		return err
+	}
+
+	for _, file := range monitoringFiles {
+		name, output := file[0], file[1]
+		tmpl, err := template.New(name).Delims("[[", "]]").ParseFS(monitoringTemplates, "templates/monitoring/"+name)
+		if err != nil {
+			return fmt.Errorf("failed to parse %s: %w", name, err)
+		}
+
+		path := filepath.Join(exportDirFlag, output)
+		out, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		err = tmpl.Execute(out, data)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("failed to render %s: %w", name, err)
+		}
+		fmt.Println(path)
+	}
+
+	return nil
+}
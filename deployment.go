@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing"
+)
+
+// deploymentsRef holds the deployment results of commits, one line per
+// deployment, apart from the notes 'git log' shows by default
+const deploymentsRef = plumbing.ReferenceName("refs/notes/deployments")
+
+// Deployment results recorded by the deployment command
+const (
+	deploySucceeded = "succeeded"
+	deployFailed    = "failed"
+)
+
+var deployTargetFlag string
+
+func deploymentFlags(fs *flag.FlagSet) {
+	fs.StringVar(&deployTargetFlag, "target", "", "Host or environment the commit was deployed to (default: this host's name)")
+}
+
+// deploymentLine formats the result of one deployment, such as
+// "2026-10-14T13:28:08Z succeeded on nas: 3 containers recreated"
+func deploymentLine(when time.Time, result string, target string, details string) string {
+	line := fmt.Sprintf("%s %s on %s", when.UTC().Format(time.RFC3339), result, target)
+	if details != "" {
+		line += ": " + details
+	}
+	return line
+}
+
+// lastDeployment returns the last deployment line recorded for a commit, if
+// any
+func lastDeployment(deployments *notes, hash plumbing.Hash) string {
+	lines := strings.Split(strings.TrimSpace(deployments.byHash[hash]), "\n")
+	return lines[len(lines)-1]
+}
+
+// runDeployment records the result of deploying a commit in a git note, so
+// the repository itself tells which changes were applied. Deploy scripts and
+// CD pipelines call it once they applied a commit; every result is kept.
+func runDeployment(repo *git.Repository, args []string) error {
+	if len(args) < 2 {
+		return errors.New("expected a commit and a result ('succeeded' or 'failed'), optionally followed by details")
+	}
+	revision, result, details := args[0], args[1], strings.Join(args[2:], " ")
+	if result != deploySucceeded && result != deployFailed {
+		return fmt.Errorf("unknown result %q, expected '%s' or '%s'", result, deploySucceeded, deployFailed)
+	}
+
+	hash, err := repo.ResolveRevision(plumbing.Revision(revision))
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", revision, err)
+	}
+	commit, err := repo.CommitObject(*hash)
+	if err != nil {
+		return err
+	}
+
+	target := deployTargetFlag
+	if target == "" {
+		target, _ = os.Hostname()
+	}
+
+	if pushFlag {
+		// Notes pushed by other hosts are kept
+		if err := fetchNotes(repo, deploymentsRef); err != nil {
+			return err
+		}
+	}
+	deployments, err := readNotes(repo, deploymentsRef)
+	if err != nil {
+		return err
+	}
+
+	line := deploymentLine(time.Now(), result, target, details)
+	deployments.byHash[commit.Hash] += line + "\n"
+	subject, _, _ := strings.Cut(commit.Message, "\n")
+	if err := deployments.write(repo, fmt.Sprintf("Deployment of %s %s on %s", commit.Hash.String()[:7], result, target)); err != nil {
+		return fmt.Errorf("failed to write notes: %w", err)
+	}
+	fmt.Printf("%s %s\n    %s\n", commit.Hash.String()[:7], subject, line)
+
+	if pushFlag {
+		return pushNotes(repo, deploymentsRef)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/utils/diff"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// changeDiff renders the added and removed lines between two versions of a
+// compose file, or only their diffstat when the diff exceeds --max-diff-size
+func changeDiff(before, after []byte) string {
+	var b strings.Builder
+	added, removed := 0, 0
+	for _, d := range diff.Do(string(before), string(after)) {
+		var prefix string
+		switch d.Type {
+		case diffmatchpatch.DiffInsert:
+			prefix = "+"
+		case diffmatchpatch.DiffDelete:
+			prefix = "-"
+		default:
+			continue
+		}
+
+		for _, line := range strings.SplitAfter(d.Text, "\n") {
+			if line == "" {
+				continue
+			}
+			if prefix == "+" {
+				added++
+			} else {
+				removed++
+			}
+			b.WriteString(prefix + strings.TrimSuffix(line, "\n") + "\n")
+		}
+	}
+
+	stat := fmt.Sprintf("%d insertion(s)(+), %d deletion(s)(-)", added, removed)
+	if maxDiffSizeFlag > 0 && b.Len() > int(maxDiffSizeFlag) {
+		size := byteSize(b.Len())
+		return fmt.Sprintf("%s, diff of %s omitted", stat, size.String())
+	}
+	return b.String() + stat
+}
+
+// logChangeDiff logs the diff of a detected change against HEAD at debug
+// level, skipping the work when debug logging is off
+func logChangeDiff(repo *git.Repository, repoPath string, change Change) {
+	if !eventLog.Enabled(context.Background(), slog.LevelDebug) {
+		return
+	}
+
+	// Created files have no HEAD version
+	before, _ := headFileContents(repo, change.FilePath)
+
+	var after []byte
+	if change.ChangeType != Deleted {
+		var err error
+		after, err = readWorktreeFile(repoPath, change.FilePath)
+		if err != nil {
+			opsLog.Warn("Failed to read changed file for its diff", "path", change.FilePath, "error", err)
+			return
+		}
+	}
+
+	eventLog.Debug("Change diff", "stack", change.StackName, "path", change.FilePath, "diff", changeDiff(before, after))
+}
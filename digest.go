@@ -0,0 +1,291 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v6"
+)
+
+// stackDigest sums up what happened to a stack since the last digest
+type stackDigest struct {
+	Stack   string `json:"stack"`
+	Commits int    `json:"commits"`
+	Held    int    `json:"held"`
+	Failed  int    `json:"failed"`
+
+	// Drifting is set while the last cycle left a change of the stack
+	// uncommitted, held or failed, so the repository no longer matches the
+	// files on disk
+	Drifting bool `json:"drifting"`
+}
+
+// repoDigest sums up what happened to a repository since the last digest
+type repoDigest struct {
+	Repo         string        `json:"repo"`
+	PushFailures int           `json:"push_failures"`
+	Diverged     bool          `json:"diverged"`
+	Stacks       []stackDigest `json:"stacks"`
+}
+
+// digestKey is the state store key of the counts of the next digest
+const digestKey = "digest"
+
+// digestCounts accumulates the digest of a repository between two digests.
+// They are kept in the state store, so that a restart doesn't lose them.
+type digestCounts struct {
+	Stacks       map[string]*stackDigest `json:"stacks"`
+	PushFailures int                     `json:"push_failures"`
+
+	// Counted are the held and failed changes of each drifting stack that
+	// were already counted, so that a change held or failing cycle after
+	// cycle counts once until its stack is back in line
+	Counted map[string]map[string]bool `json:"counted"`
+}
+
+// stack returns the counts of a stack, creating them on first use
+func (d *digestCounts) stack(name string) *stackDigest {
+	if d.Stacks == nil {
+		d.Stacks = map[string]*stackDigest{}
+	}
+	counts, ok := d.Stacks[name]
+	if !ok {
+		counts = &stackDigest{Stack: name}
+		d.Stacks[name] = counts
+	}
+	return counts
+}
+
+// firstTime reports whether an outcome of a change wasn't counted yet since
+// its stack started drifting, and remembers it
+func (d *digestCounts) firstTime(change Change, outcome string) bool {
+	if d.Counted == nil {
+		d.Counted = map[string]map[string]bool{}
+	}
+	key := outcome + " " + string(change.ChangeType) + " " + change.FilePath
+	if d.Counted[change.StackName][key] {
+		return false
+	}
+	if d.Counted[change.StackName] == nil {
+		d.Counted[change.StackName] = map[string]bool{}
+	}
+	d.Counted[change.StackName][key] = true
+	return true
+}
+
+// countHeld counts a held change, once until its stack is back in line
+func (d *digestCounts) countHeld(change Change) {
+	if d.firstTime(change, "held") {
+		d.stack(change.StackName).Held++
+	}
+}
+
+// countFailed counts a change that failed to commit, once until its stack
+// is back in line
+func (d *digestCounts) countFailed(change Change) {
+	if d.firstTime(change, "failed") {
+		d.stack(change.StackName).Failed++
+	}
+}
+
+// setDrifting marks the stacks the last cycle left uncommitted as drifting,
+// and the others as back in line, forgetting their counted changes
+func (d *digestCounts) setDrifting(stacks map[string]bool) {
+	for name, counts := range d.Stacks {
+		counts.Drifting = stacks[name]
+	}
+	for name := range stacks {
+		d.stack(name).Drifting = true
+	}
+	for name := range d.Counted {
+		if !stacks[name] {
+			delete(d.Counted, name)
+		}
+	}
+}
+
+// loadDigest returns the digest counts a previous run of the watcher left
+// in a repository's state store
+func loadDigest(repo *git.Repository) digestCounts {
+	var counts digestCounts
+	store, err := openStateStore(repo)
+	if err == nil {
+		_, err = store.load(digestKey, &counts)
+	}
+	if err != nil {
+		opsLog.Warn("Failed to load the digest counts", "error", err)
+		return digestCounts{}
+	}
+	return counts
+}
+
+// saveDigest keeps the digest counts of a repository in its state store
+func saveDigest(repo *git.Repository, counts digestCounts) {
+	store, err := openStateStore(repo)
+	if err == nil {
+		err = store.save(digestKey, counts)
+	}
+	if err != nil {
+		opsLog.Warn("Failed to save the digest counts", "error", err)
+	}
+}
+
+// takeDigest returns the digest of a watched repository and starts a new
+// period, keeping only the stacks still drifting
+func (w *watchedRepo) takeDigest() repoDigest {
+	var digest repoDigest
+	w.do(func() {
+		digest = repoDigest{Repo: w.path, PushFailures: current.digest.PushFailures, Diverged: current.diverged, Stacks: []stackDigest{}}
+		drifting := map[string]bool{}
+		for name, counts := range current.digest.Stacks {
+			digest.Stacks = append(digest.Stacks, *counts)
+			if counts.Drifting {
+				drifting[name] = true
+			}
+		}
+		current.digest = digestCounts{}
+		current.digest.setDrifting(drifting)
+	})
+	sort.Slice(digest.Stacks, func(i, j int) bool { return digest.Stacks[i].Stack < digest.Stacks[j].Stack })
+	return digest
+}
+
+// plural formats a count with its noun, e.g. "1 commit" or "3 commits"
+func plural(count int, noun string) string {
+	if count == 1 {
+		return fmt.Sprintf("1 %s", noun)
+	}
+	return fmt.Sprintf("%d %ss", count, noun)
+}
+
+// digestText renders the digests for people, in chat messages and emails
+//
+//	📋 git-stack-watch digest for nas, 2026-01-05 to 2026-01-12
+//	/srv/stacks: 4 commits, 1 held, 0 failed, 0 push failures
+//	  web: 3 commits
+//	  db: 1 commit, 1 held, drifting
+func digestText(host string, since, until time.Time, digests []repoDigest) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "📋 git-stack-watch digest for %s, %s to %s\n", host, since.Format("2006-01-02"), until.Format("2006-01-02"))
+	for _, digest := range digests {
+		var commits, held, failed int
+		for _, stack := range digest.Stacks {
+			commits, held, failed = commits+stack.Commits, held+stack.Held, failed+stack.Failed
+		}
+		fmt.Fprintf(&b, "%s: %s, %d held, %d failed, %s", digest.Repo, plural(commits, "commit"), held, failed, plural(digest.PushFailures, "push failure"))
+		if digest.Diverged {
+			b.WriteString(", diverged from the remote")
+		}
+		b.WriteByte('\n')
+		if len(digest.Stacks) == 0 {
+			b.WriteString("  no changes\n")
+		}
+		for _, stack := range digest.Stacks {
+			details := []string{plural(stack.Commits, "commit")}
+			if stack.Held > 0 {
+				details = append(details, fmt.Sprintf("%d held", stack.Held))
+			}
+			if stack.Failed > 0 {
+				details = append(details, fmt.Sprintf("%d failed", stack.Failed))
+			}
+			if stack.Drifting {
+				details = append(details, "drifting")
+			}
+			fmt.Fprintf(&b, "  %s: %s\n", stack.Stack, strings.Join(details, ", "))
+		}
+	}
+	return b.String()
+}
+
+// checkDigestOptions checks that the digest has somewhere to go, and that
+// emails have a server and a sender
+func checkDigestOptions() error {
+	if digestToFlag != "" && (smtpAddrFlag == "" || smtpFromFlag == "") {
+		return errors.New("--digest-to requires --smtp-addr and --smtp-from")
+	}
+	if smtpAddrFlag != "" {
+		if _, _, err := net.SplitHostPort(smtpAddrFlag); err != nil {
+			return fmt.Errorf("invalid --smtp-addr %q, expected host:port", smtpAddrFlag)
+		}
+	}
+	targets := []string{digestToFlag, secretValue(webhookURLFlag, "WEBHOOK_URL"), secretValue(discordWebhookFlag, "DISCORD_WEBHOOK_URL"), secretValue(slackWebhookFlag, "SLACK_WEBHOOK_URL"), ntfyURLFlag, gotifyURLFlag}
+	if digestIntervalFlag > 0 && firstOf(targets...) == "" {
+		return errors.New("--digest-interval requires --digest-to, --webhook-url, --discord-webhook, --slack-webhook, --ntfy-url or --gotify-url")
+	}
+	return nil
+}
+
+// digestSince is the start of the period of the next digest
+var digestSince = time.Now()
+
+// sendDigest sends the digest of every watched repository since the last
+// one to --webhook-url, the chat and push notification targets and --digest-to
+func sendDigest(watched []*watchedRepo) {
+	until := time.Now()
+	digests := make([]repoDigest, 0, len(watched))
+	for _, w := range watched {
+		digests = append(digests, w.takeDigest())
+	}
+	since := digestSince
+	digestSince = until
+
+	// Sent between cycles, so that its logs aren't labelled with one
+	cycleMu.Lock()
+	defer cycleMu.Unlock()
+
+	host, _ := os.Hostname()
+	text := digestText(host, since, until, digests)
+	opsLog.Info("Sending digest", "since", since.Format(time.RFC3339), "repositories", len(digests))
+
+	postJSON("webhook", webhookDigest, secretValue(webhookURLFlag, "WEBHOOK_URL"), "", webhookPayload{Event: webhookDigest, Time: until.UTC(), Host: host, Repo: repoFlag.String(), Since: since.UTC(), Digest: digests})
+	postChat(webhookDigest, text, priorityLow)
+
+	if digestToFlag != "" {
+		subject := fmt.Sprintf("git-stack-watch digest for %s", host)
+		if err := sendEmail(subject, text); err != nil {
+			opsLog.Warn("Failed to email the digest", "to", digestToFlag, "error", err)
+		}
+	}
+}
+
+// digestRecipients returns the addresses of --digest-to
+func digestRecipients() []string {
+	var recipients []string
+	for _, address := range strings.Split(digestToFlag, ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			recipients = append(recipients, address)
+		}
+	}
+	return recipients
+}
+
+// sendEmail sends a plain text email to --digest-to through --smtp-addr,
+// authenticating with --smtp-user when set. The connection is upgraded to
+// TLS when the server offers STARTTLS.
+func sendEmail(subject, body string) error {
+	host, _, err := net.SplitHostPort(smtpAddrFlag)
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if smtpUserFlag != "" {
+		auth = smtp.PlainAuth("", smtpUserFlag, secretValue(smtpPasswordFlag, "SMTP_PASSWORD"), host)
+	}
+
+	recipients := digestRecipients()
+	message := "From: " + smtpFromFlag + "\r\n" +
+		"To: " + strings.Join(recipients, ", ") + "\r\n" +
+		"Subject: " + mime.QEncoding.Encode("utf-8", subject) + "\r\n" +
+		"Date: " + time.Now().Format(time.RFC1123Z) + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" + strings.ReplaceAll(body, "\n", "\r\n")
+	return smtp.SendMail(smtpAddrFlag, auth, smtpFromFlag, recipients, []byte(message))
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDigestCountsDistinctChanges(t *testing.T) {
+	var counts digestCounts
+	web := Change{StackName: "web", FilePath: "web/compose.yml", ChangeType: Updated}
+	db := Change{StackName: "db", FilePath: "db/compose.yml", ChangeType: Deleted}
+
+	// Three cycles hold the same web change and fail the same db change
+	for range 3 {
+		counts.countHeld(web)
+		counts.countFailed(db)
+		counts.setDrifting(map[string]bool{"web": true, "db": true})
+	}
+	// The web change is committed, then held again
+	counts.setDrifting(map[string]bool{"db": true})
+	counts.countHeld(web)
+	counts.countHeld(Change{StackName: "db", FilePath: "db/compose.yml", ChangeType: Created})
+	counts.setDrifting(map[string]bool{"web": true, "db": true})
+
+	if got := counts.stack("web").Held; got != 2 {
+		t.Errorf("web held = %d, want 2", got)
+	}
+	if got := counts.stack("db").Failed; got != 1 {
+		t.Errorf("db failed = %d, want 1", got)
+	}
+	if got := counts.stack("db").Held; got != 1 {
+		t.Errorf("db held = %d, want 1", got)
+	}
+}
+
+func TestDigestPersistence(t *testing.T) {
+	for _, store := range []string{"", "sqlite"} {
+		t.Run(firstOf(store, "file"), func(t *testing.T) {
+			if store == "sqlite" {
+				openTestDatabase(t, "sqlite:"+filepath.Join(t.TempDir(), "state.db"))
+			}
+			repo, _ := initTestRepo(t)
+
+			var counts digestCounts
+			counts.countHeld(Change{StackName: "web", FilePath: "web/compose.yml", ChangeType: Updated})
+			counts.setDrifting(map[string]bool{"web": true})
+			counts.PushFailures = 2
+			saveDigest(repo, counts)
+
+			got := loadDigest(repo)
+			if !reflect.DeepEqual(got, counts) {
+				t.Errorf("loadDigest() = %+v, want %+v", got, counts)
+			}
+			// A loaded change still counts once
+			got.countHeld(Change{StackName: "web", FilePath: "web/compose.yml", ChangeType: Updated})
+			if held := got.stack("web").Held; held != 1 {
+				t.Errorf("web held after reload = %d, want 1", held)
+			}
+		})
+	}
+}
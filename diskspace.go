@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// byteSize is a flag value accepting sizes such as "512", "64KB" or "1.5GB"
+type byteSize uint64
+
+var sizeUnits = []struct {
+	suffix string
+	factor float64
+}{
+	{"TB", 1 << 40},
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"B", 1},
+}
+
+func (s *byteSize) String() string {
+	value := float64(*s)
+	for _, unit := range sizeUnits {
+		if value >= unit.factor {
+			return fmt.Sprintf("%.4g%s", value/unit.factor, unit.suffix)
+		}
+	}
+	return "0"
+}
+
+func (s *byteSize) Set(value string) error {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	factor := 1.0
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(value, unit.suffix) {
+			factor = unit.factor
+			value = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix))
+			break
+		}
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || number < 0 {
+		return fmt.Errorf("invalid size %q", value)
+	}
+
+	*s = byteSize(number * factor)
+	return nil
+}
+
+// hasEnoughDiskSpace reports whether the repository's filesystem has at least
+// --min-free-space available. Failing to read the free space doesn't block.
+func hasEnoughDiskSpace(repoPath string) bool {
+	if minFreeSpaceFlag == 0 {
+		return true
+	}
+
+	free, err := freeDiskSpace(repoPath)
+	if err != nil {
+		opsLog.Warn("Failed to check free disk space", "error", err)
+		return true
+	}
+
+	if free < uint64(minFreeSpaceFlag) {
+		available := byteSize(free)
+		opsLog.Warn("Low disk space, holding commits and push", "free", available.String(), "threshold", minFreeSpaceFlag.String())
+		return false
+	}
+	return true
+}
@@ -0,0 +1,10 @@
+//go:build plan9 || js || wasip1
+
+package main
+
+import "errors"
+
+// freeDiskSpace is not available on this platform
+func freeDiskSpace(path string) (uint64, error) {
+	return 0, errors.New("free disk space is not available on this platform")
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing"
+)
+
+// unpushedCommits counts the commits of HEAD that its remote branch doesn't
+// have, or returns false when there's no remote branch to compare with
+func unpushedCommits(repo *git.Repository) (int, bool) {
+	head, err := repo.Head()
+	if err != nil || !head.Name().IsBranch() {
+		return 0, false
+	}
+	remote, err := repo.Reference(plumbing.NewRemoteReferenceName(git.DefaultRemoteName, head.Name().Short()), true)
+	if err != nil {
+		return 0, false
+	}
+
+	commits, err := repo.Log(&git.LogOptions{From: head.Hash()})
+	if err != nil {
+		return 0, false
+	}
+	defer commits.Close()
+
+	count := 0
+	for {
+		commit, err := commits.Next()
+		if errors.Is(err, io.EOF) {
+			// The remote branch isn't in HEAD's history
+			return count, false
+		}
+		if err != nil || commit.Hash == remote.Hash() {
+			return count, err == nil
+		}
+		count++
+	}
+}
+
+// dryRunHold reports why a change would be held back from its commit, with the
+// gates that only read the worktree: --lint, --conflicts and conflict markers
+func dryRunHold(repo *git.Repository, root string, change Change) error {
+	if change.ChangeType != Deleted && isComposeFile(change.FilePath) {
+		if lintFlag != "" {
+			if err := checkLint(root, change); err != nil {
+				return err
+			}
+		}
+		if conflictsFlag != "" {
+			if err := checkConflicts(repo, root, change); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, name := range change.files() {
+		data, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(name)))
+		if err != nil {
+			continue
+		}
+		if lines := conflictMarkers(data); len(lines) > 0 {
+			return fmt.Errorf("%w: conflict markers in %s at lines %v", errCommitHeld, name, lines)
+		}
+	}
+	return nil
+}
+
+// printDryRun prints the commits a cycle would create, with their message and
+// the paths they'd stage, and what would be pushed, without changing anything
+func printDryRun(repo *git.Repository, root string, changes []Change) {
+	sort.Slice(changes, func(i, j int) bool { return changes[i].FilePath < changes[j].FilePath })
+
+	commits := 0
+	for _, change := range changes {
+		target := "the checked out branch"
+		if stackBranchFlag != "" {
+			target = stackBranch(change.StackName).Short()
+		} else if branchFlag != "" {
+			target = branchFlag
+		} else if branch, err := headBranch(repo); err == nil {
+			target = branch
+		}
+
+		fmt.Printf("Would commit %s %s on %s\n", change.ChangeType, change.StackName, target)
+		if err := dryRunHold(repo, root, change); err != nil {
+			fmt.Printf("  held: %v\n", err)
+			continue
+		}
+		commits++
+
+		fmt.Println("  paths:")
+		for _, name := range change.files() {
+			action := "add"
+			if _, err := os.Lstat(filepath.Join(root, filepath.FromSlash(name))); errors.Is(err, os.ErrNotExist) {
+				action = "remove"
+			}
+			fmt.Printf("    %s %s\n", action, name)
+		}
+		fmt.Println("  message:")
+		for _, line := range strings.Split(commitMessage(repo, root, change), "\n") {
+			fmt.Println(strings.TrimRight("    "+line, " "))
+		}
+	}
+
+	switch {
+	case !pushFlag:
+		fmt.Println("Would not push, --push isn't set")
+	case commits == 0:
+		fmt.Println("Would not push, no commit would be created")
+	case pushesDeferred():
+		fmt.Printf("Would defer %d commit(s) to the next scheduled push\n", commits)
+	default:
+		refSpecs, err := pushRefSpecs(repo)
+		if err != nil {
+			fmt.Printf("Would fail to push: %v\n", err)
+			return
+		}
+		specs := "go-git's default refspecs"
+		if len(refSpecs) > 0 {
+			names := make([]string, len(refSpecs))
+			for i, spec := range refSpecs {
+				names[i] = spec.String()
+			}
+			specs = strings.Join(names, ", ")
+		}
+		fmt.Printf("Would push %d new commit(s) to %s (%s) with %s", commits, git.DefaultRemoteName, redact(remoteURL(repo), nil), specs)
+		if unpushed, ok := unpushedCommits(repo); ok && unpushed > 0 && stackBranchFlag == "" {
+			fmt.Printf(", along with %d commit(s) not pushed yet", unpushed)
+		}
+		fmt.Println()
+	}
+}
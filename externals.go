@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// composeResource is a network or volume, by the name docker knows it by
+type composeResource struct {
+	Kind string
+	Name string
+}
+
+func (r composeResource) String() string {
+	return r.Kind + " " + r.Name
+}
+
+// composeResources holds the top-level networks and volumes of a compose file
+type composeResources struct {
+	Networks map[string]any `yaml:"networks"`
+	Volumes  map[string]any `yaml:"volumes"`
+}
+
+// resourceName returns the docker name of a top-level network or volume and
+// whether it is external. External resources keep their key unless renamed,
+// others are prefixed with the project, i.e. the stack directory.
+func resourceName(stack string, key string, definition any) (string, bool) {
+	fields, _ := definition.(map[string]any)
+
+	external := false
+	name, _ := fields["name"].(string)
+	switch value := fields["external"].(type) {
+	case bool:
+		external = value
+	case map[string]any:
+		// Legacy 'external: {name: ...}' syntax
+		external = true
+		if legacyName, ok := value["name"].(string); ok {
+			name = legacyName
+		}
+	}
+
+	switch {
+	case name != "":
+		return name, external
+	case external:
+		return key, true
+	default:
+		return stack + "_" + key, false
+	}
+}
+
+// parseResources returns the external and declared networks and volumes of a
+// compose file. Unparsable files have none.
+func parseResources(filePath string, data []byte) (external []composeResource, declared []composeResource) {
+	var resources composeResources
+	if err := decodeDocuments(data, &resources); err != nil {
+		return nil, nil
+	}
+
+	stack := getStackName(filePath)
+	for kind, definitions := range map[string]map[string]any{"network": resources.Networks, "volume": resources.Volumes} {
+		for _, key := range sortedKeys(keySet(definitions)) {
+			name, isExternal := resourceName(stack, key, definitions[key])
+			resource := composeResource{Kind: kind, Name: name}
+			if isExternal {
+				external = append(external, resource)
+			} else {
+				declared = append(declared, resource)
+			}
+		}
+	}
+	return external, declared
+}
+
+// knownExternals returns the networks and volumes listed in
+// --known-externals, which exist outside of the repository
+func knownExternals() map[string]bool {
+	known := map[string]bool{}
+	for _, name := range strings.Split(knownExternalsFlag, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			known[name] = true
+		}
+	}
+	return known
+}
+
+// danglingExternals returns the external networks and volumes of a compose
+// file that no other compose file of the repository declares and that
+// --known-externals doesn't list
+func danglingExternals(repoPath string, filePath string, data []byte) ([]composeResource, error) {
+	external, _ := parseResources(filePath, data)
+	if len(external) == 0 {
+		return nil, nil
+	}
+
+	declared := map[composeResource]bool{}
+	err := walkComposeFiles(repoPath, filePath, func(otherPath string, otherData []byte) {
+		_, resources := parseResources(otherPath, otherData)
+		for _, resource := range resources {
+			declared[resource] = true
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	known := knownExternals()
+	var dangling []composeResource
+	for _, resource := range external {
+		if !declared[resource] && !known[resource.Name] {
+			dangling = append(dangling, resource)
+		}
+	}
+	return dangling, nil
+}
+
+// externalsBody returns the commit body line listing the dangling external
+// references of a stack change, warning about each of them. It is empty
+// when the check is disabled or every reference is declared.
+func externalsBody(repoPath string, change Change) string {
+	if !checkExternalsFlag || change.ChangeType == Deleted {
+		return ""
+	}
+
+	data, err := readWorktreeFile(repoPath, change.FilePath)
+	if err != nil {
+		return ""
+	}
+	dangling, err := danglingExternals(repoPath, change.FilePath, data)
+	if err != nil {
+		opsLog.Warn("Failed to check external references", "path", change.FilePath, "error", err)
+		return ""
+	}
+	if len(dangling) == 0 {
+		return ""
+	}
+
+	names := make([]string, len(dangling))
+	for i, resource := range dangling {
+		names[i] = resource.String()
+		eventLog.Warn("External reference is not declared in the repository", "stack", change.StackName, "path", change.FilePath, "resource", resource)
+	}
+	return fmt.Sprintf("Dangling external references: %s", strings.Join(names, ", "))
+}
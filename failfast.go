@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-git/go-git/v6/plumbing/transport"
+)
+
+// Failure categories that --fail-fast can turn into a process exit
+const (
+	failAuth = "auth"
+	failRepo = "repo"
+	failPush = "push"
+)
+
+// errAuth marks errors caused by missing or rejected credentials
+var errAuth = errors.New("authentication failed")
+
+// failFast holds the categories enabled by --fail-fast
+var failFast = map[string]bool{}
+
+// parseFailFast parses the comma separated --fail-fast categories
+func parseFailFast(value string) error {
+	for _, category := range strings.Split(value, ",") {
+		category = strings.TrimSpace(category)
+		switch category {
+		case "":
+		case "all":
+			failFast[failAuth] = true
+			failFast[failRepo] = true
+			failFast[failPush] = true
+		case failAuth, failRepo, failPush:
+			failFast[category] = true
+		default:
+			return fmt.Errorf("unknown --fail-fast category %q", category)
+		}
+	}
+	return nil
+}
+
+// isAuthError reports whether a push error was caused by credentials
+func isAuthError(err error) bool {
+	return errors.Is(err, errAuth) ||
+		errors.Is(err, transport.ErrAuthenticationRequired) ||
+		errors.Is(err, transport.ErrAuthorizationFailed) ||
+		errors.Is(err, transport.ErrInvalidAuthMethod) ||
+		strings.Contains(err.Error(), "unable to authenticate")
+}
+
+// checkRepoFailure exits when repository errors are fatal
+func checkRepoFailure(err error) {
+	if failFast[failRepo] {
+		fatal("Repository error, exiting (--fail-fast repo)", "error", err)
+	}
+}
+
+// recordPushResult tracks consecutive push failures and exits when the
+// configured fail-fast policy says the failure can't be retried away
+func recordPushResult(err error) {
+	current.metrics.observePush(err)
+	if err == nil {
+		current.pushFailures = 0
+		return
+	}
+
+	current.pushFailures++
+	current.digest.PushFailures++
+	postWebhook(webhookPayload{Event: webhookPushFailed, Error: err.Error(), Failures: current.pushFailures})
+
+	if failFast[failAuth] && isAuthError(err) {
+		fatal("Push authentication failed, exiting (--fail-fast auth)", "error", err)
+	}
+	if failFast[failPush] && current.pushFailures >= failFastPushesFlag {
+		fatal("Too many consecutive push failures, exiting (--fail-fast push)", "failures", current.pushFailures, "error", err)
+	}
+}
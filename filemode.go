@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing/filemode"
+)
+
+// modeChange returns the HEAD and worktree modes of an updated compose file
+// whose content is unchanged, reporting whether only its mode bits differ
+func modeChange(repo *git.Repository, repoPath string, change Change) (filemode.FileMode, filemode.FileMode, bool, error) {
+	if change.ChangeType != Updated {
+		return 0, 0, false, nil
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		return 0, 0, false, err
+	}
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return 0, 0, false, err
+	}
+	file, err := commit.File(change.FilePath)
+	if err != nil {
+		return 0, 0, false, err
+	}
+
+	hash, exists, err := hashWorktreeFile(repoPath, change.FilePath)
+	if err != nil || !exists || !hash.Equal(file.Hash) {
+		return 0, 0, false, err
+	}
+
+	info, err := os.Lstat(filepath.Join(repoPath, filepath.FromSlash(change.FilePath)))
+	if err == nil && info.Mode()&os.ModeSymlink != 0 && followsSymlink(change.FilePath) {
+		info, err = os.Stat(filepath.Join(repoPath, filepath.FromSlash(change.FilePath)))
+	}
+	if err != nil {
+		return 0, 0, false, err
+	}
+	mode, err := filemode.NewFromOSFileMode(info.Mode())
+	if err != nil {
+		return 0, 0, false, err
+	}
+
+	return file.Mode, mode, mode != file.Mode, nil
+}
+
+// filterModeChanges applies --mode-changes to updates that only change a
+// compose file's mode bits: 'commit' keeps them, 'ignore' drops them and
+// 'warn' drops them with a warning
+func filterModeChanges(repo *git.Repository, repoPath string, changes []Change) []Change {
+	var kept []Change
+	for _, change := range changes {
+		oldMode, newMode, modeOnly, err := modeChange(repo, repoPath, change)
+		if err != nil {
+			opsLog.Warn("Failed to compare file modes, keeping change", "path", change.FilePath, "error", err)
+		}
+		if !modeOnly {
+			kept = append(kept, change)
+			continue
+		}
+
+		switch modeChangesFlag {
+		case "ignore":
+			eventLog.Debug("Ignoring mode-only change", "stack", change.StackName, "path", change.FilePath, "old_mode", oldMode, "new_mode", newMode)
+		case "warn":
+			eventLog.Warn("Compose file mode changed, not committing it", "stack", change.StackName, "path", change.FilePath, "old_mode", oldMode, "new_mode", newMode)
+		default:
+			eventLog.Info("Committing mode-only change", "stack", change.StackName, "path", change.FilePath, "old_mode", oldMode, "new_mode", newMode)
+			kept = append(kept, change)
+		}
+	}
+	return kept
+}
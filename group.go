@@ -0,0 +1,68 @@
+package main
+
+import (
+	"path"
+	"slices"
+	"sort"
+)
+
+// primaryRank orders the files of a stack directory to pick the one its
+// grouped change is about: compose.yml or compose.yaml, then the other
+// compose files, then the other watched files
+func primaryRank(filePath string) int {
+	switch name := path.Base(filePath); {
+	case name == "compose.yml" || name == "compose.yaml":
+		return 0
+	case isComposeFile(filePath):
+		return 1
+	}
+	return 2
+}
+
+// groupStackChanges merges the changes of watched files in the same stack
+// directory, such as compose.yml with a compose.override.yml or an env file
+// matching --pattern, into one change per stack, committed once per cycle.
+// The change is about the stack's compose file, the other files being
+// committed and listed along as stack files.
+func groupStackChanges(changes []Change) []Change {
+	byDir := map[string][]int{}
+	var dirs []string
+	for i, change := range changes {
+		dir := path.Dir(change.FilePath)
+		if _, ok := byDir[dir]; !ok {
+			dirs = append(dirs, dir)
+		}
+		byDir[dir] = append(byDir[dir], i)
+	}
+	if len(dirs) == len(changes) {
+		return changes
+	}
+
+	grouped := make([]Change, 0, len(dirs))
+	for _, dir := range dirs {
+		members := byDir[dir]
+		sort.Slice(members, func(i, j int) bool {
+			a, b := changes[members[i]], changes[members[j]]
+			if primaryRank(a.FilePath) != primaryRank(b.FilePath) {
+				return primaryRank(a.FilePath) < primaryRank(b.FilePath)
+			}
+			return a.FilePath < b.FilePath
+		})
+
+		change := changes[members[0]]
+		for _, i := range members[1:] {
+			other := changes[i]
+			change.Extras = append(change.Extras, other.FilePath)
+			change.Extras = append(change.Extras, other.Extras...)
+			change.Included = append(change.Included, other.Included...)
+		}
+		if len(members) > 1 {
+			sort.Strings(change.Extras)
+			sort.Strings(change.Included)
+			change.Included = slices.Compact(change.Included)
+			opsLog.Debug("Grouped stack changes", "stack", change.StackName, "files", len(change.files()))
+		}
+		grouped = append(grouped, change)
+	}
+	return grouped
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// repoHealth is the health of a repository reported by /healthz and /readyz
+type repoHealth struct {
+	Path       string    `json:"path"`
+	Open       bool      `json:"open"`
+	LastCycle  time.Time `json:"last_cycle,omitzero"`
+	LastPushOK bool      `json:"last_push_ok"`
+}
+
+// healthReport is the document served by /healthz and /readyz
+type healthReport struct {
+	Status       string       `json:"status"`
+	Reason       string       `json:"reason,omitempty"`
+	Repositories []repoHealth `json:"repositories"`
+}
+
+// staleAfter is how long a repository may go without a cycle before the
+// watcher counts as wedged: three --interval, like the exported alert rules
+func staleAfter() time.Duration {
+	return 3 * intervalFlag
+}
+
+// repositoriesHealth reports every --repo, including the ones that couldn't be
+// opened
+func repositoriesHealth() []repoHealth {
+	var repos []repoHealth
+	for _, path := range repoFlag {
+		i := slices.IndexFunc(statusRepos, func(w *watchedRepo) bool { return w.path == path })
+		if i < 0 {
+			repos = append(repos, repoHealth{Path: path})
+			continue
+		}
+		w := statusRepos[i]
+		w.snapshotMu.Lock()
+		repos = append(repos, repoHealth{Path: path, Open: true, LastCycle: w.snapshot.LastCycle, LastPushOK: w.snapshot.PushFailures == 0})
+		w.snapshotMu.Unlock()
+	}
+	return repos
+}
+
+// liveness fails when an open repository has gone without a cycle for too
+// long, which a restart may fix
+func liveness(repos []repoHealth) string {
+	for _, repo := range repos {
+		last := repo.LastCycle
+		if last.IsZero() {
+			last = startedAt
+		}
+		if repo.Open && time.Since(last) > staleAfter() {
+			return "no cycle on " + repo.Path + " since " + last.Format(time.RFC3339)
+		}
+	}
+	return ""
+}
+
+// readiness fails until every repository is open and went through a cycle,
+// and while the last push of one of them failed
+func readiness(repos []repoHealth) string {
+	for _, repo := range repos {
+		switch {
+		case !repo.Open:
+			return repo.Path + " isn't open"
+		case repo.LastCycle.IsZero():
+			return "no cycle on " + repo.Path + " yet"
+		case !repo.LastPushOK:
+			return "the last push of " + repo.Path + " failed"
+		}
+	}
+	return liveness(repos)
+}
+
+// healthHandler serves a health report, with status 503 when check fails
+func healthHandler(check func([]repoHealth) string) http.HandlerFunc {
+	return func(rw http.ResponseWriter, _ *http.Request) {
+		report := healthReport{Status: "ok", Repositories: repositoriesHealth()}
+		rw.Header().Set("Content-Type", "application/json")
+		if report.Reason = check(report.Repositories); report.Reason != "" {
+			report.Status = "failing"
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(rw).Encode(report)
+	}
+}
+
+// serveHealth serves /healthz, whether the watcher still runs cycles, and
+// /readyz, whether its repositories are open, checked and pushed, in the
+// background. They don't take --api-token, for healthchecks to stay simple.
+func serveHealth(addr string) error {
+	listener, err := apiListen(addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", healthHandler(liveness))
+	mux.HandleFunc("GET /readyz", healthHandler(readiness))
+
+	go func() {
+		if err := http.Serve(listener, protectAPI(mux, roleNone)); err != nil {
+			processLog.Error("Health endpoints stopped", "error", err)
+		}
+	}()
+
+	opsLog.Info("Serving health endpoints", "url", apiURL(listener, "/healthz"))
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v6"
+)
+
+// heartbeatFile is the path, relative to the repository root, of the file
+// touched by heartbeat commits
+const heartbeatFile = ".stack-watch/heartbeat"
+
+// heartbeatDue reports whether the last recorded heartbeat is older than the
+// configured heartbeat interval
+func heartbeatDue(repoPath string) bool {
+	data, err := os.ReadFile(filepath.Join(repoPath, heartbeatFile))
+	if err != nil {
+		return true
+	}
+
+	last, err := time.Parse(time.RFC3339, strings.TrimSpace(string(data)))
+	if err != nil {
+		return true
+	}
+
+	return time.Since(last) >= heartbeatFlag
+}
+
+// commitHeartbeat writes the current time to the heartbeat file and commits it,
+// so the watcher's liveness can be checked from the repository history alone
+func commitHeartbeat(worktree *git.Worktree, repoPath string) error {
+	path := filepath.Join(repoPath, heartbeatFile)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create heartbeat directory: %w", err)
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	if err := os.WriteFile(path, []byte(now+"\n"), 0o644); err != nil {
+		return fmt.Errorf("failed to write heartbeat file: %w", err)
+	}
+
+	if _, err := worktree.Add(heartbeatFile); err != nil {
+		return fmt.Errorf("failed to add heartbeat file: %w", err)
+	}
+
+	commit, err := worktree.Commit(withTrailers("heartbeat", ownTrailerKey+": heartbeat", runTrailer()), commitOptions())
+	if err != nil {
+		return fmt.Errorf("failed to commit: %w", err)
+	}
+
+	eventLog.Info("✓ Created heartbeat commit", "hash", commit.String()[:7])
+
+	return nil
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing/object"
+)
+
+var logLimitFlag int
+
+func logFlags(fs *flag.FlagSet) {
+	fs.IntVar(&logLimitFlag, "limit", 20, "Maximum number of commits to show (0 for all)")
+}
+
+// belongsToStack reports whether a repository path is part of the named
+// stack, i.e. the innermost stack directory of dirs containing it has that
+// name
+func belongsToStack(filePath string, name string, dirs map[string]string) bool {
+	dir, ok := owningStack(filePath, dirs)
+	return ok && getStackName(dirs[dir]) == name
+}
+
+// commitStackDirs returns the stack directories of a commit and its first
+// parent, keyed like stackDirs, so that the files of a stack the commit
+// removed still belong to it
+func commitStackDirs(commit *object.Commit) (map[string]string, error) {
+	commits := []*object.Commit{commit}
+	if commit.NumParents() > 0 {
+		parent, err := commit.Parent(0)
+		if err != nil {
+			return nil, err
+		}
+		commits = append(commits, parent)
+	}
+
+	dirs := map[string]string{}
+	for _, c := range commits {
+		tree, err := c.Tree()
+		if err != nil {
+			return nil, err
+		}
+		err = tree.Files().ForEach(func(file *object.File) error {
+			if isComposeFile(file.Name) && !isArchived(file.Name) {
+				dirs[path.Dir(file.Name)] = file.Name
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return dirs, nil
+}
+
+// commitChanges returns the file changes a commit introduced over its first
+// parent, or over an empty tree for root commits
+func commitChanges(commit *object.Commit) (object.Changes, error) {
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	var parentTree *object.Tree
+	if commit.NumParents() > 0 {
+		parent, err := commit.Parent(0)
+		if err != nil {
+			return nil, err
+		}
+		parentTree, err = parent.Tree()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return object.DiffTree(parentTree, tree)
+}
+
+// changeName returns the path of a change, whichever side it exists on
+func changeName(change *object.Change) string {
+	if change.To.Name != "" {
+		return change.To.Name
+	}
+	return change.From.Name
+}
+
+// changeContents returns the content of both sides of a change, empty for a
+// side that doesn't exist
+func changeContents(change *object.Change) ([]byte, []byte, error) {
+	from, to, err := change.Files()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var before, after string
+	if from != nil {
+		if before, err = from.Contents(); err != nil {
+			return nil, nil, err
+		}
+	}
+	if to != nil {
+		if after, err = to.Contents(); err != nil {
+			return nil, nil, err
+		}
+	}
+	return []byte(before), []byte(after), nil
+}
+
+// composeChangeDetails parses both sides of a compose file change and returns
+// the image and environment differences
+func composeChangeDetails(change *object.Change) []string {
+	before, after, err := changeContents(change)
+	if err != nil {
+		return []string{fmt.Sprintf("(unreadable: %v)", err)}
+	}
+
+	old, err := parseCompose(before)
+	if err != nil {
+		return []string{"(previous version is not valid YAML)"}
+	}
+	cur, err := parseCompose(after)
+	if err != nil {
+		return []string{"(new version is not valid YAML)"}
+	}
+
+	return imageAndEnvChanges(old, cur)
+}
+
+// runLog prints the commits touching a stack's files, bot and human alike,
+// with the image and environment changes of its compose files
+func runLog(repo *git.Repository, args []string) error {
+	if len(args) != 1 {
+		return errors.New("expected exactly one stack name")
+	}
+	names := loadAliases(repo).names(args[0])
+
+	head, err := repo.Head()
+	if err != nil {
+		return fmt.Errorf("failed to resolve HEAD: %w", err)
+	}
+
+	commits, err := repo.Log(&git.LogOptions{From: head.Hash()})
+	if err != nil {
+		return err
+	}
+	defer commits.Close()
+
+	deployments, err := readNotes(repo, deploymentsRef)
+	if err != nil {
+		return err
+	}
+
+	shown := 0
+	for logLimitFlag == 0 || shown < logLimitFlag {
+		commit, err := commits.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			if historyTruncated(repo, err) {
+				break
+			}
+			return err
+		}
+
+		changes, err := commitChanges(commit)
+		if err != nil {
+			if historyTruncated(repo, err) {
+				break
+			}
+			return err
+		}
+
+		dirs, err := commitStackDirs(commit)
+		if err != nil {
+			if historyTruncated(repo, err) {
+				break
+			}
+			return err
+		}
+
+		var lines []string
+		for _, change := range changes {
+			filePath := changeName(change)
+			if !belongsToStacks(filePath, names, dirs) {
+				continue
+			}
+
+			lines = append(lines, filePath)
+			if isComposeFile(filePath) {
+				for _, detail := range composeChangeDetails(change) {
+					lines = append(lines, "  "+detail)
+				}
+			}
+		}
+		if len(lines) == 0 {
+			continue
+		}
+
+		subject, _, _ := strings.Cut(commit.Message, "\n")
+		fmt.Printf("%s %s %s  %s\n", commit.Hash.String()[:7], commit.Author.When.Format("2006-01-02 15:04"), commit.Author.Name, subject)
+		for _, line := range lines {
+			fmt.Printf("    %s\n", line)
+		}
+		if _, ok := deployments.byHash[commit.Hash]; ok {
+			fmt.Printf("    deployment: %s\n", lastDeployment(deployments, commit.Hash))
+		}
+		shown++
+	}
+
+	if shown == 0 {
+		fmt.Printf("No commits found for stack %s\n", args[0])
+	}
+	return nil
+}
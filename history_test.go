@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing/object"
+)
+
+func TestBelongsToStack(t *testing.T) {
+	dirs := map[string]string{
+		".":        "compose.yml",
+		"web":      "web/compose.yml",
+		"a/web":    "a/web/compose.yml",
+		"apps/api": "apps/api/compose.yaml",
+	}
+	tests := []struct {
+		filePath string
+		name     string
+		want     bool
+	}{
+		{"web/compose.yml", "web", true},
+		{"web/.env", "web", true},
+		{"web/config/nginx.conf", "web", true},
+		{"a/web/compose.yml", "web", true},
+		{"a/web/compose.yml", "a", false},
+		{"a/web/.env", "web", true},
+		{"apps/api/.env", "api", true},
+		{"apps/api/.env", "apps", false},
+		{"apps/web/.env", "web", false},
+		{"docs/web/README.md", "web", false},
+		{"compose.yml", "root", true},
+		{".env", "root", true},
+		{"docs/README.md", "root", false},
+	}
+
+	for _, tt := range tests {
+		if got := belongsToStack(tt.filePath, tt.name, dirs); got != tt.want {
+			t.Errorf("belongsToStack(%q, %q) = %v, want %v", tt.filePath, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCommitStackDirs(t *testing.T) {
+	repo, _ := initTestRepo(t)
+	worktree, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := worktree.Filesystem.Root()
+	if err := os.MkdirAll(filepath.Join(root, "web"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "web", "compose.yml"), []byte("services: {}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := worktree.Add("web/compose.yml"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := worktree.Remove("compose.yml"); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := worktree.Commit("move", &git.CommitOptions{Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dirs, err := commitStackDirs(commit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for dir, composePath := range map[string]string{".": "compose.yml", "web": "web/compose.yml"} {
+		if dirs[dir] != composePath {
+			t.Errorf("commitStackDirs()[%q] = %q, want %q", dir, dirs[dir], composePath)
+		}
+	}
+}
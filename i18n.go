@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// locales holds the message catalogs, one JSON file per language mapping the
+// English message to its translation
+//
+//go:embed locales/*.json
+var locales embed.FS
+
+// catalog is the message catalog of the configured locale, nil for English
+var catalog map[string]string
+
+// loadCatalog loads the catalog for a locale such as "fr" or "fr_FR.UTF-8"
+func loadCatalog(locale string) error {
+	lang, _, _ := strings.Cut(strings.ToLower(locale), "_")
+	lang, _, _ = strings.Cut(lang, ".")
+	if lang == "" || lang == "en" || lang == "c" || lang == "posix" {
+		catalog = nil
+		return nil
+	}
+
+	data, err := locales.ReadFile("locales/" + lang + ".json")
+	if err != nil {
+		return fmt.Errorf("unsupported locale %q", locale)
+	}
+
+	var messages map[string]string
+	if err := json.Unmarshal(data, &messages); err != nil {
+		return fmt.Errorf("invalid catalog for locale %q: %w", locale, err)
+	}
+
+	catalog = messages
+	return nil
+}
+
+// tr translates a message into the configured locale, falling back to the
+// English message when it has no translation
+func tr(msg string) string {
+	if translated, ok := catalog[msg]; ok {
+		return translated
+	}
+	return msg
+}
+
+// localizedHandler translates record messages before passing them on.
+// Attribute keys and values are left as is so they stay machine readable.
+type localizedHandler struct {
+	slog.Handler
+}
+
+func (h localizedHandler) Handle(ctx context.Context, r slog.Record) error {
+	r.Message = tr(r.Message)
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h localizedHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return localizedHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h localizedHandler) WithGroup(name string) slog.Handler {
+	return localizedHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// lastChangeAt is when a cycle last detected changes, in Unix nanoseconds,
+// or 0 before the first one. Cycles set it while the main loop reads it.
+var lastChangeAt atomic.Int64
+
+// idleRemaining returns how long until the watcher has been idle for
+// --exit-after-idle, zero or less once it has. It counts from startup until
+// changes are detected.
+func idleRemaining() time.Duration {
+	since := startedAt
+	if changed := lastChangeAt.Load(); changed != 0 {
+		since = time.Unix(0, changed)
+	}
+	return exitAfterIdleFlag - time.Since(since)
+}
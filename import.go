@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/go-git/go-git/v6"
+)
+
+// importOrder sorts new stacks so that those declaring networks and volumes
+// come before the stacks using them as external, alphabetically otherwise.
+// Stacks caught in a dependency cycle keep their alphabetical order.
+func importOrder(repoPath string, changes []Change) []Change {
+	sort.Slice(changes, func(i, j int) bool { return changes[i].FilePath < changes[j].FilePath })
+
+	declaredBy := map[composeResource]int{}
+	external := make([][]composeResource, len(changes))
+	for i, change := range changes {
+		data, err := readWorktreeFile(repoPath, change.FilePath)
+		if err != nil {
+			continue
+		}
+		var declared []composeResource
+		external[i], declared = parseResources(change.FilePath, data)
+		for _, resource := range declared {
+			declaredBy[resource] = i
+		}
+	}
+
+	var ordered []Change
+	done := make([]bool, len(changes))
+	for len(ordered) < len(changes) {
+		progressed := false
+		for i, change := range changes {
+			if done[i] {
+				continue
+			}
+			ready := true
+			for _, resource := range external[i] {
+				if dep, ok := declaredBy[resource]; ok && dep != i && !done[dep] {
+					ready = false
+				}
+			}
+			if ready {
+				done[i] = true
+				ordered = append(ordered, change)
+				progressed = true
+				break
+			}
+		}
+
+		if !progressed {
+			for i, change := range changes {
+				if !done[i] {
+					done[i] = true
+					ordered = append(ordered, change)
+					break
+				}
+			}
+		}
+	}
+	return ordered
+}
+
+// runImport commits every untracked compose file of the worktree as a
+// created stack, one commit each, in dependency then alphabetical order
+func runImport(repo *git.Repository, args []string) error {
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return fmt.Errorf("failed to get worktree: %w", err)
+	}
+	repoPath := worktree.Filesystem.Root()
+
+	status, err := worktreeStatus(repo, worktree)
+	if err != nil {
+		return fmt.Errorf("failed to get status: %w", err)
+	}
+
+	var created []Change
+	for _, change := range findComposeChanges(status) {
+		if change.ChangeType == Created {
+			created = append(created, change)
+		}
+	}
+	if len(created) == 0 {
+		fmt.Println("No untracked compose files to import")
+		return nil
+	}
+
+	imported := 0
+	for _, change := range importOrder(repoPath, created) {
+		change.Class = classifyChange(repo, repoPath, change)
+		if err := commitStackChange(worktree, repo, change); err != nil {
+			opsLog.Error("Failed to import stack", "stack", change.StackName, "path", change.FilePath, "error", err)
+			continue
+		}
+		imported++
+	}
+	fmt.Printf("Imported %d of %d stacks\n", imported, len(created))
+
+	if pushFlag && imported > 0 {
+		return pushToRemote(repo)
+	}
+	return nil
+}
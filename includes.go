@@ -0,0 +1,180 @@
+package main
+
+import (
+	"path"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v6"
+	"gopkg.in/yaml.v3"
+)
+
+// composeInclude is an entry of a compose file's include: list, either a
+// path or a mapping with a path and env files
+type composeInclude struct {
+	Paths    []string
+	EnvFiles []string
+}
+
+func (i *composeInclude) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		i.Paths = []string{node.Value}
+		return nil
+	}
+	var entry struct {
+		Path    yaml.Node `yaml:"path"`
+		EnvFile yaml.Node `yaml:"env_file"`
+	}
+	if err := node.Decode(&entry); err != nil {
+		return err
+	}
+	i.Paths = scalars(&entry.Path)
+	i.EnvFiles = scalars(&entry.EnvFile)
+	return nil
+}
+
+// scalars returns the value of a string node, or the values of a list of
+// strings
+func scalars(node *yaml.Node) []string {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		return []string{node.Value}
+	case yaml.SequenceNode:
+		var values []string
+		for _, item := range node.Content {
+			if item.Kind == yaml.ScalarNode {
+				values = append(values, item.Value)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// includedPath resolves a path of include: against the directory of the
+// including file, as a repository path. Absolute paths, remote resources
+// and paths outside of the repository are left out.
+func includedPath(dir string, name string) (string, bool) {
+	if name == "" || path.IsAbs(name) || strings.Contains(name, "://") || strings.HasPrefix(name, "oci:") {
+		return "", false
+	}
+	resolved := path.Join(dir, name)
+	if resolved == ".." || strings.HasPrefix(resolved, "../") {
+		return "", false
+	}
+	return resolved, true
+}
+
+// composeIncludes returns the files a compose file includes, with their env
+// files and, recursively, the files they include
+func composeIncludes(repoPath string, composePath string) []string {
+	var files []string
+	seen := map[string]bool{composePath: true}
+	queue := []string{composePath}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		data, err := readWorktreeFile(repoPath, current)
+		if err != nil {
+			continue
+		}
+		var compose struct {
+			Include []composeInclude `yaml:"include"`
+		}
+		if err := decodeDocuments(data, &compose); err != nil {
+			continue
+		}
+
+		for _, include := range compose.Include {
+			for _, name := range include.Paths {
+				if resolved, ok := includedPath(path.Dir(current), name); ok && !seen[resolved] {
+					seen[resolved] = true
+					files = append(files, resolved)
+					queue = append(queue, resolved)
+				}
+			}
+			for _, name := range include.EnvFiles {
+				if resolved, ok := includedPath(path.Dir(current), name); ok && !seen[resolved] {
+					seen[resolved] = true
+					files = append(files, resolved)
+				}
+			}
+		}
+	}
+	return files
+}
+
+// withIncludes commits the changed files included by a compose file with
+// its stack, wherever they are in the repository: a file that changed alone
+// makes its stack updated. A file included by several stacks is committed
+// with the first one by path.
+func withIncludes(repo *git.Repository, repoPath string, status git.Status, changes []Change) []Change {
+	dirs := stackDirs(repo, status)
+	composePaths := make([]string, 0, len(dirs))
+	for _, composePath := range dirs {
+		composePaths = append(composePaths, composePath)
+	}
+	sort.Strings(composePaths)
+
+	includedBy := map[string]string{}
+	for _, composePath := range composePaths {
+		for _, name := range composeIncludes(repoPath, composePath) {
+			if _, ok := includedBy[name]; !ok && !isWatchedFile(name) {
+				includedBy[name] = composePath
+			}
+		}
+	}
+	if len(includedBy) == 0 {
+		return changes
+	}
+
+	// Files already committed as stack files stay with their stack
+	committed := map[string]bool{}
+	for _, change := range changes {
+		for _, name := range change.files() {
+			committed[name] = true
+		}
+	}
+
+	included := map[string][]string{}
+	for filePath, fileStatus := range status {
+		if fileStatus.Staging == git.Unmodified && fileStatus.Worktree == git.Unmodified {
+			continue
+		}
+		if composePath, ok := includedBy[filePath]; ok && !committed[filePath] {
+			included[composePath] = append(included[composePath], filePath)
+		}
+	}
+
+	for i, change := range changes {
+		if files, ok := included[change.FilePath]; ok {
+			changes[i].Included = files
+			delete(included, change.FilePath)
+		}
+	}
+	for _, composePath := range composePaths {
+		if files, ok := included[composePath]; ok {
+			changes = append(changes, Change{
+				StackName:  getStackName(composePath),
+				FilePath:   composePath,
+				ChangeType: Updated,
+				Included:   files,
+			})
+		}
+	}
+
+	for i := range changes {
+		slices.Sort(changes[i].Included)
+	}
+	return changes
+}
+
+// includedBody lists the included files committed with a stack change
+func includedBody(change Change) string {
+	if len(change.Included) == 0 {
+		return ""
+	}
+	return "Included files: " + strings.Join(change.Included, ", ")
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing"
+)
+
+// checkIntegrity runs lightweight consistency checks: HEAD resolves to a
+// readable commit and tree, the index can be read, and every reference points
+// to an existing object
+func checkIntegrity(repo *git.Repository) error {
+	head, err := repo.Head()
+	switch {
+	case errors.Is(err, plumbing.ErrReferenceNotFound):
+		// No commit yet
+	case err != nil:
+		return fmt.Errorf("HEAD does not resolve: %w", err)
+	default:
+		commit, err := repo.CommitObject(head.Hash())
+		if err != nil {
+			return fmt.Errorf("HEAD commit %s is unreadable: %w", head.Hash(), err)
+		}
+		if _, err := commit.Tree(); err != nil {
+			return fmt.Errorf("HEAD tree %s is unreadable: %w", commit.TreeHash, err)
+		}
+	}
+
+	if _, err := repo.Storer.Index(); err != nil {
+		return fmt.Errorf("index is unreadable: %w", err)
+	}
+
+	refs, err := repo.References()
+	if err != nil {
+		return fmt.Errorf("references are unreadable: %w", err)
+	}
+	defer refs.Close()
+
+	return refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference {
+			return nil
+		}
+		if _, err := repo.Storer.EncodedObject(plumbing.AnyObject, ref.Hash()); err != nil {
+			return fmt.Errorf("reference %s points to missing object %s: %w", ref.Name(), ref.Hash(), err)
+		}
+		return nil
+	})
+}
+
+// verifyRepository runs the integrity checks when due and reports whether the
+// cycle can proceed
+func verifyRepository(repo *git.Repository) bool {
+	if integrityIntervalFlag <= 0 {
+		return true
+	}
+	if !current.repoCorrupted && time.Since(current.lastIntegrityCheck) < integrityIntervalFlag {
+		return true
+	}
+
+	current.lastIntegrityCheck = time.Now()
+	err := checkIntegrity(repo)
+	if err != nil {
+		current.repoCorrupted = true
+		opsLog.Error("!!! REPOSITORY APPEARS CORRUPTED, skipping cycle until repaired !!!", "error", err)
+		checkRepoFailure(err)
+		return false
+	}
+
+	if current.repoCorrupted {
+		opsLog.Info("Repository integrity restored")
+		current.repoCorrupted = false
+	}
+	return true
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// interpolationPattern matches "$$" escapes, "${VAR}" with its optional
+// modifier (":-default", "-default", ":?error", "?error", ":+alt", "+alt")
+// and bare "$VAR" interpolations
+var interpolationPattern = regexp.MustCompile(`\$(?:\$|\{([A-Za-z_][A-Za-z0-9_]*)(:?[-?+][^}]*)?\}|([A-Za-z_][A-Za-z0-9_]*))`)
+
+// interpolatedVars returns the variables a compose file needs to be set, in
+// order of appearance: interpolations without a default or alternative value
+func interpolatedVars(data []byte) []string {
+	seen := map[string]bool{}
+	var vars []string
+	var walk func(node *yaml.Node)
+	walk = func(node *yaml.Node) {
+		if node.Kind == yaml.ScalarNode {
+			for _, match := range interpolationPattern.FindAllStringSubmatch(node.Value, -1) {
+				name, modifier := match[1], match[2]
+				if name == "" {
+					name = match[3]
+				}
+				if name == "" || seen[name] {
+					continue
+				}
+				// Defaults and alternative values cover unset variables
+				if op := strings.TrimPrefix(modifier, ":"); strings.HasPrefix(op, "-") || strings.HasPrefix(op, "+") {
+					continue
+				}
+				seen[name] = true
+				vars = append(vars, name)
+			}
+		}
+		for _, child := range node.Content {
+			walk(child)
+		}
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var doc yaml.Node
+		if err := decoder.Decode(&doc); err != nil {
+			break
+		}
+		walk(&doc)
+	}
+	return vars
+}
+
+// readEnvFile returns the variables set by a .env file, empty when missing
+func readEnvFile(data []byte) map[string]bool {
+	vars := map[string]bool{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, _, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		if ok {
+			vars[strings.TrimSpace(name)] = true
+		}
+	}
+	return vars
+}
+
+// unresolvedVars returns the variables of a compose file that its stack's
+// .env file doesn't set
+func unresolvedVars(repoPath string, filePath string, data []byte) []string {
+	envFile, err := readWorktreeFile(repoPath, path.Join(path.Dir(filePath), ".env"))
+	if err != nil && !os.IsNotExist(err) {
+		opsLog.Warn("Failed to read .env file", "path", filePath, "error", err)
+	}
+	defined := readEnvFile(envFile)
+
+	var missing []string
+	for _, name := range interpolatedVars(data) {
+		if !defined[name] {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// envBody returns the commit body line listing the variables a stack change
+// interpolates without a value, warning about each of them. It is empty when
+// the check is disabled or every variable resolves.
+func envBody(repoPath string, change Change) string {
+	if !checkEnvFlag || change.ChangeType == Deleted {
+		return ""
+	}
+
+	data, err := readWorktreeFile(repoPath, change.FilePath)
+	if err != nil {
+		return ""
+	}
+	missing := unresolvedVars(repoPath, change.FilePath, data)
+	if len(missing) == 0 {
+		return ""
+	}
+
+	for _, name := range missing {
+		eventLog.Warn("Interpolated variable has no value", "stack", change.StackName, "path", change.FilePath, "variable", name)
+	}
+	return fmt.Sprintf("Unresolved variables: %s", strings.Join(missing, ", "))
+}
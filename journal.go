@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/storage/filesystem"
+)
+
+// journalKey is the state store key of the journal, stored in
+// stack-watch-journal.json by the file store
+const journalKey = "journal"
+
+// journal records an in-flight stack commit so a cycle interrupted between
+// staging and committing can be recovered on the next start
+type journal struct {
+	Change  Change    `json:"change"`
+	Message string    `json:"message"`
+	Head    string    `json:"head"`
+	Started time.Time `json:"started"`
+}
+
+// gitDir returns the path of the repository's git directory
+func gitDir(repo *git.Repository) (string, error) {
+	storage, ok := repo.Storer.(*filesystem.Storage)
+	if !ok {
+		return "", errors.New("repository is not stored on the filesystem")
+	}
+	return storage.Filesystem().Root(), nil
+}
+
+// writeJournal records a stack change about to be staged and committed
+func writeJournal(repo *git.Repository, change Change, message string) error {
+	store, err := openStateStore(repo)
+	if err != nil {
+		return err
+	}
+
+	entry := journal{Change: change, Message: message, Started: time.Now()}
+	if head, err := repo.Head(); err == nil {
+		entry.Head = head.Hash().String()
+	}
+	return store.save(journalKey, entry)
+}
+
+// clearJournal removes the journal once its change is committed or abandoned
+func clearJournal(repo *git.Repository) {
+	store, err := openStateStore(repo)
+	if err != nil {
+		return
+	}
+	if err := store.remove(journalKey); err != nil {
+		opsLog.Warn("Failed to remove journal", "error", err)
+	}
+}
+
+// recoverJournal resolves a cycle interrupted by a crash: if its commit was
+// created nothing is left to do, otherwise the staged change is either
+// committed ('complete') or unstaged so the next cycle redetects it ('rollback')
+func recoverJournal(repo *git.Repository) error {
+	store, err := openStateStore(repo)
+	if err != nil {
+		return err
+	}
+
+	var entry journal
+	found, err := store.load(journalKey, &entry)
+	if !found && err == nil {
+		return nil
+	}
+	defer clearJournal(repo)
+	if err != nil {
+		return fmt.Errorf("failed to read journal: %w", err)
+	}
+
+	opsLog.Warn("Found interrupted cycle", "stack", entry.Change.StackName, "path", entry.Change.FilePath, "started", entry.Started)
+
+	head, err := repo.Head()
+	if err == nil && head.Hash().String() != entry.Head {
+		commit, err := repo.CommitObject(head.Hash())
+		if err == nil && commit.Message == entry.Message {
+			opsLog.Info("Interrupted commit was already created", "hash", head.Hash().String()[:7])
+			return nil
+		}
+	}
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return fmt.Errorf("failed to get worktree: %w", err)
+	}
+
+	status, err := worktree.Status()
+	if err != nil {
+		return fmt.Errorf("failed to get status: %w", err)
+	}
+
+	staged := false
+	for _, filePath := range entry.Change.files() {
+		fileStatus := status.File(filePath)
+		if fileStatus.Staging != git.Unmodified && fileStatus.Staging != git.Untracked {
+			staged = true
+		}
+	}
+	if !staged {
+		opsLog.Info("Nothing staged by interrupted cycle")
+		return nil
+	}
+
+	if resumeFlag == "complete" {
+		commit, err := worktree.Commit(entry.Message, commitOptions())
+		if err != nil {
+			return fmt.Errorf("failed to complete interrupted commit: %w", err)
+		}
+		eventLog.Info("✓ Completed interrupted commit", "stack", entry.Change.StackName, "hash", commit.String()[:7], "message", entry.Message)
+		return nil
+	}
+
+	err = worktree.Restore(&git.RestoreOptions{Staged: true, Files: entry.Change.files()})
+	if err != nil {
+		return fmt.Errorf("failed to unstage interrupted change: %w", err)
+	}
+	opsLog.Info("Rolled back interrupted change", "stack", entry.Change.StackName, "path", entry.Change.FilePath)
+
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+)
+
+// logEvents names the records of --log-format json that log aggregators key
+// on, by their message in English, as an "event" attribute
+var logEvents = map[string]string{
+	"Starting git-stack-watch":                     "watcher_start",
+	"Received interrupt signal, shutting down...":  "watcher_stop",
+	"Checking for compose file changes...":         "cycle_start",
+	"Done.":                                        "cycle_end",
+	"Change detected":                              "change_detected",
+	"Holding change":                               "change_held",
+	"✓ Created commit":                             "commit_created",
+	"Failed to commit":                             "commit_failed",
+	"Pushing to remote...":                         "push_start",
+	"✓ Successfully pushed to remote":              "push_succeeded",
+	"✓ Already up to date":                         "push_up_to_date",
+	"Push failed, retrying":                        "push_retry",
+	"Failed to push to remote":                     "push_failed",
+	"Deferring push until the next scheduled push": "push_deferred",
+	"Remote is reachable":                          "remote_reachable",
+	"Remote is unreachable":                        "remote_unreachable",
+}
+
+// eventHandler adds the event name of a record, before its message is
+// translated
+type eventHandler struct {
+	slog.Handler
+}
+
+func (h eventHandler) Handle(ctx context.Context, r slog.Record) error {
+	if event, ok := logEvents[r.Message]; ok {
+		r.AddAttrs(slog.String("event", event))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h eventHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return eventHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h eventHandler) WithGroup(name string) slog.Handler {
+	return eventHandler{h.Handler.WithGroup(name)}
+}
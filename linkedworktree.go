@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/config"
+	"github.com/go-git/go-git/v6/plumbing"
+)
+
+// openRepository opens the repository at path, resolving the common git
+// directory of linked worktrees created by 'git worktree add'
+func openRepository(path string) (*git.Repository, error) {
+	repo, err := git.PlainOpenWithOptions(path, &git.PlainOpenOptions{EnableDotGitCommonDir: true})
+	if err != nil {
+		return nil, err
+	}
+	return tuneStorage(repo)
+}
+
+// isLinkedWorktree reports whether the repository was opened from a linked
+// worktree, whose git directory refers to the main one through 'commondir'
+func isLinkedWorktree(repo *git.Repository) bool {
+	dir, err := gitDir(repo)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(filepath.Join(dir, "commondir"))
+	return err == nil
+}
+
+// pushRefSpecs limits pushes from a linked worktree to its checked out
+// branch, leaving the branches of the main worktree and its siblings alone,
+// and pushes only --branch or the stack branches when they're set. Other
+// repositories push with go-git's default refspecs.
+func pushRefSpecs(repo *git.Repository) ([]config.RefSpec, error) {
+	if stackBranchFlag != "" {
+		return stackBranchRefSpecs(), nil
+	}
+	if branchFlag != "" {
+		name := plumbing.NewBranchReferenceName(branchFlag)
+		return []config.RefSpec{config.RefSpec(name + ":" + name)}, nil
+	}
+	if !isLinkedWorktree(repo) {
+		return nil, nil
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve HEAD: %w", err)
+	}
+	if !head.Name().IsBranch() {
+		return nil, errors.New("linked worktree has a detached HEAD, there is no branch to push")
+	}
+
+	return []config.RefSpec{config.RefSpec(head.Name() + ":" + head.Name())}, nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/go-git/go-git/v6/config"
+)
+
+// addWorktree runs 'git worktree add' in the main worktree of a test
+// repository, returning the path of the linked worktree
+func addWorktree(t *testing.T, mainPath string, args ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "linked")
+	cmd := exec.Command("git", append([]string{"-C", mainPath, "worktree", "add"}, append(args, path)...)...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git worktree add: %v\n%s", err, out)
+	}
+	return path
+}
+
+func TestLinkedWorktree(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	tests := []struct {
+		name         string
+		args         []string
+		branch       string
+		stackBranch  string
+		linked       bool
+		wantRefSpecs []config.RefSpec
+		wantErr      bool
+	}{
+		{
+			name:   "main worktree",
+			linked: false,
+		},
+		{
+			name:         "linked worktree",
+			args:         []string{"-b", "feature"},
+			linked:       true,
+			wantRefSpecs: []config.RefSpec{"refs/heads/feature:refs/heads/feature"},
+		},
+		{
+			name:    "detached linked worktree",
+			args:    []string{"--detach"},
+			linked:  true,
+			wantErr: true,
+		},
+		{
+			name:         "linked worktree with --branch",
+			args:         []string{"-b", "feature"},
+			branch:       "main",
+			linked:       true,
+			wantRefSpecs: []config.RefSpec{"refs/heads/main:refs/heads/main"},
+		},
+		{
+			name:         "linked worktree with --stack-branch-prefix",
+			args:         []string{"-b", "feature"},
+			stackBranch:  "stacks/",
+			linked:       true,
+			wantRefSpecs: []config.RefSpec{"refs/heads/stacks/*:refs/heads/stacks/*"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			branch, stackBranch := branchFlag, stackBranchFlag
+			branchFlag, stackBranchFlag = tt.branch, tt.stackBranch
+			t.Cleanup(func() { branchFlag, stackBranchFlag = branch, stackBranch })
+
+			mainRepo, _ := initTestRepo(t)
+			worktree, err := mainRepo.Worktree()
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := worktree.Filesystem.Root()
+			if tt.linked {
+				path = addWorktree(t, path, tt.args...)
+			}
+
+			repo, err := openRepository(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := isLinkedWorktree(repo); got != tt.linked {
+				t.Errorf("isLinkedWorktree() = %v, want %v", got, tt.linked)
+			}
+
+			refSpecs, err := pushRefSpecs(repo)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("pushRefSpecs() error = %v, want error %v", err, tt.wantErr)
+			}
+			if !slices.Equal(refSpecs, tt.wantRefSpecs) {
+				t.Errorf("pushRefSpecs() = %v, want %v", refSpecs, tt.wantRefSpecs)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// errCommitHeld is returned when a change is deliberately left uncommitted
+// until the file is fixed
+var errCommitHeld = errors.New("commit held")
+
+// lintFile checks a compose file, returning one message per problem found.
+// It runs the configured external linter, or the embedded rules otherwise.
+func lintFile(repoPath string, filePath string) ([]string, error) {
+	path := filepath.Join(repoPath, filepath.FromSlash(filePath))
+
+	if lintCmdFlag != "" {
+		return runExternalLinter(path)
+	}
+
+	data, err := readWorktreeFile(repoPath, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return lintYAML(data), nil
+}
+
+// runExternalLinter runs the --lint-cmd command on a file, treating a non-zero
+// exit status as lint failure
+func runExternalLinter(path string) ([]string, error) {
+	args := strings.Fields(lintCmdFlag)
+	if len(args) == 0 {
+		return nil, errors.New("--lint-cmd has no command")
+	}
+	cmd := exec.Command(args[0], append(args[1:], path)...)
+
+	output, err := cmd.CombinedOutput()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		var problems []string
+		for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+			if line != "" {
+				problems = append(problems, line)
+			}
+		}
+		if len(problems) == 0 {
+			problems = append(problems, exitErr.Error())
+		}
+		return problems, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to run linter: %w", err)
+	}
+
+	return nil, nil
+}
+
+// lintYAML applies the embedded lint rules: valid syntax (including duplicate
+// keys), no tabs, no trailing spaces and a final newline
+func lintYAML(data []byte) []string {
+	var problems []string
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var doc yaml.Node
+		err := decoder.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("syntax: %v", err))
+			break
+		}
+	}
+
+	lines := strings.Split(string(data), "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(strings.TrimLeft(line, " "), "\t") {
+			problems = append(problems, fmt.Sprintf("line %d: tab used for indentation", i+1))
+		}
+		if strings.TrimRight(line, " \t") != line {
+			problems = append(problems, fmt.Sprintf("line %d: trailing spaces", i+1))
+		}
+	}
+
+	if len(data) > 0 && !bytes.HasSuffix(data, []byte("\n")) {
+		problems = append(problems, fmt.Sprintf("line %d: no new line character at the end of file", len(lines)))
+	}
+
+	return problems
+}
+
+// checkLint lints a changed file according to --lint, returning errCommitHeld
+// when the commit must wait for the file to be fixed
+func checkLint(repoPath string, change Change) error {
+	problems, err := lintFile(repoPath, change.FilePath)
+	if err != nil {
+		return fmt.Errorf("failed to lint file: %w", err)
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+
+	for _, problem := range problems {
+		eventLog.Warn("Lint failed", "stack", change.StackName, "path", change.FilePath, "problem", problem)
+	}
+
+	if lintFlag == "block" {
+		return fmt.Errorf("%w: %d lint problem(s) in %s", errCommitHeld, len(problems), change.FilePath)
+	}
+	return nil
+}
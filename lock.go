@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-git/go-git/v6"
+)
+
+// indexLockFile is the lock git takes while writing the index
+const indexLockFile = "index.lock"
+
+// lockHeld reports whether a running process has the file at path open, by
+// scanning the file descriptors listed in /proc
+func lockHeld(path string) (bool, error) {
+	fds, err := filepath.Glob("/proc/[0-9]*/fd/*")
+	if err != nil {
+		return false, err
+	}
+	if len(fds) == 0 {
+		return false, errors.New("/proc is not available to check open files")
+	}
+
+	for _, fd := range fds {
+		if target, err := os.Readlink(fd); err == nil && target == path {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// removeStaleLock deletes an index lock older than --stale-lock-age that no
+// process holds open, left behind by a crashed git process
+func removeStaleLock(path string, age time.Duration) error {
+	if staleLockAgeFlag <= 0 || age < staleLockAgeFlag {
+		return errors.New("lock is not stale")
+	}
+
+	held, err := lockHeld(path)
+	if err != nil {
+		return fmt.Errorf("can't tell whether the lock is held: %w", err)
+	}
+	if held {
+		return errors.New("lock is held by a running process")
+	}
+
+	return os.Remove(path)
+}
+
+// indexUnlocked reports whether the index is free for the cycle to stage
+// changes: another git process writing it makes the cycle wait, unless its
+// lock is stale and can be removed
+func indexUnlocked(repo *git.Repository) bool {
+	dir, err := gitDir(repo)
+	if err != nil {
+		return true
+	}
+	path := filepath.Join(dir, indexLockFile)
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return true
+	}
+	age := time.Since(info.ModTime()).Round(time.Second)
+
+	if err := removeStaleLock(path, age); err != nil {
+		opsLog.Warn("Index is locked by another git process, skipping cycle", "path", path, "age", age, "reason", err)
+		return false
+	}
+
+	opsLog.Warn("Removed stale index lock", "path", path, "age", age)
+	return true
+}
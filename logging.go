@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/go-git/go-git/v6/utils/trace"
+)
+
+// Two independent log streams: operational logs (startup, cycles, errors) and
+// change events (what was detected, committed and pushed). Their records are
+// labelled with the repository and cycle in progress, see cycleScope.
+var (
+	opsLevel   = new(slog.LevelVar)
+	eventLevel = new(slog.LevelVar)
+
+	opsLog   = slog.New(scopeHandler{Handler: newPlainHandler(os.Stderr, opsLevel)})
+	eventLog = slog.New(scopeHandler{Handler: newPlainHandler(os.Stderr, eventLevel)})
+
+	// processLog is the operational log of the goroutines serving the whole
+	// process, such as the HTTP endpoints, whose records never get the
+	// labels of a cycle running meanwhile
+	processLog = slog.New(scopeHandler{Handler: newPlainHandler(os.Stderr, opsLevel), scope: &logScope{}})
+)
+
+// setupLogging points each log stream at its configured destination and level
+func setupLogging() error {
+	if err := loadCatalog(localeFlag); err != nil {
+		return err
+	}
+	switch logFormatFlag {
+	case "plain", "pretty", "json":
+	default:
+		return fmt.Errorf("invalid --log-format %q, expected 'plain', 'pretty' or 'json'", logFormatFlag)
+	}
+
+	handler, err := newLogHandler(logOpsFlag, opsLevel)
+	if err != nil {
+		return fmt.Errorf("ops log: %w", err)
+	}
+	opsHandler := wrapLogHandler(teeHandler{handler, newRingHandler(opsLevel)})
+	opsLog = slog.New(scopeHandler{Handler: opsHandler})
+	processLog = slog.New(scopeHandler{Handler: opsHandler, scope: &logScope{}})
+
+	handler, err = newLogHandler(logEventsFlag, eventLevel)
+	if err != nil {
+		return fmt.Errorf("events log: %w", err)
+	}
+	eventLog = slog.New(scopeHandler{Handler: wrapLogHandler(teeHandler{handler, newRingHandler(eventLevel)})})
+
+	// Messages of the standard log package, such as net/http's, go to the
+	// operational logs
+	slog.SetDefault(processLog)
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(logLevelFlag)); err != nil {
+		return fmt.Errorf("log level: %w", err)
+	}
+	opsLevel.Set(level)
+	eventLevel.Set(level)
+	if logOpsLevelFlag != "" {
+		if err := opsLevel.UnmarshalText([]byte(logOpsLevelFlag)); err != nil {
+			return fmt.Errorf("ops log level: %w", err)
+		}
+	}
+	if logEventsLevelFlag != "" {
+		if err := eventLevel.UnmarshalText([]byte(logEventsLevelFlag)); err != nil {
+			return fmt.Errorf("events log level: %w", err)
+		}
+	}
+	traceGit()
+
+	return nil
+}
+
+// gitTraceWriter sends go-git's traces to the operational logs, a line each
+type gitTraceWriter struct{}
+
+func (gitTraceWriter) Write(p []byte) (int, error) {
+	opsLog.Debug("Git transport trace", "trace", strings.TrimRight(string(p), "\n"))
+	return len(p), nil
+}
+
+// traceGit has go-git trace its exchanges with remotes (pkt-lines, SSH and
+// HTTP requests) while operational logs are at debug level. Pack data and
+// credentials are masked by go-git.
+func traceGit() {
+	if opsLevel.Level() > slog.LevelDebug {
+		trace.SetTarget(0)
+		return
+	}
+	trace.SetLogger(log.New(gitTraceWriter{}, "", 0))
+	trace.SetTarget(trace.Packet | trace.SSH | trace.HTTP)
+}
+
+// wrapLogHandler adds the cycle, the repository and, in JSON, the event name
+// to the records of a log stream, and translates their message
+func wrapLogHandler(handler slog.Handler) slog.Handler {
+	handler = localizedHandler{handler}
+	if logFormatFlag == "json" {
+		handler = eventHandler{handler}
+	}
+	return handler
+}
+
+// fatal logs an operational error and exits
+func fatal(msg string, args ...any) {
+	opsLog.Error(msg, args...)
+	writeDebugBundle(fatalReason(msg, args), nil)
+	notify(notifyCrash, fatalReason(msg, args))
+	os.Exit(1)
+}
+
+// newLogHandler creates the handler for a log destination: 'stderr', 'stdout',
+// 'syslog' or a file path that is appended to. An empty target falls back to
+// --log-target. --log-format pretty only applies to stderr and stdout, files
+// and syslog being read by tools, and syslog stays plain with json.
+func newLogHandler(target string, level slog.Leveler) (slog.Handler, error) {
+	if target == "" {
+		target = logTargetFlag
+	}
+
+	var out io.Writer
+	switch target {
+	case "", "stderr":
+		out = os.Stderr
+	case "stdout":
+		out = os.Stdout
+	case "syslog":
+		return newSyslogHandler(level)
+	default:
+		file, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		out = file
+	}
+
+	if logFormatFlag == "pretty" && (out == os.Stderr || out == os.Stdout) {
+		return newPrettyHandler(out, level), nil
+	}
+	if logFormatFlag == "json" {
+		return slog.NewJSONHandler(out, &slog.HandlerOptions{Level: level}), nil
+	}
+	return newPlainHandler(out, level), nil
+}
+
+// plainHandler renders records as human readable lines:
+// "2006/01/02 15:04:05 LEVEL message key=value ..."
+type plainHandler struct {
+	write  func(level slog.Level, line string) error
+	level  slog.Leveler
+	attrs  string
+	prefix string
+
+	// header controls whether lines start with the time and level, which
+	// syslog records on its own
+	header bool
+}
+
+func newPlainHandler(out io.Writer, level slog.Leveler) *plainHandler {
+	mu := &sync.Mutex{}
+	write := func(_ slog.Level, line string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		_, err := io.WriteString(out, line+"\n")
+		return err
+	}
+	return &plainHandler{write: write, level: level, header: true}
+}
+
+func (h *plainHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *plainHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	if h.header {
+		b.WriteString(r.Time.Format("2006/01/02 15:04:05 "))
+		b.WriteString(r.Level.String())
+		b.WriteByte(' ')
+	}
+	b.WriteString(r.Message)
+	b.WriteString(h.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		writeAttr(&b, h.prefix, a)
+		return true
+	})
+
+	return h.write(r.Level, b.String())
+}
+
+func (h *plainHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var b strings.Builder
+	for _, a := range attrs {
+		writeAttr(&b, h.prefix, a)
+	}
+	clone := *h
+	clone.attrs += b.String()
+	return &clone
+}
+
+func (h *plainHandler) WithGroup(name string) slog.Handler {
+	clone := *h
+	clone.prefix += name + "."
+	return &clone
+}
+
+// writeAttr appends " key=value", quoting values that contain spaces
+func writeAttr(b *strings.Builder, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, child := range a.Value.Group() {
+			writeAttr(b, prefix, child)
+		}
+		return
+	}
+
+	value := a.Value.String()
+	if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+		value = strconv.Quote(value)
+	}
+
+	b.WriteByte(' ')
+	b.WriteString(prefix + a.Key)
+	b.WriteByte('=')
+	b.WriteString(value)
+}
+
+// configuredLevels holds the levels of both log streams while debug logging
+// is toggled on at runtime
+var configuredLevels *[2]slog.Level
+
+// toggleDebug switches both log streams to debug, or back to their configured
+// levels when debug was already toggled on
+func toggleDebug() {
+	if configuredLevels == nil {
+		configuredLevels = &[2]slog.Level{opsLevel.Level(), eventLevel.Level()}
+		opsLevel.Set(slog.LevelDebug)
+		eventLevel.Set(slog.LevelDebug)
+		traceGit()
+		processLog.Info("Debug logging enabled")
+		return
+	}
+
+	opsLevel.Set(configuredLevels[0])
+	eventLevel.Set(configuredLevels[1])
+	configuredLevels = nil
+	traceGit()
+	processLog.Info("Debug logging disabled", "ops_level", opsLevel.Level(), "events_level", eventLevel.Level())
+}
+
+// logScope labels log records with the repository worked on, when several
+// are watched, and the ID of the cycle in progress
+type logScope struct {
+	repo string
+	run  string
+}
+
+// cycleScope is the scope of the work holding cycleMu, nil in between. Only
+// that work logs while it's set, other goroutines use processLog or the
+// loggers of their repository.
+var cycleScope atomic.Pointer[logScope]
+
+// enterScope sets the scope of the work in progress, returning the function
+// restoring the previous one
+func enterScope(scope logScope) (leave func()) {
+	previous := cycleScope.Swap(&scope)
+	return func() { cycleScope.Store(previous) }
+}
+
+// currentScope returns the scope of the work in progress, empty in between
+func currentScope() logScope {
+	if scope := cycleScope.Load(); scope != nil {
+		return *scope
+	}
+	return logScope{}
+}
+
+// newRunID returns a short random ID for a cycle
+func newRunID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// scopeHandler adds the labels of a scope to every record: those of the work
+// in progress, or fixed ones for the loggers of a repository's goroutines
+type scopeHandler struct {
+	slog.Handler
+	scope *logScope
+}
+
+func (h scopeHandler) Handle(ctx context.Context, r slog.Record) error {
+	scope := h.scope
+	if scope == nil {
+		scope = cycleScope.Load()
+	}
+	if scope != nil && scope.repo != "" {
+		r.AddAttrs(slog.String("repo", scope.repo))
+	}
+	if scope != nil && scope.run != "" {
+		r.AddAttrs(slog.String("run", scope.run))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h scopeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return scopeHandler{h.Handler.WithAttrs(attrs), h.scope}
+}
+
+func (h scopeHandler) WithGroup(name string) slog.Handler {
+	return scopeHandler{h.Handler.WithGroup(name), h.scope}
+}
+
+// withScope returns a logger of the same stream with a fixed scope
+func withScope(logger *slog.Logger, scope logScope) *slog.Logger {
+	handler := logger.Handler()
+	if scoped, ok := handler.(scopeHandler); ok {
+		handler = scoped.Handler
+	}
+	return slog.New(scopeHandler{Handler: handler, scope: &scope})
+}
@@ -1,17 +1,18 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
-	"log"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/go-git/go-git/v6"
-	"github.com/go-git/go-git/v6/plumbing/transport/ssh"
+	"github.com/go-git/go-git/v6/plumbing/transport"
 )
 
 // enum ChangeType
@@ -21,12 +22,41 @@ const (
 	Created ChangeType = "created"
 	Updated ChangeType = "updated"
 	Deleted ChangeType = "deleted"
+	Renamed ChangeType = "renamed"
+
+	// Archived is only used by the archive command, the watcher never
+	// detects it
+	Archived ChangeType = "archived"
 )
 
+// gitmojis maps each change type to the gitmoji prefixed to its commit message
+var gitmojis = map[ChangeType]string{
+	Created:  "✨",
+	Updated:  "♻️",
+	Deleted:  "🔥",
+	Renamed:  "🚚",
+	Archived: "⚰️",
+}
+
 type Change struct {
 	StackName  string
 	FilePath   string
 	ChangeType ChangeType
+	Class      string
+	Extras     []string
+	Approvers  []string
+
+	// OldPath is the compose file a renamed stack was moved from
+	OldPath string
+
+	// Included are the changed files the compose file includes
+	Included []string
+
+	// Aliases are the past names of the stack, when it was renamed
+	Aliases []string
+
+	// Sequence is the number of the stack's commit, from its metadata
+	Sequence int
 }
 
 const (
@@ -34,141 +64,873 @@ const (
 )
 
 var (
-	repoFlag       string
-	pushFlag       bool
-	authMethodFlag string
+	repoFlag           stringList
+	sourceFlag         string
+	remoteURLFlag      string
+	patternFlag        stringList
+	notifyCmdFlag      string
+	notifyEventsFlag   string
+	webhookURLFlag     string
+	webhookEventsFlag  string
+	discordWebhookFlag string
+	slackWebhookFlag   string
+	chatEventsFlag     string
+	ntfyURLFlag        string
+	ntfyTopicFlag      string
+	ntfyTokenFlag      string
+	gotifyURLFlag      string
+	gotifyTokenFlag    string
+	escalateAfterFlag  int
+	digestIntervalFlag time.Duration
+	digestToFlag       string
+	smtpAddrFlag       string
+	smtpUserFlag       string
+	smtpPasswordFlag   string
+	smtpFromFlag       string
+	configFlag         string
+	intervalFlag       time.Duration
+	exitAfterIdleFlag  time.Duration
+	onceFlag           bool
+	pushFlag           bool
+	pushIntervalFlag   time.Duration
+	pushWindowFlag     string
+	timezoneFlag       string
+	branchFlag         string
+	checkoutBranchFlag bool
+	stackBranchFlag    string
+	metadataFlag       string
+	stateStoreFlag     string
+	stateScopeFlag     string
+	dryRunFlag         bool
+	pullFlag           string
+	pushRetriesFlag    int
+	pushBackoffFlag    time.Duration
+	authMethodFlag     string
+	heartbeatFlag      time.Duration
+	normalizeFlag      bool
+	stackDirFlag       bool
+	modeChangesFlag    string
+	symlinksFlag       string
+	stackIntervalFlag  string
+	formatFlag         bool
+	gitmojiFlag        bool
+	commitTemplateFlag string
+	commitStyleFlag    string
+	authorNameFlag     string
+	authorEmailFlag    string
+	gpgKeyFlag         string
+	gpgPassphraseFlag  string
+	gpgAgentKeyFlag    string
+	sshSignFlag        bool
+	sshSignKeyFlag     string
+	runTrailerFlag     bool
+	classTrailerFlag   bool
+	skipClassesFlag    string
+
+	formatIndentFlag   int
+	lintFlag           string
+	lintCmdFlag        string
+	conflictsFlag      string
+	checkExternalsFlag bool
+	knownExternalsFlag string
+	checkEnvFlag       bool
+
+	approveDeletionsFlag int
+	approversFlag        string
 
-	sshkeyPath string
+	logTargetFlag      string
+	logOpsFlag         string
+	logEventsFlag      string
+	logLevelFlag       string
+	logOpsLevelFlag    string
+	logEventsLevelFlag string
+	localeFlag         string
+	logFormatFlag      string
+	failFastFlag       string
+	failFastPushesFlag int
+	resumeFlag         string
+
+	integrityIntervalFlag time.Duration
+	minFreeSpaceFlag      byteSize
+	clutterSizeFlag       byteSize
+	staleLockAgeFlag      time.Duration
+	maxDiffSizeFlag       byteSize = 4 << 10
+
+	objectCacheSizeFlag      byteSize = 96 << 20
+	largeObjectThresholdFlag byteSize
+	reopenIntervalFlag       time.Duration
+
+	scanDepthFlag  int
+	maxFilesFlag   int
+	skipDirsFlag   string
+	archiveDirFlag string
+
+	watchFlag     bool
+	watchPollFlag time.Duration
+
+	probeIntervalFlag   time.Duration
+	tokenExpiryFlag     string
+	tokenExpiryWarnFlag time.Duration
+	remoteCompatFlag    string
+	unshallowFlag       bool
+	pprofAddrFlag       string
+	statusAddrFlag      string
+	metricsAddrFlag     string
+	healthAddrFlag      string
+	apiTokenFlag        string
+	apiViewerTokenFlag  string
+	apiAllowFlag        string
+	apiTLSCertFlag      string
+	apiTLSKeyFlag       string
+	apiClientCAFlag     string
+	logBufferFlag       int
+	debugBundleDirFlag  string
+
+	httpUserFlag        string
+	httpPasswordFlag    string
+	httpsTokenFlag      string
+	proxyUserFlag       string
+	proxyPasswordFlag   string
+	proxyAuthHeaderFlag string
+
+	sshKeyFlag               string
+	sshKeyPassphraseFileFlag string
+	sshkeyPath               string
 )
 
 func main() {
-	// Define flags
-	flag.StringVar(&repoFlag, "repo", "", "/path/to/repo")
-	flag.BoolVar(&pushFlag, "push", false, "Push to remote after committing changes")
-	flag.StringVar(&authMethodFlag, "auth", "", "Auth method for the repo ('ssh', 'http', or empty for no auth)")
+	// Run a subcommand if one is given
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			runCommand(os.Args[1], cmd, os.Args[2:])
+			return
+		}
+	}
+
+	defineFlags(flag.CommandLine)
+	activeFlags = flag.CommandLine
 	flag.Parse()
+	if err := loadConfig(flag.CommandLine); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid config file %s: %v\n", configFlag, err)
+		os.Exit(1)
+	}
 
 	// Get repository path from remaining args
-	if repoFlag == "" {
+	if len(repoFlag) == 0 && sourceFlag == "" {
 		fmt.Println("Usage: git-stack-watch [OPTIONS] --repo <repository-path>")
+		fmt.Println("       git-stack-watch <command> [OPTIONS] --repo <repository-path>")
+		fmt.Println("\nCommands:")
+		printCommands()
 		fmt.Println("\nOptions:")
 		flag.PrintDefaults()
 		fmt.Println("\nExample: git-stack-watch --repo /path/to/repo --push")
 		os.Exit(1)
 	}
 
-	// Define Auth method
-	if authMethodFlag == "ssh" {
-		log.Println("Auth method: SSH")
-		log.Println("Will now check for a correct SSH Key Path...")
+	initOptions()
 
-		keypath := os.Getenv("SSHKEY_PATH")
-		if keypath != "" {
-			sshkeyPath = keypath
-			log.Printf("Using SSH key at %s\n", sshkeyPath)
-		} else {
-			sshkeyPath = "/root/.ssh/id_ed25519"
-			log.Printf("No SSHKEY_PATH env set, using default SSH key path at %s\n", sshkeyPath)
+	// Notify unclean shutdowns from now on
+	notifying = true
+	defer notifyPanic()
+
+	setupAuth()
+
+	// Remote-first mode works in a clone of the remote, not in --source
+	if sourceFlag != "" {
+		path, cleanup, err := prepareMirror()
+		if err != nil {
+			fatal("Failed to prepare the remote-first clone", "error", err)
 		}
-	} else if authMethodFlag == "http" {
-		log.Panicln("Not implemented yet!")
-	} else {
-		log.Println("No Auth method!")
+		defer cleanup()
+		repoFlag = stringList{path}
 	}
 
-	// Open the git repository
-	repo, err := git.PlainOpen(repoFlag)
-	if err != nil {
-		log.Fatalf("Failed to open repository: %v", err)
+	opsLog.Info("Starting git-stack-watch", "repo", repoFlag.String())
+	if pprofAddrFlag != "" {
+		if err := servePprof(pprofAddrFlag); err != nil {
+			opsLog.Error("Failed to serve profiling endpoints", "addr", pprofAddrFlag, "error", err)
+		}
 	}
 
-	log.Printf("Starting git-stack-watch for repository: %s", repoFlag)
-	log.Printf("Checking for changes every 29 minutes...")
+	// Open the git repositories. A repository that can't be opened is skipped
+	// so the others are still watched.
+	var watched []*watchedRepo
+	for _, path := range repoFlag {
+		w, err := openWatchedRepo(path, len(repoFlag) > 1)
+		if err != nil {
+			if len(repoFlag) == 1 {
+				fatal("Failed to open repository", "repo", path, "error", err)
+			}
+			opsLog.Error("Failed to open repository, not watching it", "repo", path, "error", err)
+			continue
+		}
+		watched = append(watched, w)
+	}
+	if len(watched) == 0 {
+		fatal("None of the repositories could be opened")
+	}
+	statusRepos = watched
+	if statusAddrFlag != "" {
+		if err := serveStatus(statusAddrFlag); err != nil {
+			opsLog.Error("Failed to serve the status API", "addr", statusAddrFlag, "error", err)
+		}
+	}
+	if metricsAddrFlag != "" {
+		if err := serveMetrics(metricsAddrFlag); err != nil {
+			opsLog.Error("Failed to serve Prometheus metrics", "addr", metricsAddrFlag, "error", err)
+		}
+	}
+	if healthAddrFlag != "" {
+		if err := serveHealth(healthAddrFlag); err != nil {
+			opsLog.Error("Failed to serve health endpoints", "addr", healthAddrFlag, "error", err)
+		}
+	}
+
+	if onceFlag {
+		opsLog.Info("Running a single cycle")
+	} else {
+		opsLog.Info("Checking for changes periodically", "interval", intervalFlag)
+	}
+	if dryRunFlag {
+		opsLog.Warn("Dry run, changes are printed instead of committed and pushed")
+	}
 	if pushFlag {
-		log.Println("/!\\ Auto-push to remote is enabled.")
+		opsLog.Warn("/!\\ Auto-push to remote is enabled.")
+		if pushIntervalFlag > 0 {
+			opsLog.Info("Pushing commits together periodically", "push_interval", pushIntervalFlag)
+		}
+		if len(pushWindows) > 0 {
+			opsLog.Info("Pushing commits within windows", "push_window", pushWindowFlag)
+		}
+	}
+	if heartbeatFlag > 0 && stackBranchFlag != "" {
+		opsLog.Warn("Heartbeat commits are skipped with --stack-branch-prefix, which never commits to the checked out branch")
+	} else if heartbeatFlag > 0 {
+		opsLog.Info("Heartbeat commits enabled", "interval", heartbeatFlag)
+	}
+	if exitAfterIdleFlag > 0 {
+		opsLog.Info("Exiting once idle", "idle", exitAfterIdleFlag)
 	}
-	log.Println("Press Ctrl+C to stop")
+	if onceFlag {
+		os.Exit(runOnce(watched))
+	}
+	opsLog.Info("Press Ctrl+C to stop")
+	notify(notifyStart, "")
 
-	// Create a ticker that fires every 29 minutes
-	ticker := time.NewTicker(Delay)
-	defer ticker.Stop()
+	// Tell a Type=notify systemd unit that the repositories are open
+	sdNotify("READY=1\nSTATUS=Watching " + repoFlag.String())
+	if timeout := watchdogTimeout(); timeout > 0 {
+		opsLog.Info("Pinging the systemd watchdog", "watchdog", timeout)
+		go watchdog(timeout)
+	}
 
 	// Create a channel to listen for interrupt signals
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM, syscall.SIGINT)
 
-	// Run immediately on startup
-	checkAndCommit(repo, repoFlag)
+	// SIGUSR2 toggles debug logging without a restart
+	verbosityChan := make(chan os.Signal, 1)
+	notifyVerbosity(verbosityChan)
+
+	// Exit once no changes were detected for --exit-after-idle
+	var idleTimeouts <-chan time.Time
+	var idleTimer *time.Timer
+	if exitAfterIdleFlag > 0 {
+		idleTimer = time.NewTimer(exitAfterIdleFlag)
+		defer idleTimer.Stop()
+		idleTimeouts = idleTimer.C
+	}
+
+	// Send a digest every --digest-interval
+	var digestTicks <-chan time.Time
+	if digestIntervalFlag > 0 {
+		digestTicker := time.NewTicker(digestIntervalFlag)
+		defer digestTicker.Stop()
+		digestTicks = digestTicker.C
+	}
+
+	// Each repository runs its cycles on its own schedule
+	for _, w := range watched {
+		go w.watch()
+	}
 
 	// Main loop
 	for {
 		select {
-		case <-ticker.C:
-			// Ticker fired - check for changes and commit
-			checkAndCommit(repo, repoFlag)
-		case <-sigChan:
-			// Received interrupt signal - gracefully shutdown
-			fmt.Println("\nReceived interrupt signal, shutting down...")
+		case <-idleTimeouts:
+			// Changes detected since the timer started push the deadline back
+			if remaining := idleRemaining(); remaining > 0 {
+				idleTimer.Reset(remaining)
+				continue
+			}
+			close(shuttingDown)
+			cycleMu.Lock()
+			sdNotify("STOPPING=1")
+			opsLog.Info("No changes detected for a while, exiting", "idle", exitAfterIdleFlag)
+			notify(notifyStop, fmt.Sprintf("no changes for %s", exitAfterIdleFlag))
+			return
+		case <-digestTicks:
+			sendDigest(watched)
+		case <-verbosityChan:
+			toggleDebug()
+		case sig := <-sigChan:
+			// Received interrupt signal - gracefully shutdown once the
+			// cycle in progress is done
+			close(shuttingDown)
+			cycleMu.Lock()
+			sdNotify("STOPPING=1")
+			opsLog.Info("Received interrupt signal, shutting down...")
+			notify(notifyStop, fmt.Sprintf("received %s", sig))
 			return
 		}
 	}
 }
 
+// defineFlags registers the options shared by the watcher and subcommands
+func defineFlags(fs *flag.FlagSet) {
+	fs.Var(&patternFlag, "pattern", "Glob of other files to track besides compose.yml and compose.yaml, e.g. 'docker-compose*.yml' or '*.env' (repeatable)")
+	fs.StringVar(&notifyCmdFlag, "notify-cmd", "", "Command run with a message as last argument when the watcher starts or stops")
+	fs.StringVar(&notifyEventsFlag, "notify-events", "start,stop,crash,diverged", "Comma separated events notified by --notify-cmd ('start', 'stop', 'crash' or 'diverged')")
+	fs.StringVar(&webhookURLFlag, "webhook-url", "", "URL a JSON document is posted to when a stack commit is created, a push fails or a stack is deleted (default: WEBHOOK_URL env, none)")
+	fs.StringVar(&webhookEventsFlag, "webhook-events", "commit,push_failed,stack_deleted", "Comma separated events posted to --webhook-url ('commit', 'push_failed' or 'stack_deleted')")
+	fs.StringVar(&discordWebhookFlag, "discord-webhook", "", "Discord webhook URL messages like '✅ updated web — c6b9c5a' are posted to (default: DISCORD_WEBHOOK_URL env, none)")
+	fs.StringVar(&slackWebhookFlag, "slack-webhook", "", "Slack incoming webhook URL messages like '✅ updated web — c6b9c5a' are posted to (default: SLACK_WEBHOOK_URL env, none)")
+	fs.StringVar(&chatEventsFlag, "chat-events", "commit,push_failed,stack_deleted", "Comma separated events posted to --discord-webhook, --slack-webhook, --ntfy-url and --gotify-url ('commit', 'push_failed' or 'stack_deleted')")
+	fs.StringVar(&ntfyURLFlag, "ntfy-url", "", "ntfy server push notifications are published to, e.g. https://ntfy.sh (default: disabled)")
+	fs.StringVar(&ntfyTopicFlag, "ntfy-topic", "", "ntfy topic push notifications are published to")
+	fs.StringVar(&ntfyTokenFlag, "ntfy-token", "", "Access token of a protected ntfy topic (default: NTFY_TOKEN env, none)")
+	fs.StringVar(&gotifyURLFlag, "gotify-url", "", "Gotify server push notifications are sent to, e.g. https://gotify.example.com (default: disabled)")
+	fs.StringVar(&gotifyTokenFlag, "gotify-token", "", "Token of the Gotify application sending push notifications (default: GOTIFY_TOKEN env)")
+	fs.IntVar(&escalateAfterFlag, "escalate-after", 3, "Consecutive push failures after which their ntfy and Gotify notifications become urgent (0 to never escalate)")
+	fs.DurationVar(&digestIntervalFlag, "digest-interval", 0, "Send a digest of the commits, held changes, failures and drifting stacks of each repository at this interval, e.g. 168h for a weekly one (0 to disable)")
+	fs.StringVar(&digestToFlag, "digest-to", "", "Comma separated email addresses the digest is sent to, through --smtp-addr")
+	fs.StringVar(&smtpAddrFlag, "smtp-addr", "", "SMTP server emails are sent through, e.g. smtp.example.com:587")
+	fs.StringVar(&smtpUserFlag, "smtp-user", "", "Username authenticating to --smtp-addr (default: no authentication)")
+	fs.StringVar(&smtpPasswordFlag, "smtp-password", "", "Password of --smtp-user (default: SMTP_PASSWORD env)")
+	fs.StringVar(&smtpFromFlag, "smtp-from", "", "Sender address of emails")
+	fs.Var(&repoFlag, "repo", "/path/to/repo, repeated or comma separated to watch several repositories")
+	fs.StringVar(&sourceFlag, "source", "", "Read-only directory of stacks copied into a clone of --remote-url every cycle (remote-first mode)")
+	fs.StringVar(&remoteURLFlag, "remote-url", "", "Remote cloned by --source mode, into --repo or a private temporary directory")
+	fs.StringVar(&configFlag, "config", "", "YAML or TOML file of options keyed by flag name, overridden by the command line")
+	fs.StringVar(&sshKeyFlag, "ssh-key", "", "Path to the SSH private key (default: SSHKEY_PATH env or /root/.ssh/id_ed25519)")
+	fs.StringVar(&sshKeyPassphraseFileFlag, "ssh-key-passphrase-file", "", "File holding the passphrase of the SSH key, e.g. a Docker secret (default: SSHKEY_PASSPHRASE env)")
+	fs.StringVar(&branchFlag, "branch", "", "Branch the watcher commits to; cycles are skipped while another branch is checked out (default: any)")
+	fs.BoolVar(&checkoutBranchFlag, "checkout-branch", false, "Check out --branch, creating it when missing, instead of skipping cycles on another branch")
+	fs.StringVar(&stackBranchFlag, "stack-branch-prefix", "", "Commit each stack's changes to its own branch named <prefix><stack>, e.g. 'stacks/', leaving the checked out branch untouched (default: commit to the checked out branch)")
+	fs.StringVar(&metadataFlag, "metadata", "git", "Where to keep the repository metadata (stack aliases, sequences, held changes): 'git' with the --state-store state, 'ignored' or 'committed' in .stack-watch/ in the worktree")
+	fs.StringVar(&stateStoreFlag, "state-store", "", "Where to keep the state of every repository: 'file' in its git directory, 'sqlite:<path>' or a 'postgres://' URL shared by a fleet (default: STATE_STORE env or file)")
+	fs.StringVar(&stateScopeFlag, "state-scope", "", "Name of this watcher in a shared --state-store database (default: the hostname)")
+	fs.BoolVar(&dryRunFlag, "dry-run", false, "Print the commits each cycle would create and what would be pushed, without touching the index, the branches or the remote")
+	fs.BoolVar(&pushFlag, "push", false, "Push to remote after committing changes")
+	fs.DurationVar(&pushIntervalFlag, "push-interval", 0, "With --push, push the commits of several cycles together at this interval, e.g. 2h (0 to push after every cycle)")
+	fs.StringVar(&pushWindowFlag, "push-window", "", "With --push, comma separated daily HH:MM-HH:MM windows outside of which commits are kept local, e.g. 22:00-06:00")
+	fs.StringVar(&timezoneFlag, "timezone", "", "IANA time zone of push windows, log timestamps and commit dates, e.g. Europe/Paris (default: TZ env or the system's)")
+	fs.IntVar(&pushRetriesFlag, "push-retries", 3, "How many times a push failing on a network error is retried right away (0 to wait for the next cycle)")
+	fs.DurationVar(&pushBackoffFlag, "push-backoff", 5*time.Second, "Delay before the first push retry, doubled after each one up to --interval")
+	fs.StringVar(&pullFlag, "pull", "", "Before pushing, fetch the remote branch and fast-forward ('ff') or rebase ('rebase') the local commits on top of it (default: push only)")
+	fs.DurationVar(&intervalFlag, "interval", 0, "How often to check for changes, e.g. 2m or 6h (default: CHECK_INTERVAL env or 29m)")
+	fs.DurationVar(&exitAfterIdleFlag, "exit-after-idle", 0, "Exit once no changes were detected for this long, e.g. 24h (0 to never exit)")
+	fs.BoolVar(&onceFlag, "once", false, "Run a single cycle and exit, for systemd timers and cron: status 0 when nothing changed, 2 when commits were created, 1 on errors")
+	fs.StringVar(&authMethodFlag, "auth", "", "Comma separated auth methods tried in order when the previous one is rejected ('ssh', 'http', 'token' or 'none'; default: picked from the remote URL)")
+	fs.StringVar(&modeChangesFlag, "mode-changes", "commit", "What to do with changes to a compose file's mode bits only ('commit', 'ignore' or 'warn')")
+	fs.StringVar(&stackIntervalFlag, "stack-min-interval", "", "Comma separated stack=duration pairs, e.g. 'traefik=1h': a stack is committed at most once per duration, with its final state once it elapsed")
+	fs.StringVar(&symlinksFlag, "symlinks", "link", "How symlinked watched files are committed: as the link itself ('link'), as their target's content ('follow'), or not at all with a warning ('skip')")
+	fs.BoolVar(&stackDirFlag, "stack-dir", false, "Commit the other changed files of a stack's directory (.env, configuration...) with its compose file")
+	fs.BoolVar(&normalizeFlag, "normalize", false, "Ignore compose file changes that don't alter the parsed YAML content")
+	fs.BoolVar(&formatFlag, "format", false, "Reformat changed compose files with a canonical YAML style before committing")
+	fs.IntVar(&formatIndentFlag, "format-indent", 2, "Indentation width used by --format")
+	fs.StringVar(&lintFlag, "lint", "", "Lint changed compose files before committing ('warn', 'block', or empty to disable)")
+	fs.StringVar(&lintCmdFlag, "lint-cmd", "", "External linter command used by --lint, e.g. 'yamllint -f parsable' (default: embedded rules)")
+	fs.StringVar(&conflictsFlag, "conflicts", "", "Check changed compose files for host ports and container names used by other services ('warn', 'block', or empty to disable)")
+	fs.BoolVar(&checkExternalsFlag, "check-externals", false, "Flag external networks and volumes that no compose file of the repository declares")
+	fs.StringVar(&knownExternalsFlag, "known-externals", "", "Comma separated external networks and volumes that exist outside of the repository")
+	fs.BoolVar(&checkEnvFlag, "check-env", false, "Flag ${VAR} interpolations of changed compose files that the stack's .env file doesn't set")
+	fs.IntVar(&approveDeletionsFlag, "approve-deletions", 0, "Number of distinct --approvers a stack deletion needs before it's committed, through POST /approve on --status-addr (0 to commit deletions right away)")
+	fs.StringVar(&approversFlag, "approvers", "", "Comma separated name:token pairs of the people allowed to approve deletions (default: APPROVERS env, none)")
+	fs.BoolVar(&gitmojiFlag, "gitmoji", false, "Prefix commit messages with the gitmoji of the change type")
+	fs.StringVar(&commitStyleFlag, "commit-style", "plain", "Style of commit subjects: 'plain' (\"updated komodo\") or 'conventional' (\"chore(komodo): update compose\")")
+	fs.StringVar(&authorNameFlag, "author-name", "", "Name of the author and committer of the watcher's commits, e.g. 'stack-watch bot' (default: git config)")
+	fs.StringVar(&authorEmailFlag, "author-email", "", "Email of the author and committer of the watcher's commits (default: git config)")
+	fs.StringVar(&gpgKeyFlag, "gpg-key", "", "Path to an OpenPGP private key signing the watcher's commits (armored or binary)")
+	fs.StringVar(&gpgPassphraseFlag, "gpg-passphrase", "", "Passphrase of --gpg-key (default: GPG_PASSPHRASE env)")
+	fs.StringVar(&gpgAgentKeyFlag, "gpg-agent-key", "", "ID of a key signing the watcher's commits through gpg and gpg-agent")
+	fs.BoolVar(&sshSignFlag, "ssh-sign", false, "Sign the watcher's commits in git's SSH format with the push key (--ssh-key)")
+	fs.StringVar(&sshSignKeyFlag, "ssh-sign-key", "", "Path to the SSH private key signing the watcher's commits, instead of the push key")
+	fs.StringVar(&commitTemplateFlag, "commit-template", "", "Go text/template of commit messages, e.g. 'chore({{.Stack}}): {{.ChangeType}} {{.FilePath}}'")
+	fs.BoolVar(&runTrailerFlag, "run-trailer", false, "Add a Run-Id trailer with the ID of the cycle that created the commit")
+	fs.BoolVar(&classTrailerFlag, "class-trailer", false, "Add a Change-Class trailer: 'cosmetic', 'configuration' or 'deployment'")
+	fs.StringVar(&skipClassesFlag, "skip-classes", "", "Comma separated change classes not to commit, e.g. 'cosmetic'")
+	fs.DurationVar(&heartbeatFlag, "heartbeat", 0, "Create a heartbeat commit at this interval, e.g. 24h (0 to disable)")
+	fs.StringVar(&logTargetFlag, "log-target", "stderr", "Default destination of all logs ('stderr', 'stdout', 'syslog' or a file path)")
+	fs.StringVar(&logOpsFlag, "log-ops", "", "Destination of operational logs (default: --log-target)")
+	fs.StringVar(&logEventsFlag, "log-events", "", "Destination of change event logs (default: --log-target)")
+	fs.StringVar(&logLevelFlag, "log-level", "info", "Level of both log streams ('debug', 'info', 'warn' or 'error'); debug adds the worktree status, auth method selection and the exchanges with the remote")
+	fs.StringVar(&logOpsLevelFlag, "log-ops-level", "", "Level of operational logs ('debug', 'info', 'warn' or 'error', default: --log-level)")
+	fs.StringVar(&logEventsLevelFlag, "log-events-level", "", "Level of change event logs ('debug', 'info', 'warn' or 'error', default: --log-level)")
+	fs.StringVar(&localeFlag, "locale", "en", "Language of log messages, e.g. 'en' or 'fr'")
+	fs.StringVar(&logFormatFlag, "log-format", "plain", "Format of logs: 'plain', 'json' for log aggregators, or 'pretty' on stderr and stdout for colors, aligned columns and a summary table after each cycle")
+	fs.StringVar(&failFastFlag, "fail-fast", "", "Comma separated failures that exit the process instead of being retried ('auth', 'repo', 'push' or 'all')")
+	fs.IntVar(&failFastPushesFlag, "fail-fast-pushes", 3, "Consecutive push failures tolerated before exiting with --fail-fast push")
+	fs.StringVar(&resumeFlag, "resume", "rollback", "How to recover a cycle interrupted between staging and committing ('rollback' or 'complete')")
+	fs.DurationVar(&integrityIntervalFlag, "integrity-interval", time.Hour, "How often to check the repository for corruption (0 to disable)")
+	fs.DurationVar(&staleLockAgeFlag, "stale-lock-age", 0, "Remove an index.lock older than this when no process holds it (default: never)")
+	fs.Var(&maxDiffSizeFlag, "max-diff-size", "Largest diff logged for a change, larger ones are summarized as a diffstat (0 for no limit)")
+	fs.Var(&objectCacheSizeFlag, "object-cache-size", "Size of go-git's in-memory object cache, e.g. 32MB (0 to disable caching)")
+	fs.Var(&largeObjectThresholdFlag, "large-object-threshold", "Objects larger than this are streamed instead of read in memory, e.g. 1MB (0 for no limit)")
+	fs.DurationVar(&reopenIntervalFlag, "reopen-interval", 0, "Reopen the repository at this interval to release go-git's caches, e.g. 24h (0 to disable)")
+	fs.Var(&clutterSizeFlag, "clutter-size", "Warn about stack directories whose untracked files add up to this size, e.g. 50MB (0 to disable)")
+	fs.Var(&minFreeSpaceFlag, "min-free-space", "Hold commits and push while the repository's filesystem has less free space, e.g. 500MB (0 to disable)")
+	fs.IntVar(&scanDepthFlag, "scan-depth", 0, "Maximum directory depth scanned for compose files (0 for unlimited)")
+	fs.IntVar(&maxFilesFlag, "max-files", 0, "Maximum number of files visited per scan (0 for unlimited)")
+	fs.BoolVar(&watchFlag, "watch", false, "Check as soon as a compose file changes, using filesystem notifications")
+	fs.DurationVar(&watchPollFlag, "watch-poll-interval", time.Minute, "How often to poll the directories --watch can't watch")
+	fs.DurationVar(&probeIntervalFlag, "probe-interval", 0, "How often to probe the remote for reachability and latency (0 to disable)")
+	fs.StringVar(&tokenExpiryFlag, "token-expiry", "", "Known expiry date of the HTTPS token (2006-01-02), to warn before it lapses")
+	fs.DurationVar(&tokenExpiryWarnFlag, "token-expiry-warn", 7*24*time.Hour, "How long before --token-expiry to start warning")
+	fs.StringVar(&httpUserFlag, "http-user", "", "Username of the 'http' auth method")
+	fs.StringVar(&httpsTokenFlag, "https-token", "", "Access token of the 'token' auth method, for HTTPS remotes (default: GIT_TOKEN env)")
+	fs.StringVar(&httpPasswordFlag, "http-password", "", "Password of the 'http' auth method (default: GIT_HTTP_PASSWORD env)")
+	fs.StringVar(&proxyUserFlag, "proxy-user", "", "Username of a basic auth reverse proxy in front of the HTTP(S) remote")
+	fs.StringVar(&proxyPasswordFlag, "proxy-password", "", "Password of the reverse proxy (default: PROXY_PASSWORD env)")
+	fs.StringVar(&proxyAuthHeaderFlag, "proxy-auth-header", "Authorization", "Header carrying the reverse proxy credentials")
+	fs.BoolVar(&unshallowFlag, "unshallow", false, "Fetch the full history of a shallow clone on start")
+	fs.StringVar(&debugBundleDirFlag, "debug-bundle-dir", "", "Directory where a debug bundle (status, redacted options, recent logs, versions) is written on crashes and cycle errors (default: disabled)")
+	fs.StringVar(&pprofAddrFlag, "pprof-addr", "", "Loopback address serving pprof endpoints, e.g. localhost:6060 (default: disabled)")
+	fs.StringVar(&statusAddrFlag, "status-addr", "", "Address serving the status API, the state of the repositories and the recent logs, e.g. localhost:8080 (default: disabled)")
+	fs.StringVar(&metricsAddrFlag, "metrics-addr", "", "Address serving Prometheus metrics under /metrics, e.g. :9090 (default: disabled)")
+	fs.StringVar(&healthAddrFlag, "health-addr", "", "Address serving /healthz and /readyz for container healthchecks, e.g. :8081 (default: disabled)")
+	fs.StringVar(&apiTokenFlag, "api-token", "", "Admin bearer token, accepted by every HTTP endpoint but the health ones, and sent by the status command (default: API_TOKEN env, none)")
+	fs.StringVar(&apiViewerTokenFlag, "api-viewer-token", "", "Read-only bearer token, accepted by the status API and metrics endpoint but refused by admin endpoints, to share the status safely (default: API_VIEWER_TOKEN env, none)")
+	fs.StringVar(&apiAllowFlag, "api-allow", "", "Comma separated networks and addresses allowed to reach the HTTP endpoints, e.g. '192.168.1.0/24,10.0.0.5' (default: any)")
+	fs.StringVar(&apiTLSCertFlag, "api-tls-cert", "", "Certificate serving the HTTP endpoints over HTTPS (default: plain HTTP)")
+	fs.StringVar(&apiTLSKeyFlag, "api-tls-key", "", "Private key of --api-tls-cert")
+	fs.StringVar(&apiClientCAFlag, "api-client-ca", "", "CA certificate that must sign the client certificates of the HTTP endpoints (mTLS), with --api-tls-cert")
+	fs.IntVar(&logBufferFlag, "log-buffer", 500, "Number of recent log lines kept in memory for the status API and debug bundles")
+	fs.StringVar(&remoteCompatFlag, "remote-compat", "auto", "Remote provider quirks to account for ('auto', 'azure', 'bitbucket' or 'generic')")
+	fs.StringVar(&skipDirsFlag, "skip-dirs", "", "Comma separated directory names skipped while scanning, e.g. 'node_modules,.cache'")
+	fs.StringVar(&archiveDirFlag, "archive-dir", "archive", "Directory of archived stacks, which are not watched")
+}
+
+// initOptions sets up logging and validates the parsed options
+func initOptions() {
+	if timezoneFlag != "" {
+		location, err := time.LoadLocation(timezoneFlag)
+		if err != nil {
+			fatal("Invalid --timezone, expected a name like Europe/Paris", "timezone", timezoneFlag, "error", err)
+		}
+		// Every local time follows it: windows, log records, commit dates
+		time.Local = location
+	}
+
+	if err := setupLogging(); err != nil {
+		fatal("Failed to set up logging", "error", err)
+	}
+
+	if err := parseFailFast(failFastFlag); err != nil {
+		fatal("Invalid --fail-fast value", "error", err)
+	}
+
+	if intervalFlag == 0 {
+		intervalFlag = Delay
+		if value := os.Getenv("CHECK_INTERVAL"); value != "" {
+			interval, err := time.ParseDuration(value)
+			if err != nil {
+				fatal("Invalid CHECK_INTERVAL, expected a duration like 2m or 6h", "interval", value)
+			}
+			intervalFlag = interval
+		}
+	}
+	if intervalFlag <= 0 {
+		fatal("Invalid --interval, expected a positive duration", "interval", intervalFlag)
+	}
+
+	switch {
+	case sourceFlag != "" && remoteURLFlag == "":
+		fatal("--source needs --remote-url, the remote to commit its stacks to")
+	case sourceFlag == "" && remoteURLFlag != "":
+		fatal("--remote-url is only used with --source")
+	case sourceFlag != "" && len(repoFlag) > 1:
+		fatal("--source takes a single --repo, the path of the clone")
+	}
+
+	if err := parseAPIAllow(); err != nil {
+		fatal("Invalid --api-allow value", "error", err)
+	}
+	if (apiTLSCertFlag == "") != (apiTLSKeyFlag == "") {
+		fatal("--api-tls-cert and --api-tls-key must be given together")
+	}
+	if apiClientCAFlag != "" && apiTLSCertFlag == "" {
+		fatal("--api-client-ca requires --api-tls-cert")
+	}
+
+	if logBufferFlag <= 0 {
+		fatal("Invalid --log-buffer, expected a positive number", "log_buffer", logBufferFlag)
+	}
+	recentLogs.resize(logBufferFlag)
+
+	if pushIntervalFlag < 0 {
+		fatal("Invalid --push-interval, expected a positive duration", "push_interval", pushIntervalFlag)
+	}
+
+	if pushRetriesFlag < 0 {
+		fatal("Invalid --push-retries, expected a positive number", "push_retries", pushRetriesFlag)
+	}
+	if pushBackoffFlag <= 0 {
+		fatal("Invalid --push-backoff, expected a positive duration", "push_backoff", pushBackoffFlag)
+	}
+
+	if stackBranchFlag != "" && branchFlag != "" {
+		fatal("--stack-branch-prefix and --branch can't be used together")
+	}
+
+	if checkoutBranchFlag && branchFlag == "" {
+		fatal("--checkout-branch needs --branch, the branch to check out")
+	}
+
+	if pullFlag != "" && pullFlag != "ff" && pullFlag != "rebase" {
+		fatal("Invalid --pull strategy, expected 'ff' or 'rebase'", "pull", pullFlag)
+	}
+
+	if err := parsePushWindows(); err != nil {
+		fatal("Invalid --push-window value", "error", err)
+	}
+
+	if resumeFlag != "rollback" && resumeFlag != "complete" {
+		fatal("Invalid --resume mode, expected 'rollback' or 'complete'", "resume", resumeFlag)
+	}
+
+	if lintFlag != "" && lintFlag != "warn" && lintFlag != "block" {
+		fatal("Invalid --lint mode, expected 'warn' or 'block'", "lint", lintFlag)
+	}
+	if lintCmdFlag != "" && strings.TrimSpace(lintCmdFlag) == "" {
+		fatal("Invalid --lint-cmd, expected a command")
+	}
+	if notifyCmdFlag != "" && strings.TrimSpace(notifyCmdFlag) == "" {
+		fatal("Invalid --notify-cmd, expected a command")
+	}
+
+	if conflictsFlag != "" && conflictsFlag != "warn" && conflictsFlag != "block" {
+		fatal("Invalid --conflicts mode, expected 'warn' or 'block'", "conflicts", conflictsFlag)
+	}
+
+	if err := parseNotifyEvents(); err != nil {
+		fatal("Invalid --notify-events value", "error", err)
+	}
+
+	if err := parseWebhookEvents(webhookEventsFlag); err != nil {
+		fatal("Invalid --webhook-events value", "error", err)
+	}
+	if err := parseWebhookEvents(chatEventsFlag); err != nil {
+		fatal("Invalid --chat-events value", "error", err)
+	}
+
+	if ntfyURLFlag != "" && ntfyTopicFlag == "" {
+		fatal("--ntfy-url requires --ntfy-topic")
+	}
+	if gotifyURLFlag != "" && secretValue(gotifyTokenFlag, "GOTIFY_TOKEN") == "" {
+		fatal("--gotify-url requires --gotify-token or GOTIFY_TOKEN")
+	}
+	if escalateAfterFlag < 0 {
+		fatal("Invalid --escalate-after, expected a positive number or 0", "escalate_after", escalateAfterFlag)
+	}
+
+	if err := checkDigestOptions(); err != nil {
+		fatal("Invalid digest options", "error", err)
+	}
+
+	if err := checkAuthor(); err != nil {
+		fatal("Invalid commit author", "error", err)
+	}
+
+	if err := setupSigning(); err != nil {
+		fatal("Failed to set up commit signing", "error", err)
+	}
+
+	if err := parseCommitTemplate(); err != nil {
+		fatal("Invalid --commit-template", "error", err)
+	}
+
+	if err := parsePatterns(); err != nil {
+		fatal("Invalid --pattern glob", "error", err)
+	}
+
+	if err := parseClasses(); err != nil {
+		fatal("Invalid --skip-classes value", "error", err)
+	}
+
+	switch modeChangesFlag {
+	case "commit", "ignore", "warn":
+	default:
+		fatal("Invalid --mode-changes policy, expected 'commit', 'ignore' or 'warn'", "mode_changes", modeChangesFlag)
+	}
+
+	if err := parseCommitStyle(); err != nil {
+		fatal("Invalid --commit-style value", "error", err)
+	}
+
+	if err := parseMetadata(); err != nil {
+		fatal("Invalid --metadata value", "error", err)
+	}
+
+	if err := openStateDatabase(); err != nil {
+		fatal("Failed to open the --state-store database", "error", err)
+	}
+
+	if err := parseStackIntervals(); err != nil {
+		fatal("Invalid --stack-min-interval value", "error", err)
+	}
+
+	if err := parseApprovers(); err != nil {
+		fatal("Invalid deletion approval options", "error", err)
+	}
+	if approveDeletionsFlag > 0 && statusAddrFlag == "" {
+		fatal("--approve-deletions needs --status-addr, which serves POST /approve")
+	}
+
+	switch symlinksFlag {
+	case "link", "follow", "skip":
+	default:
+		fatal("Invalid --symlinks policy, expected 'link', 'follow' or 'skip'", "symlinks", symlinksFlag)
+	}
+
+	if pprofAddrFlag != "" {
+		if err := loopbackAddr(pprofAddrFlag); err != nil {
+			fatal("Invalid --pprof-addr, expected a loopback address like localhost:6060", "error", err)
+		}
+	}
+
+	if onceFlag {
+		// A single cycle has no schedule to wait for
+		switch {
+		case pushesDeferred():
+			fatal("--once can't be used with --push-interval or --push-window, commits are pushed right away")
+		case watchFlag:
+			fatal("--once can't be used with --watch")
+		case exitAfterIdleFlag > 0:
+			fatal("--once can't be used with --exit-after-idle")
+		}
+	}
+
+	if watchFlag && watchPollFlag <= 0 {
+		fatal("Invalid --watch-poll-interval, expected a positive duration", "watch_poll_interval", watchPollFlag)
+	}
+
+	switch remoteCompatFlag {
+	case "auto", providerGeneric, providerAzure, providerBitbucket:
+	default:
+		fatal("Invalid --remote-compat mode, expected 'auto', 'azure', 'bitbucket' or 'generic'", "remote_compat", remoteCompatFlag)
+	}
+}
+
+// setupAuth validates the auth chain and resolves the credentials it uses
+func setupAuth() {
+	chain := authMethods()
+	if len(chain) == 0 {
+		opsLog.Info("Auth method: picked from the remote URL (ssh, token, http or none)")
+		sshkeyPath = sshKeyPath()
+		if proxyUserFlag != "" && (httpUserFlag != "" || secretValue(httpsTokenFlag, "GIT_TOKEN") != "") && strings.EqualFold(proxyAuthHeaderFlag, "Authorization") {
+			fatal("Proxy and git credentials can't share the Authorization header, set --proxy-auth-header")
+		}
+	}
+
+	for _, method := range chain {
+		switch method {
+		case "ssh":
+			opsLog.Info("Auth method: SSH")
+			opsLog.Info("Will now check for a correct SSH Key Path...")
+
+			if keypath := configuredSSHKeyPath(); keypath != "" {
+				sshkeyPath = keypath
+				opsLog.Info("Using SSH key", "path", sshkeyPath)
+			} else {
+				sshkeyPath = "/root/.ssh/id_ed25519"
+				opsLog.Info("No SSHKEY_PATH env set, using default SSH key path", "path", sshkeyPath)
+			}
+			if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" {
+				opsLog.Info("Using SSH agent keys first, then the SSH key", "socket", socket)
+			} else if _, err := readSSHKey(sshkeyPath); err != nil {
+				opsLog.Error("SSH key can't be used", "path", sshkeyPath, "error", err)
+			}
+		case "http", "token":
+			if method == "token" {
+				opsLog.Info("Auth method: HTTPS token", "user", firstOf(httpUserFlag, "picked by --remote-compat"))
+			} else {
+				opsLog.Info("Auth method: HTTP basic auth", "user", httpUserFlag)
+			}
+			if proxyUserFlag != "" && strings.EqualFold(proxyAuthHeaderFlag, "Authorization") {
+				fatal("Proxy and git credentials can't share the Authorization header, set --proxy-auth-header")
+			}
+		case "none":
+			opsLog.Info("No Auth method!")
+		default:
+			if _, ok := authProviders[method]; !ok {
+				fatal("Unknown auth method", "method", method)
+			}
+		}
+	}
+
+	if proxyUserFlag != "" {
+		opsLog.Info("Using reverse proxy credentials", "user", proxyUserFlag, "header", proxyAuthHeaderFlag)
+	}
+}
+
 func checkAndCommit(repo *git.Repository, repoPath string) {
-	log.Println("Checking for compose file changes...")
+	scope := currentScope()
+	scope.run = newRunID()
+	defer enterScope(scope)()
+	defer printCycleSummary()
+
+	opsLog.Info("Checking for compose file changes...")
+
+	if !verifyRepository(repo) {
+		return
+	}
+
+	if !hasEnoughDiskSpace(repoPath) {
+		return
+	}
+
+	if !indexUnlocked(repo) {
+		return
+	}
+
+	if !onTargetBranch(repo) {
+		return
+	}
 
 	// Get the worktree
 	worktree, err := repo.Worktree()
 	if err != nil {
-		fmt.Printf("Failed to get worktree: %v", err)
+		opsLog.Error("Failed to get worktree", "error", err)
+		checkRepoFailure(err)
 		return
 	}
 
 	// Get the current status
-	status, err := worktree.Status()
+	status, err := worktreeStatus(repo, worktree)
 	if err != nil {
-		fmt.Printf("Failed to get status: %v", err)
+		opsLog.Error("Failed to get status", "error", err)
+		checkRepoFailure(err)
 		return
 	}
+	logStatus(status)
+	pruneApprovals(repo, repoPath)
+	current.stackFiles = newStackFiles(repoPath, stackDirs(repo, status))
+
+	if clutterSizeFlag > 0 {
+		reportClutter(repo, repoPath, status)
+	}
 
 	// Find all compose file changes
-	changes := findComposeChanges(status)
+	changes := detectChanges(repo, repoPath, status)
 
+	commitCount := 0
+	defer func() { current.cycleCommits = commitCount }()
+	// Changes are pending until committed, held and failed ones included
+	pending := len(changes)
+	defer func() { current.metrics.setPending(pending) }()
 	if len(changes) == 0 {
-		fmt.Println("No compose file changes detected.")
-		return
-	}
+		opsLog.Info("No compose file changes detected.")
+	} else {
+		lastChangeAt.Store(time.Now().UnixNano())
+		opsLog.Info("Found stack changes", "count", len(changes))
+		for _, change := range changes {
+			eventLog.Info("Change detected", "change", change.ChangeType, "class", change.Class, "stack", change.StackName, "path", change.FilePath)
+			logChangeDiff(repo, repoPath, change)
+		}
 
-	fmt.Printf("Found %d stack change(s):\n", len(changes))
-	for _, change := range changes {
-		fmt.Printf("  - %s %s (%s)\n", change.ChangeType, change.StackName, change.FilePath)
-	}
+		if dryRunFlag {
+			printDryRun(repo, repoPath, changes)
+			opsLog.Info("Done.")
+			return
+		}
 
-	fmt.Println()
+		// Create a commit for each stack change
+		drifting := map[string]bool{}
+		defer func() { current.digest.setDrifting(drifting) }()
+		for _, change := range changes {
+			err := commitStackChange(worktree, repo, change)
+			if errors.Is(err, errCommitHeld) {
+				eventLog.Warn("Holding change", "stack", change.StackName, "reason", err)
+				if err := recordHeld(repo, change, err); err != nil {
+					opsLog.Warn("Failed to record the held change", "stack", change.StackName, "error", err)
+				}
+				current.digest.countHeld(change)
+				drifting[change.StackName] = true
+				continue
+			}
+			if err != nil {
+				opsLog.Error("Failed to commit", "stack", change.StackName, "error", err)
+				current.digest.countFailed(change)
+				drifting[change.StackName] = true
+				continue
+			}
+			commitCount++
+			pending--
+			current.metrics.observeCommit(change)
+			forgetApprovals(repo, func(path string) bool { return path == change.FilePath })
+			current.digest.stack(change.StackName).Commits++
+		}
+	}
 
-	// Create a commit for each stack change
-	commitCount := 0
-	for _, change := range changes {
-		err := commitStackChange(worktree, repo, change)
+	// Create a heartbeat commit if one is due
+	if heartbeatFlag > 0 && stackBranchFlag == "" && !dryRunFlag && heartbeatDue(repoPath) {
+		err := commitHeartbeat(worktree, repoPath)
 		if err != nil {
-			fmt.Printf("Failed to commit %s: %v", change.StackName, err)
-			continue
+			opsLog.Error("Failed to commit heartbeat", "error", err)
+		} else {
+			commitCount++
 		}
-		commitCount++
 	}
 
-	if pushFlag && commitCount > 0 {
-		fmt.Println()
+	if commitCount > 0 && pushesDeferred() {
+		deferPush()
+	} else if pushFlag && !pushesDeferred() && (commitCount > 0 || current.pushFailures > 0) {
+		// The commits of a failed push are pushed again even without new ones
 		err := pushToRemote(repo)
 		if err != nil {
-			fmt.Printf("Failed to push to remote: %v\n", err)
+			opsLog.Error("Failed to push to remote", "error", err)
 		}
+		recordPushResult(err)
 	} else if commitCount == 0 {
-		fmt.Println()
-		log.Println("No commits were created, skipping push.")
+		opsLog.Info("No commits were created, skipping push.")
 	}
 
-	log.Print("Done.\n\n")
+	opsLog.Info("Done.")
+}
+
+// detectChanges returns the stack changes of a worktree status that the
+// enabled filters keep, classified
+func detectChanges(repo *git.Repository, repoPath string, status git.Status) []Change {
+	if symlinksFlag == "follow" {
+		followSymlinks(repo, repoPath, status)
+	}
+	changes := findComposeChanges(status)
+	if symlinksFlag == "skip" {
+		changes = filterSymlinks(repoPath, changes)
+	}
+	if stackDirFlag {
+		changes = withStackFiles(repo, status, changes)
+	}
+	changes = withIncludes(repo, repoPath, status, changes)
+	// Stack branches keep a deletion and a creation, the two stacks having
+	// their own branch
+	if stackBranchFlag == "" {
+		changes = detectRenames(repo, repoPath, changes)
+	}
+	if normalizeFlag {
+		changes = filterFormattingOnly(repo, repoPath, changes)
+	}
+	changes = filterModeChanges(repo, repoPath, changes)
+	if stackBranchFlag != "" {
+		changes = filterOnStackBranches(repo, repoPath, changes)
+	}
+	changes = groupStackChanges(changes)
+	changes = withStackMetadata(repo, changes)
+	if len(stackIntervals) > 0 {
+		changes = filterThrottled(repo, changes)
+	}
+	return classifyChanges(repo, repoPath, changes)
 }
 
 // findComposeChanges scans the git status for compose.yml/compose.yaml changes
@@ -176,9 +938,8 @@ func findComposeChanges(status git.Status) []Change {
 	var changes []Change
 
 	for filePath, fileStatus := range status {
-		// Check if the file is a compose file
-		fileName := filepath.Base(filePath)
-		if fileName != "compose.yml" && fileName != "compose.yaml" {
+		// Check if the file is watched, outside of the archive
+		if !isWatchedFile(filePath) || isArchived(filePath) {
 			continue
 		}
 
@@ -209,6 +970,17 @@ func findComposeChanges(status git.Status) []Change {
 	return changes
 }
 
+// isComposeFile reports whether a repository path is a compose file: named
+// compose.yml or compose.yaml, or a YAML file matching a --pattern
+func isComposeFile(filePath string) bool {
+	fileName := filepath.Base(filePath)
+	if fileName == "compose.yml" || fileName == "compose.yaml" {
+		return true
+	}
+	ext := filepath.Ext(fileName)
+	return (ext == ".yml" || ext == ".yaml") && matchesPattern(filePath)
+}
+
 // getStackName extracts the stack name from the file path
 // For example: "docker/komodo/compose.yml" -> "komodo"
 func getStackName(filePath string) string {
@@ -224,8 +996,157 @@ func getStackName(filePath string) string {
 	return stackName
 }
 
+// readWorktreeFile reads a file from the worktree given its repository path
+func readWorktreeFile(repoPath string, filePath string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(repoPath, filepath.FromSlash(filePath)))
+}
+
+// commitBody returns what a stack change's commit message says beyond its
+// subject: affected profiles and the problems found by the enabled checks
+func commitBody(repo *git.Repository, repoPath string, change Change) string {
+	if !isComposeFile(change.FilePath) {
+		return ""
+	}
+
+	var lines []string
+	for _, line := range []string{
+		summaryBody(repo, repoPath, change),
+		stackFilesBody(change),
+		includedBody(change),
+		profilesBody(repo, repoPath, change),
+		externalsBody(repoPath, change),
+		envBody(repoPath, change),
+	} {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// withTrailers appends the non-empty trailers to a commit message, as its
+// last paragraph
+func withTrailers(message string, trailers ...string) string {
+	var lines []string
+	for _, trailer := range trailers {
+		if trailer != "" {
+			lines = append(lines, trailer)
+		}
+	}
+	if len(lines) == 0 {
+		return message
+	}
+	return message + "\n\n" + strings.Join(lines, "\n")
+}
+
+// runTrailer returns the Run-Id trailer of the cycle when --run-trailer is set
+func runTrailer() string {
+	run := currentScope().run
+	if !runTrailerFlag || run == "" {
+		return ""
+	}
+	return "Run-Id: " + run
+}
+
+// classTrailer returns the Change-Class trailer of a change when
+// --class-trailer is set
+func classTrailer(change Change) string {
+	if !classTrailerFlag || change.Class == "" {
+		return ""
+	}
+	return "Change-Class: " + change.Class
+}
+
+// commitSubject returns the subject of a stack commit, such as "created
+// nginx", prefixed with its gitmoji when --gitmoji is set, in the style of
+// --commit-style, or rendered from --commit-template
+func commitSubject(change Change) string {
+	if commitTemplate != nil {
+		subject, err := renderCommitTemplate(change)
+		if err == nil {
+			return subject
+		}
+		opsLog.Warn("Failed to render --commit-template, using the default message", "error", err)
+	}
+
+	if commitStyleFlag == "conventional" {
+		return conventionalSubject(change)
+	}
+
+	name := change.StackName
+	if change.ChangeType == Renamed {
+		name = renameLabel(change)
+	}
+	subject := fmt.Sprintf("%s %s", change.ChangeType, name)
+	if gitmojiFlag {
+		subject = gitmojis[change.ChangeType] + " " + subject
+	}
+	return subject
+}
+
+// commitMessage returns the message of a stack change's commit: its subject,
+// body and trailers
+func commitMessage(repo *git.Repository, root string, change Change) string {
+	message := commitSubject(change)
+	if body := commitBody(repo, root, change); body != "" {
+		message += "\n\n" + body
+	}
+	return withTrailers(message, ownTrailer(change), aliasTrailer(change), runTrailer(), classTrailer(change), approvalTrailer(change))
+}
+
 // commitStackChange creates a commit for a single stack change
 func commitStackChange(worktree *git.Worktree, repo *git.Repository, change Change) error {
+	// Deletions wait for their approvers with --approve-deletions
+	approvers, err := deletionApprovers(repo, change)
+	if err != nil {
+		return err
+	}
+	change.Approvers = approvers
+
+	commitMsg := commitMessage(repo, worktree.Filesystem.Root(), change)
+
+	// Record the change so a crash before the commit can be recovered
+	if err := writeJournal(repo, change, commitMsg); err != nil {
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+	defer clearJournal(repo)
+
+	if change.ChangeType != "deleted" {
+		if formatFlag && isComposeFile(change.FilePath) {
+			err := formatComposeFile(worktree.Filesystem.Root(), change.FilePath)
+			if err != nil {
+				return fmt.Errorf("failed to format file: %w", err)
+			}
+		}
+
+		if lintFlag != "" && isComposeFile(change.FilePath) {
+			err := checkLint(worktree.Filesystem.Root(), change)
+			if err != nil {
+				return err
+			}
+		}
+
+		if conflictsFlag != "" && isComposeFile(change.FilePath) {
+			err := checkConflicts(repo, worktree.Filesystem.Root(), change)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Stack branches are committed to without staging anything
+	if stackBranchFlag != "" {
+		hash, err := commitToStackBranch(repo, worktree.Filesystem.Root(), change, commitMsg)
+		if err != nil {
+			return err
+		}
+		if err := recordStackCommit(repo, change); err != nil {
+			opsLog.Warn("Failed to record the stack metadata", "stack", change.StackName, "error", err)
+		}
+		postCommitWebhook(change, hash.String(), commitMsg)
+		return nil
+	}
+
 	if change.ChangeType == "deleted" {
 		_, err := worktree.Remove(change.FilePath)
 		if err != nil {
@@ -236,53 +1157,115 @@ func commitStackChange(worktree *git.Worktree, repo *git.Repository, change Chan
 		if err != nil {
 			return fmt.Errorf("failed to add file: %w", err)
 		}
+		if change.ChangeType == Renamed {
+			if err := removeOldPath(repo, worktree, change.OldPath); err != nil {
+				return fmt.Errorf("failed to remove the stack's old file: %w", err)
+			}
+		}
+		if followsSymlink(change.FilePath) && isSymlink(worktree.Filesystem.Root(), change.FilePath) {
+			if err := stageSymlinkTarget(repo, worktree.Filesystem.Root(), change.FilePath); err != nil {
+				return fmt.Errorf("failed to stage the symlink's target: %w", err)
+			}
+		}
 	}
 
-	// Create the commit
-	commitMsg := fmt.Sprintf("%s %s", change.ChangeType, change.StackName)
+	if err := stageStackFiles(worktree, change); err != nil {
+		return fmt.Errorf("failed to stage stack files: %w", err)
+	}
+
+	// Never commit an unresolved merge
+	if err := checkConflictMarkers(repo, worktree, change); err != nil {
+		return err
+	}
 
-	commit, err := worktree.Commit(commitMsg, &git.CommitOptions{})
+	// A change formatted down to nothing commits nothing, not even the
+	// metadata
+	staged, err := stagedChangeDiffers(repo, change)
 	if err != nil {
+		return fmt.Errorf("failed to compare the staged files with HEAD: %w", err)
+	}
+	if !staged {
+		return fmt.Errorf("nothing left to commit after formatting: %w", git.ErrEmptyCommit)
+	}
+
+	// The metadata is recorded once the commit exists, unless it goes in the
+	// commit with --metadata committed: then it's rolled back if it fails
+	var saved map[string][]byte
+	if metadataFlag == "committed" {
+		saved, err = snapshotMetadata(worktree)
+		if err != nil {
+			return fmt.Errorf("failed to read the metadata: %w", err)
+		}
+		if err := recordStackCommit(repo, change); err != nil {
+			opsLog.Warn("Failed to record the stack metadata", "stack", change.StackName, "error", err)
+		}
+		if err := stageMetadata(worktree); err != nil {
+			restoreMetadata(worktree, saved)
+			return fmt.Errorf("failed to stage the metadata: %w", err)
+		}
+	}
+
+	// Create the commit
+	commit, err := worktree.Commit(commitMsg, commitOptions())
+	if err != nil {
+		if metadataFlag == "committed" {
+			restoreMetadata(worktree, saved)
+		}
+		if errors.Is(err, git.ErrEmptyCommit) {
+			return fmt.Errorf("nothing left to commit after formatting: %w", err)
+		}
 		return fmt.Errorf("failed to commit: %w", err)
 	}
+	if metadataFlag != "committed" {
+		if err := recordStackCommit(repo, change); err != nil {
+			opsLog.Warn("Failed to record the stack metadata", "stack", change.StackName, "error", err)
+		}
+	}
 
 	// Log the commit hash
-	log.Printf("✓ Created commit %s: %s\n", commit.String()[:7], commitMsg)
+	eventLog.Info("✓ Created commit", "stack", change.StackName, "change", change.ChangeType, "hash", commit.String()[:7], "message", commitMsg)
+	postCommitWebhook(change, commit.String(), commitMsg)
 
 	return nil
 }
 
-// pushToRemote pushes the commits to the remote repository
-func pushToRemote(repo *git.Repository) error {
-	log.Println("Pushing to remote...")
-
-	var err error
-
-	if authMethodFlag == "ssh" {
-		auth, e := ssh.NewPublicKeysFromFile("git", sshkeyPath, "")
-		if e != nil {
-			return fmt.Errorf("failed to create SSH auth: %w", err)
+// pushOnce pushes the commits to the remote repository, without retrying
+func pushOnce(repo *git.Repository) error {
+	if pullFlag != "" {
+		if err := pullBeforePush(repo); err != nil {
+			return fmt.Errorf("push skipped: %w", err)
 		}
+	}
 
-		err = repo.Push(&git.PushOptions{
-			Auth: auth,
-		})
-	} else {
-		err = repo.Push(&git.PushOptions{})
+	opsLog.Info("Pushing to remote...")
+
+	refSpecs, err := pushRefSpecs(repo)
+	if err != nil {
+		return fmt.Errorf("push failed: %w", err)
 	}
 
+	opsLog.Debug("Push refspecs", "remote", redact(remoteURL(repo), nil), "refspecs", refSpecs)
+	err = withAuth(repo, func(auth transport.AuthMethod) error {
+		return repo.Push(&git.PushOptions{
+			Auth:     auth,
+			RefSpecs: refSpecs,
+		})
+	})
 	if err != nil {
 		if err == git.NoErrAlreadyUpToDate {
-			log.Println("✓ Already up to date")
+			opsLog.Info("✓ Already up to date")
 			return nil
 		}
 		if err == git.ErrRemoteNotFound {
-			log.Println("x No remote available, please add one!")
+			opsLog.Error("x No remote available, please add one!")
 			return err
 		}
+		if hint := compatHint(remoteProvider(repo), err); hint != "" {
+			opsLog.Warn("Push failed on a remote with known quirks", "provider", remoteProvider(repo), "hint", hint)
+		}
 		return fmt.Errorf("push failed: %w", err)
 	}
 
-	log.Println("✓ Successfully pushed to remote")
+	eventLog.Info("✓ Successfully pushed to remote")
 	return nil
 }
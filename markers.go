@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing/format/index"
+)
+
+// conflictMarkers returns the line numbers of the merge conflicts left in a
+// file: a "<<<<<<<" line followed by "=======" and ">>>>>>>" lines
+func conflictMarkers(data []byte) []int {
+	var conflicts []int
+	start, separated := 0, false
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		switch {
+		case strings.HasPrefix(text, "<<<<<<<"):
+			start, separated = line, false
+		case start > 0 && strings.HasPrefix(text, "======="):
+			separated = true
+		case start > 0 && separated && strings.HasPrefix(text, ">>>>>>>"):
+			conflicts = append(conflicts, start)
+			start, separated = 0, false
+		}
+	}
+	return conflicts
+}
+
+// checkConflictMarkers reads the staged content of a change's files and
+// unstages them when one still holds merge conflict markers, returning
+// errCommitHeld
+func checkConflictMarkers(repo *git.Repository, worktree *git.Worktree, change Change) error {
+	idx, err := repo.Storer.Index()
+	if err != nil {
+		return err
+	}
+
+	var found []string
+	for _, filePath := range change.files() {
+		entry, err := idx.Entry(filePath)
+		if errors.Is(err, index.ErrEntryNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		blob, err := repo.BlobObject(entry.Hash)
+		if err != nil {
+			return err
+		}
+		reader, err := blob.Reader()
+		if err != nil {
+			return err
+		}
+		data, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			return err
+		}
+
+		if lines := conflictMarkers(data); len(lines) > 0 {
+			eventLog.Error("Conflict markers found, refusing to commit", "stack", change.StackName, "path", filePath, "lines", lines)
+			found = append(found, filePath)
+		}
+	}
+	if len(found) == 0 {
+		return nil
+	}
+
+	if err := worktree.Restore(&git.RestoreOptions{Staged: true, Files: change.files()}); err != nil {
+		return fmt.Errorf("failed to unstage files with conflict markers: %w", err)
+	}
+	return fmt.Errorf("%w: conflict markers in %s", errCommitHeld, strings.Join(found, ", "))
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"time"
+
+	"github.com/go-git/go-git/v6"
+)
+
+// stacksKey and heldKey are the metadata store keys of the stacks and their
+// held changes
+const (
+	stacksKey = "stacks"
+	heldKey   = "held"
+)
+
+// stackMetadata is what the repository metadata records about a stack
+type stackMetadata struct {
+	// Sequence numbers the stack's commits, from 1
+	Sequence   int        `json:"sequence"`
+	LastChange ChangeType `json:"last_change"`
+	LastCommit time.Time  `json:"last_commit"`
+}
+
+// heldChange records a change of a stack held instead of committed, until
+// the stack is committed again
+type heldChange struct {
+	Path   string     `json:"path"`
+	Change ChangeType `json:"change"`
+	Reason string     `json:"reason"`
+	Since  time.Time  `json:"since"`
+}
+
+// loadMetadata reads a metadata document, empty when there is none
+func loadMetadata[V any](store stateStore, key string) (map[string]V, error) {
+	values := map[string]V{}
+	if _, err := store.load(key, &values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// withStackMetadata sets the past names of the changes' stacks and the
+// sequence number their commits would get
+func withStackMetadata(repo *git.Repository, changes []Change) []Change {
+	changes = withAliases(repo, changes)
+
+	store, err := openMetadataStore(repo)
+	var stacks map[string]stackMetadata
+	if err == nil {
+		stacks, err = loadMetadata[stackMetadata](store, stacksKey)
+	}
+	if err != nil {
+		opsLog.Warn("Failed to read the stack metadata", "error", err)
+		return changes
+	}
+	for i, change := range changes {
+		stack, ok := stacks[change.StackName]
+		if !ok && change.OldPath != "" {
+			stack = stacks[getStackName(change.OldPath)]
+		}
+		changes[i].Sequence = stack.Sequence + 1
+	}
+	return changes
+}
+
+// recordStackCommit updates the metadata of a stack about to be committed:
+// its sequence and last change, the alias of a renamed stack, and its held
+// change, which no longer is
+func recordStackCommit(repo *git.Repository, change Change) error {
+	store, err := openMetadataStore(repo)
+	if err != nil {
+		return err
+	}
+	stacks, err := loadMetadata[stackMetadata](store, stacksKey)
+	if err != nil {
+		return err
+	}
+	held, err := loadMetadata[heldChange](store, heldKey)
+	if err != nil {
+		return err
+	}
+
+	if change.ChangeType == Renamed {
+		oldName := getStackName(change.OldPath)
+		if err := recordAlias(repo, oldName, change.StackName); err != nil {
+			return err
+		}
+		if oldName != change.StackName {
+			delete(stacks, oldName)
+			delete(held, oldName)
+		}
+	}
+	stacks[change.StackName] = stackMetadata{Sequence: max(change.Sequence, 1), LastChange: change.ChangeType, LastCommit: time.Now().UTC()}
+	delete(held, change.StackName)
+
+	if err := store.save(stacksKey, stacks); err != nil {
+		return err
+	}
+	return store.save(heldKey, held)
+}
+
+// recordHeld records a held change of a stack, keeping when it was first
+// held
+func recordHeld(repo *git.Repository, change Change, reason error) error {
+	store, err := openMetadataStore(repo)
+	if err != nil {
+		return err
+	}
+	held, err := loadMetadata[heldChange](store, heldKey)
+	if err != nil {
+		return err
+	}
+	since := time.Now().UTC()
+	if previous, ok := held[change.StackName]; ok {
+		since = previous.Since
+	}
+	held[change.StackName] = heldChange{Path: change.FilePath, Change: change.ChangeType, Reason: reason.Error(), Since: since}
+	return store.save(heldKey, held)
+}
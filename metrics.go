@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cycleDurationBuckets are the upper bounds, in seconds, of the cycle
+// duration histogram
+var cycleDurationBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+// commitKey labels the commits counter
+type commitKey struct {
+	stack  string
+	change ChangeType
+}
+
+// repoMetrics are the Prometheus metrics of a watched repository. A nil
+// *repoMetrics, the one of the state outside of a repository, records
+// nothing.
+type repoMetrics struct {
+	mu sync.Mutex
+
+	cycles    map[string]float64
+	buckets   []float64
+	count     float64
+	sum       float64
+	lastCycle time.Time
+	commits   map[commitKey]float64
+	pushes    map[string]float64
+	pending   int
+
+	// reachable is the outcome of the last remote probe, nil until the
+	// remote is probed
+	reachable *bool
+}
+
+func newRepoMetrics() *repoMetrics {
+	return &repoMetrics{
+		cycles:  map[string]float64{},
+		buckets: make([]float64, len(cycleDurationBuckets)),
+		commits: map[commitKey]float64{},
+		pushes:  map[string]float64{},
+	}
+}
+
+// result labels a cycle or push as "ok", or "error" when it failed
+func result(failed bool) string {
+	if failed {
+		return "error"
+	}
+	return "ok"
+}
+
+// observeCycle records a cycle that took duration, failed when it logged
+// errors
+func (m *repoMetrics) observeCycle(duration time.Duration, failed bool) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.cycles[result(failed)]++
+	seconds := duration.Seconds()
+	for i, bound := range cycleDurationBuckets {
+		if seconds <= bound {
+			m.buckets[i]++
+		}
+	}
+	m.count++
+	m.sum += seconds
+	m.lastCycle = time.Now()
+}
+
+func (m *repoMetrics) observeCommit(change Change) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.commits[commitKey{change.StackName, change.ChangeType}]++
+}
+
+func (m *repoMetrics) observePush(err error) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pushes[result(err != nil)]++
+}
+
+// setPending records how many detected changes are left uncommitted, held or
+// failed
+func (m *repoMetrics) setPending(pending int) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pending = pending
+}
+
+func (m *repoMetrics) setReachable(reachable bool) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reachable = &reachable
+}
+
+// labels renders Prometheus labels from name and value pairs
+func labels(pairs ...string) string {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(pairs[i+1])
+		fmt.Fprintf(&b, "%s=\"%s\"", pairs[i], value)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// metricsFamily is a metric with its samples, written together as the text
+// format requires
+type metricsFamily struct {
+	name, kind, help string
+	samples          []string
+}
+
+func (f *metricsFamily) add(suffix, labels string, value float64) {
+	f.samples = append(f.samples, f.name+suffix+labels+" "+formatFloat(value))
+}
+
+// writeMetrics renders the metrics of every watched repository in the
+// Prometheus text format
+func writeMetrics(b *strings.Builder) {
+	cycles := &metricsFamily{name: metricCycles, kind: "counter", help: "Cycles run, by result: error when the cycle logged errors"}
+	duration := &metricsFamily{name: metricCycleDuration, kind: "histogram", help: "Duration of cycles"}
+	lastCycle := &metricsFamily{name: metricLastCycle, kind: "gauge", help: "Unix time of the end of the last cycle"}
+	commits := &metricsFamily{name: metricCommits, kind: "counter", help: "Commits created, by stack and change type"}
+	pushes := &metricsFamily{name: metricPushes, kind: "counter", help: "Pushes, by result"}
+	pending := &metricsFamily{name: metricPending, kind: "gauge", help: "Changes detected by the last cycle and left uncommitted"}
+	reachable := &metricsFamily{name: metricRemoteReachable, kind: "gauge", help: "Whether the last probe reached the remote"}
+
+	for _, w := range statusRepos {
+		m := w.state.metrics
+		if m == nil {
+			continue
+		}
+		m.mu.Lock()
+		repo := labels("repo", w.path)
+		for _, r := range []string{"ok", "error"} {
+			cycles.add("", labels("repo", w.path, "result", r), m.cycles[r])
+			pushes.add("", labels("repo", w.path, "result", r), m.pushes[r])
+		}
+		for i, bound := range cycleDurationBuckets {
+			duration.add("_bucket", labels("repo", w.path, "le", formatFloat(bound)), m.buckets[i])
+		}
+		duration.add("_bucket", labels("repo", w.path, "le", "+Inf"), m.count)
+		duration.add("_sum", repo, m.sum)
+		duration.add("_count", repo, m.count)
+		if !m.lastCycle.IsZero() {
+			lastCycle.add("", repo, float64(m.lastCycle.UnixNano())/1e9)
+		}
+
+		keys := make([]commitKey, 0, len(m.commits))
+		for key := range m.commits {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if keys[i].stack != keys[j].stack {
+				return keys[i].stack < keys[j].stack
+			}
+			return keys[i].change < keys[j].change
+		})
+		for _, key := range keys {
+			commits.add("", labels("repo", w.path, "stack", key.stack, "change", string(key.change)), m.commits[key])
+		}
+
+		pending.add("", repo, float64(m.pending))
+		if m.reachable != nil {
+			value := 0.0
+			if *m.reachable {
+				value = 1
+			}
+			reachable.add("", repo, value)
+		}
+		m.mu.Unlock()
+	}
+
+	for _, f := range []*metricsFamily{cycles, duration, lastCycle, commits, pushes, pending, reachable} {
+		fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.kind)
+		for _, sample := range f.samples {
+			b.WriteString(sample + "\n")
+		}
+	}
+}
+
+func handleMetrics(rw http.ResponseWriter, _ *http.Request) {
+	var b strings.Builder
+	writeMetrics(&b)
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	rw.Write([]byte(b.String()))
+}
+
+// serveMetrics serves the Prometheus metrics under /metrics in the background
+func serveMetrics(addr string) error {
+	listener, err := apiListen(addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", handleMetrics)
+
+	go func() {
+		if err := http.Serve(listener, protectAPI(mux, roleViewer)); err != nil {
+			processLog.Error("Metrics endpoint stopped", "error", err)
+		}
+	}()
+
+	opsLog.Info("Serving Prometheus metrics", "url", apiURL(listener, "/metrics"))
+	return nil
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing/filemode"
+	"github.com/go-git/go-git/v6/plumbing/object"
+)
+
+var (
+	migrateToFlag      string
+	migrateHistoryFlag bool
+)
+
+func migrateFlags(fs *flag.FlagSet) {
+	fs.StringVar(&migrateToFlag, "to", "", "Path of the repository to move the stack to (required)")
+	fs.BoolVar(&migrateHistoryFlag, "with-history", false, "Replay the commits touching the stack in the target repository instead of a single commit")
+}
+
+// stackHistory returns the commits changing files of a stack directory, oldest
+// first, up to HEAD
+func stackHistory(repo *git.Repository, dir string) ([]*object.Commit, error) {
+	head, err := repo.Head()
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve HEAD: %w", err)
+	}
+
+	commits, err := repo.Log(&git.LogOptions{From: head.Hash()})
+	if err != nil {
+		return nil, err
+	}
+	defer commits.Close()
+
+	var history []*object.Commit
+	for {
+		commit, err := commits.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			if historyTruncated(repo, err) {
+				break
+			}
+			return nil, err
+		}
+
+		changes, err := commitChanges(commit)
+		if err != nil {
+			if historyTruncated(repo, err) {
+				break
+			}
+			return nil, err
+		}
+		for _, change := range changes {
+			if strings.HasPrefix(changeName(change), dir+"/") {
+				history = append(history, commit)
+				break
+			}
+		}
+	}
+
+	slices.Reverse(history)
+	return history, nil
+}
+
+// writeStackFiles makes a stack directory of a worktree match its state in a
+// source commit, returning the repository paths written and removed
+func writeStackFiles(root string, dir string, commit *object.Commit, previous []string) ([]string, []string, error) {
+	files, err := commit.Files()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var written []string
+	err = files.ForEach(func(f *object.File) error {
+		if !strings.HasPrefix(f.Name, dir+"/") {
+			return nil
+		}
+		contents, err := f.Contents()
+		if err != nil {
+			return err
+		}
+
+		perm := os.FileMode(0o644)
+		if f.Mode == filemode.Executable {
+			perm = 0o755
+		}
+		target := filepath.Join(root, filepath.FromSlash(f.Name))
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(target, []byte(contents), perm); err != nil {
+			return err
+		}
+		written = append(written, f.Name)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var removed []string
+	for _, name := range previous {
+		if !slices.Contains(written, name) {
+			removed = append(removed, name)
+		}
+	}
+	return written, removed, nil
+}
+
+// replayStack commits a stack's files in the target worktree, once per
+// source commit with its author and message
+func replayStack(target *git.Worktree, dir string, commits []*object.Commit) error {
+	root := target.Filesystem.Root()
+
+	var previous []string
+	for _, commit := range commits {
+		written, removed, err := writeStackFiles(root, dir, commit, previous)
+		if err != nil {
+			return err
+		}
+		for _, name := range removed {
+			if _, err := target.Remove(name); err != nil {
+				return fmt.Errorf("failed to remove %s: %w", name, err)
+			}
+		}
+		for _, name := range written {
+			if _, err := target.Add(name); err != nil {
+				return fmt.Errorf("failed to add %s: %w", name, err)
+			}
+		}
+		previous = written
+
+		author := commit.Author
+		options := commitOptions()
+		options.Author = &author
+		_, err = target.Commit(commit.Message, options)
+		if err != nil && !errors.Is(err, git.ErrEmptyCommit) {
+			return fmt.Errorf("failed to replay %s: %w", commit.Hash.String()[:7], err)
+		}
+	}
+	return nil
+}
+
+// runMigrate moves a stack to another repository: its files are committed
+// there first, as a single created commit or with their history, then removed
+// from this repository
+func runMigrate(repo *git.Repository, args []string) error {
+	if len(args) != 1 {
+		return errors.New("expected a stack name")
+	}
+	if migrateToFlag == "" {
+		return errors.New("--to is required")
+	}
+	stack := args[0]
+
+	dir, err := stackDirectory(repo, stack)
+	if err != nil {
+		return err
+	}
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return fmt.Errorf("failed to get worktree: %w", err)
+	}
+	status, err := worktree.Status()
+	if err != nil {
+		return fmt.Errorf("failed to get status: %w", err)
+	}
+	for name, fileStatus := range status {
+		if strings.HasPrefix(name, dir+"/") && fileStatus.Worktree != git.Untracked {
+			return fmt.Errorf("stack %s has uncommitted changes in %s, commit or discard them first", stack, name)
+		}
+	}
+
+	target, err := openRepository(migrateToFlag)
+	if err != nil {
+		return fmt.Errorf("failed to open target repository: %w", err)
+	}
+	targetWorktree, err := target.Worktree()
+	if err != nil {
+		return fmt.Errorf("failed to get target worktree: %w", err)
+	}
+	if _, err := os.Stat(filepath.Join(targetWorktree.Filesystem.Root(), filepath.FromSlash(dir))); err == nil {
+		return fmt.Errorf("%s already exists in %s", dir, migrateToFlag)
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		return fmt.Errorf("failed to resolve HEAD: %w", err)
+	}
+	headCommit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return err
+	}
+
+	if migrateHistoryFlag {
+		history, err := stackHistory(repo, dir)
+		if err != nil {
+			return err
+		}
+		if err := replayStack(targetWorktree, dir, history); err != nil {
+			return err
+		}
+		fmt.Printf("Replayed %d commits of stack %s in %s\n", len(history), stack, migrateToFlag)
+	} else {
+		written, _, err := writeStackFiles(targetWorktree.Filesystem.Root(), dir, headCommit, nil)
+		if err != nil {
+			return err
+		}
+		for _, name := range written {
+			if _, err := targetWorktree.Add(name); err != nil {
+				return fmt.Errorf("failed to add %s: %w", name, err)
+			}
+		}
+		change := Change{StackName: stack, FilePath: dir, ChangeType: Created, Class: ClassDeployment}
+		message := withTrailers(commitSubject(change)+"\n\nMigrated from "+repoFlag[0], ownTrailer(change))
+		if _, err := targetWorktree.Commit(message, commitOptions()); err != nil {
+			return fmt.Errorf("failed to commit in target repository: %w", err)
+		}
+		fmt.Printf("Created stack %s in %s\n", stack, migrateToFlag)
+	}
+
+	idx, err := repo.Storer.Index()
+	if err != nil {
+		return err
+	}
+	for _, entry := range slices.Clone(idx.Entries) {
+		if strings.HasPrefix(entry.Name, dir+"/") {
+			if _, err := worktree.Remove(entry.Name); err != nil {
+				return fmt.Errorf("failed to remove %s: %w", entry.Name, err)
+			}
+		}
+	}
+	change := Change{StackName: stack, FilePath: dir, ChangeType: Deleted, Class: ClassDeployment}
+	message := withTrailers(commitSubject(change)+"\n\nMigrated to "+migrateToFlag, ownTrailer(change))
+	commit, err := worktree.Commit(message, commitOptions())
+	if err != nil {
+		return fmt.Errorf("failed to commit: %w", err)
+	}
+	fmt.Printf("Removed stack %s (%s)\n", stack, commit.String()[:7])
+
+	if left, _ := os.ReadDir(filepath.Join(worktree.Filesystem.Root(), filepath.FromSlash(dir))); len(left) > 0 {
+		opsLog.Warn("Untracked files were left in the stack directory", "path", dir, "count", len(left))
+	}
+
+	if pushFlag {
+		if err := pushToRemote(target); err != nil {
+			return err
+		}
+		return pushToRemote(repo)
+	}
+	return nil
+}
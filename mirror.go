@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/config"
+	"github.com/go-git/go-git/v6/plumbing/transport"
+)
+
+// prepareMirror clones --remote-url for remote-first mode, in --repo when
+// given or a private temporary directory otherwise, and returns its path
+// with a function removing the temporary clone. An existing clone in --repo
+// is reused.
+func prepareMirror() (string, func(), error) {
+	cleanup := func() {}
+
+	dir := ""
+	if len(repoFlag) == 1 {
+		dir = repoFlag[0]
+		if _, err := git.PlainOpen(dir); err == nil {
+			opsLog.Info("Reusing the remote-first clone", "path", dir)
+			return dir, cleanup, nil
+		}
+	} else {
+		tmp, err := os.MkdirTemp("", "git-stack-watch-")
+		if err != nil {
+			return "", cleanup, err
+		}
+		dir = tmp
+		cleanup = func() { os.RemoveAll(tmp) }
+	}
+
+	err := withURLAuth(remoteURLFlag, func(auth transport.AuthMethod) error {
+		_, err := git.PlainClone(dir, &git.CloneOptions{URL: remoteURLFlag, Auth: auth})
+		return err
+	})
+	if errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		// A new remote gets its first commit from the watcher
+		os.RemoveAll(filepath.Join(dir, ".git"))
+		err = initMirror(dir)
+	}
+	if err != nil {
+		cleanup()
+		return "", func() {}, fmt.Errorf("failed to clone %s: %w", redact(remoteURLFlag, nil), err)
+	}
+
+	opsLog.Info("Cloned the remote for remote-first mode", "remote", redact(remoteURLFlag, nil), "path", dir)
+	return dir, cleanup, nil
+}
+
+// initMirror creates an empty repository pointing at --remote-url
+func initMirror(dir string) error {
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		return err
+	}
+	_, err = repo.CreateRemote(&config.RemoteConfig{Name: git.DefaultRemoteName, URLs: []string{remoteURLFlag}})
+	return err
+}
+
+// mirroredFiles returns the files of a tree copied in remote-first mode,
+// relative and slash separated: its compose files and, with --stack-dir, the
+// other files of their stacks
+func mirroredFiles(root string) (map[string]bool, error) {
+	skipped := skipDirs()
+
+	var all []string
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if d.IsDir() {
+			if rel != "." && (d.Name() == ".git" || skipped[d.Name()] || isArchived(rel+"/")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Type().IsRegular() {
+			all = append(all, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	files := map[string]bool{}
+	dirs := map[string]string{}
+	for _, rel := range all {
+		if isWatchedFile(rel) {
+			files[rel] = true
+		}
+		if isComposeFile(rel) {
+			dirs[path.Dir(rel)] = rel
+		}
+	}
+	if stackDirFlag {
+		for _, rel := range all {
+			if _, ok := owningStack(rel, dirs); ok {
+				files[rel] = true
+			}
+		}
+	}
+	return files, nil
+}
+
+// syncSource makes the clone's mirrored files match --source: new and
+// changed files are copied, and mirrored files gone from the source are
+// removed. The source is only read.
+func syncSource(source string, clone string) error {
+	wanted, err := mirroredFiles(source)
+	if err != nil {
+		return fmt.Errorf("failed to scan %s: %w", source, err)
+	}
+
+	for rel := range wanted {
+		from := filepath.Join(source, filepath.FromSlash(rel))
+		to := filepath.Join(clone, filepath.FromSlash(rel))
+
+		data, err := os.ReadFile(from)
+		if err != nil {
+			return err
+		}
+		info, err := os.Stat(from)
+		if err != nil {
+			return err
+		}
+		if current, err := os.ReadFile(to); err == nil && bytes.Equal(current, data) {
+			if target, err := os.Stat(to); err == nil && target.Mode().Perm() == info.Mode().Perm() {
+				continue
+			}
+		}
+
+		if err := os.MkdirAll(filepath.Dir(to), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(to, data, info.Mode().Perm()); err != nil {
+			return err
+		}
+		if err := os.Chmod(to, info.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+
+	present, err := mirroredFiles(clone)
+	if err != nil {
+		return fmt.Errorf("failed to scan %s: %w", clone, err)
+	}
+	for rel := range present {
+		if !wanted[rel] {
+			if err := os.Remove(filepath.Join(clone, filepath.FromSlash(rel))); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/config"
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/filemode"
+	"github.com/go-git/go-git/v6/plumbing/object"
+	"github.com/go-git/go-git/v6/plumbing/transport"
+)
+
+// notes are the git notes of a notes ref, keyed by annotated commit, along
+// with the commit of the ref they were read from
+type notes struct {
+	ref    plumbing.ReferenceName
+	parent plumbing.Hash
+	byHash map[plumbing.Hash]string
+}
+
+// readNotes reads the notes of a ref such as refs/notes/commits, which is a
+// commit whose tree holds one blob per annotated commit, named after its hash
+// and possibly split in fanout directories ("ab/cdef...")
+func readNotes(repo *git.Repository, ref plumbing.ReferenceName) (*notes, error) {
+	n := &notes{ref: ref, byHash: map[plumbing.Hash]string{}}
+
+	head, err := repo.Reference(ref, true)
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return n, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	n.parent = head.Hash()
+
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", ref, err)
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	walker := object.NewTreeWalker(tree, true, nil)
+	defer walker.Close()
+	for {
+		name, entry, err := walker.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !entry.Mode.IsFile() {
+			continue
+		}
+
+		hash, ok := plumbing.FromHex(strings.ReplaceAll(name, "/", ""))
+		if !ok {
+			continue
+		}
+		blob, err := repo.BlobObject(entry.Hash)
+		if err != nil {
+			return nil, err
+		}
+		reader, err := blob.Reader()
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			return nil, err
+		}
+		n.byHash[hash] = string(data)
+	}
+	return n, nil
+}
+
+// write commits the notes as a new commit of their ref, on top of the one
+// they were read from
+func (n *notes) write(repo *git.Repository, message string) error {
+	hashes := make([]plumbing.Hash, 0, len(n.byHash))
+	for hash := range n.byHash {
+		hashes = append(hashes, hash)
+	}
+	sort.Slice(hashes, func(i, j int) bool { return hashes[i].String() < hashes[j].String() })
+
+	tree := &object.Tree{}
+	for _, hash := range hashes {
+		blob := repo.Storer.NewEncodedObject()
+		blob.SetType(plumbing.BlobObject)
+		writer, err := blob.Writer()
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(writer, n.byHash[hash]); err != nil {
+			return err
+		}
+		if err := writer.Close(); err != nil {
+			return err
+		}
+		blobHash, err := repo.Storer.SetEncodedObject(blob)
+		if err != nil {
+			return err
+		}
+		tree.Entries = append(tree.Entries, object.TreeEntry{Name: hash.String(), Mode: filemode.Regular, Hash: blobHash})
+	}
+
+	treeHash, err := storeObject(repo, tree)
+	if err != nil {
+		return err
+	}
+
+	signature := watcherSignature(repo)
+	commit := &object.Commit{
+		Author:    signature,
+		Committer: signature,
+		Message:   message,
+		TreeHash:  treeHash,
+	}
+	if !n.parent.IsZero() {
+		commit.ParentHashes = []plumbing.Hash{n.parent}
+	}
+	commitHash, err := storeObject(repo, commit)
+	if err != nil {
+		return err
+	}
+
+	if err := repo.Storer.SetReference(plumbing.NewHashReference(n.ref, commitHash)); err != nil {
+		return err
+	}
+	n.parent = commitHash
+	return nil
+}
+
+// watcherSignature returns the signature of the commits the watcher writes
+// itself, like notes and rebased commits: its commit author, from the options
+// or the git configuration
+func watcherSignature(repo *git.Repository) object.Signature {
+	if options := commitOptions(); options.Author != nil {
+		return *options.Author
+	}
+	signature := object.Signature{Name: "git-stack-watch", Email: "git-stack-watch@localhost", When: time.Now()}
+	if cfg, err := repo.ConfigScoped(config.GlobalScope); err == nil && cfg.User.Name != "" {
+		signature.Name, signature.Email = cfg.User.Name, cfg.User.Email
+	}
+	return signature
+}
+
+// fetchNotes updates a notes ref from the remote, failing when both sides
+// have new notes, so notes written on top of it can be pushed
+func fetchNotes(repo *git.Repository, ref plumbing.ReferenceName) error {
+	refSpec := config.RefSpec(fmt.Sprintf("%s:%s", ref, ref))
+	err := withAuth(repo, func(auth transport.AuthMethod) error {
+		return repo.Fetch(&git.FetchOptions{Auth: auth, RefSpecs: []config.RefSpec{refSpec}})
+	})
+	switch {
+	case err == nil, errors.Is(err, git.NoErrAlreadyUpToDate), errors.Is(err, git.ErrRemoteRefNotFound), errors.Is(err, transport.ErrEmptyRemoteRepository):
+		return nil
+	default:
+		return fmt.Errorf("failed to fetch %s: %w", ref, err)
+	}
+}
+
+// pushNotes pushes a notes ref to the remote, which git doesn't do with
+// branches
+func pushNotes(repo *git.Repository, ref plumbing.ReferenceName) error {
+	refSpec := config.RefSpec(fmt.Sprintf("%s:%s", ref, ref))
+	err := withAuth(repo, func(auth transport.AuthMethod) error {
+		return repo.Push(&git.PushOptions{Auth: auth, RefSpecs: []config.RefSpec{refSpec}})
+	})
+	if errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to push %s: %w", ref, err)
+	}
+	eventLog.Info("✓ Pushed notes", "ref", ref)
+	return nil
+}
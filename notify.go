@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// Lifecycle events notified by --notify-cmd
+const (
+	notifyStart = "start"
+	notifyStop  = "stop"
+	notifyCrash = "crash"
+	// notifyDiverged is sent when pushes stop because the remote diverged
+	notifyDiverged = "diverged"
+)
+
+// notifyDescriptions completes the notification message of each event
+var notifyDescriptions = map[string]string{
+	notifyStart:    "started",
+	notifyStop:     "stopped",
+	notifyCrash:    "stopped unexpectedly",
+	notifyDiverged: "stopped pushing",
+}
+
+// notifyTimeout bounds how long a notification command may run, so a hung
+// command doesn't hold the shutdown
+const notifyTimeout = 30 * time.Second
+
+// notifying is set once the watcher has started, so only its lifecycle is
+// notified, not subcommands or invalid options
+var notifying bool
+
+// notifyEvents returns the events listed in --notify-events
+func notifyEvents() map[string]bool {
+	events := map[string]bool{}
+	for _, event := range strings.Split(notifyEventsFlag, ",") {
+		if event = strings.TrimSpace(event); event != "" {
+			events[event] = true
+		}
+	}
+	return events
+}
+
+// parseNotifyEvents validates the events listed in --notify-events
+func parseNotifyEvents() error {
+	for event := range notifyEvents() {
+		if _, ok := notifyDescriptions[event]; !ok {
+			return fmt.Errorf("unknown event %q, expected '%s', '%s', '%s' or '%s'", event, notifyStart, notifyStop, notifyCrash, notifyDiverged)
+		}
+	}
+	return nil
+}
+
+// notify runs --notify-cmd with a message describing a lifecycle event as
+// its last argument. The event, reason, host and repositories are also
+// passed as NOTIFY_* environment variables.
+func notify(event string, reason string) {
+	args := strings.Fields(notifyCmdFlag)
+	if !notifying || len(args) == 0 || !notifyEvents()[event] {
+		return
+	}
+
+	host, _ := os.Hostname()
+	message := fmt.Sprintf("git-stack-watch %s on %s", notifyDescriptions[event], host)
+	if reason != "" {
+		message += ": " + reason
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, args[0], append(args[1:], message)...)
+	cmd.Env = append(os.Environ(),
+		"NOTIFY_EVENT="+event,
+		"NOTIFY_REASON="+reason,
+		"NOTIFY_HOST="+host,
+		"NOTIFY_REPO="+repoFlag.String(),
+	)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		opsLog.Warn("Notification command failed", "event", event, "error", err, "output", strings.TrimSpace(string(output)))
+		return
+	}
+	opsLog.Debug("Notification sent", "event", event)
+}
+
+// fatalReason describes a fatal log record for the crash notification: its
+// message, followed by its error when it has one
+func fatalReason(msg string, args []any) string {
+	for i := 0; i+1 < len(args); i += 2 {
+		if args[i] == "error" {
+			return fmt.Sprintf("%s: %v", msg, args[i+1])
+		}
+	}
+	return msg
+}
+
+// notifyPanic notifies a crash when the calling goroutine panics, then lets
+// the panic go on
+func notifyPanic() {
+	if r := recover(); r != nil {
+		writeDebugBundle(fmt.Sprintf("panic: %v", r), debug.Stack())
+		notify(notifyCrash, fmt.Sprintf("panic: %v", r))
+		panic(r)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Priorities of push notifications, on ntfy's scale
+const (
+	priorityLow     = 2
+	priorityDefault = 3
+	priorityHigh    = 4
+	priorityUrgent  = 5
+)
+
+// gotifyPriorities maps the ntfy priorities to Gotify's 0-10 scale
+var gotifyPriorities = map[int]int{
+	priorityLow:     2,
+	priorityDefault: 5,
+	priorityHigh:    7,
+	priorityUrgent:  10,
+}
+
+// eventPriority returns the priority of an event: deletions and push failures
+// are high, and push failures turn urgent once they repeat --escalate-after
+// times in a row
+func eventPriority(payload webhookPayload) int {
+	switch payload.Event {
+	case webhookStackDeleted:
+		return priorityHigh
+	case webhookPushFailed:
+		if escalateAfterFlag > 0 && payload.Failures >= escalateAfterFlag {
+			return priorityUrgent
+		}
+		return priorityHigh
+	}
+	return priorityDefault
+}
+
+// notificationTitle is the title of push notifications
+func notificationTitle() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("git-stack-watch on %s", host)
+}
+
+// postNtfy publishes a message to --ntfy-topic on the --ntfy-url server
+func postNtfy(event string, message string, priority int) {
+	if ntfyURLFlag == "" {
+		return
+	}
+	document := map[string]any{
+		"topic":    ntfyTopicFlag,
+		"title":    notificationTitle(),
+		"message":  message,
+		"priority": priority,
+	}
+	postJSON("ntfy", event, strings.TrimSuffix(ntfyURLFlag, "/"), secretValue(ntfyTokenFlag, "NTFY_TOKEN"), document)
+}
+
+// postGotify sends a message to the --gotify-url server, as the application
+// of --gotify-token
+func postGotify(event string, message string, priority int) {
+	if gotifyURLFlag == "" {
+		return
+	}
+	document := map[string]any{
+		"title":    notificationTitle(),
+		"message":  message,
+		"priority": gotifyPriorities[priority],
+	}
+	postJSON("gotify", event, strings.TrimSuffix(gotifyURLFlag, "/")+"/message", secretValue(gotifyTokenFlag, "GOTIFY_TOKEN"), document)
+}
@@ -0,0 +1,32 @@
+package main
+
+// Exit statuses of --once, for the systemd unit or cron job running it
+const (
+	onceOK      = 0
+	onceErrors  = 1
+	onceCommits = 2
+)
+
+// runOnce runs a single cycle on every repository, pushing its commits with
+// --push, and returns the exit status: onceErrors when the cycles logged
+// errors, onceCommits when commits were created, onceOK otherwise. Errors
+// logged at startup, before the cycles, don't count.
+func runOnce(watched []*watchedRepo) int {
+	baseline := recentLogs.errorCount()
+	commits := 0
+	for _, w := range watched {
+		w.check()
+		commits += w.state.cycleCommits
+	}
+
+	errors := recentLogs.errorCount() - baseline
+	status := onceOK
+	switch {
+	case errors > 0:
+		status = onceErrors
+	case commits > 0:
+		status = onceCommits
+	}
+	opsLog.Info("Single cycle done, exiting", "commits", commits, "errors", errors, "status", status)
+	return status
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// openAPIDocument describes the HTTP endpoints of the watcher, for
+// integrators and the client package
+//
+//go:embed templates/api/openapi.json
+var openAPIDocument []byte
+
+func handleOpenAPI(rw http.ResponseWriter, _ *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Write(openAPIDocument)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/go-git/go-git/v6/plumbing/object"
+)
+
+// ownTrailerKey is the trailer tagging the watcher's own commits, whatever
+// their subject looks like with --commit-template
+const ownTrailerKey = "Stack-Watch"
+
+// ownTrailer returns the trailer of a stack commit, such as "Stack-Watch:
+// updated nginx"
+func ownTrailer(change Change) string {
+	return ownTrailerKey + ": " + string(change.ChangeType) + " " + change.StackName
+}
+
+// ownTrailerValue returns the value of a commit's Stack-Watch trailer
+func ownTrailerValue(message string) (string, bool) {
+	for _, trailer := range commitTrailers(message) {
+		if value, ok := strings.CutPrefix(trailer, ownTrailerKey+": "); ok {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// isOwnCommit reports whether the watcher created a commit: it carries the
+// Stack-Watch trailer, or it was authored as --author-email. Reconciliation
+// logic skips these so it never processes the watcher's changes twice.
+func isOwnCommit(commit *object.Commit) bool {
+	if _, ok := ownTrailerValue(commit.Message); ok {
+		return true
+	}
+	return authorEmailFlag != "" && commit.Author.Email == authorEmailFlag
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// matchesPattern reports whether a repository path matches a --pattern.
+// Patterns without a slash match the file name, the others the whole path.
+func matchesPattern(filePath string) bool {
+	for _, pattern := range patternFlag {
+		name := filePath
+		if !strings.Contains(pattern, "/") {
+			name = path.Base(filePath)
+		}
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// isWatchedFile reports whether a repository path is a compose file or
+// another file matching a --pattern
+func isWatchedFile(filePath string) bool {
+	return isComposeFile(filePath) || matchesPattern(filePath)
+}
+
+// parsePatterns validates the --pattern globs
+func parsePatterns() error {
+	for _, pattern := range patternFlag {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("%q: %w", pattern, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// prettyMessageWidth is the column messages are padded to in pretty logs
+const prettyMessageWidth = 36
+
+// ANSI colors of the pretty console
+const (
+	colorReset  = "\x1b[0m"
+	colorDim    = "\x1b[2m"
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+	colorBlue   = "\x1b[34m"
+	colorGray   = "\x1b[90m"
+)
+
+// stackColors tell stacks apart, each stack always getting the same one
+var stackColors = []string{"\x1b[32m", "\x1b[33m", "\x1b[34m", "\x1b[35m", "\x1b[36m", "\x1b[92m", "\x1b[94m", "\x1b[95m", "\x1b[96m"}
+
+// stackOutcome is what happened to a stack during a cycle, for its summary
+type stackOutcome struct {
+	change string
+	result string
+	detail string
+}
+
+// prettyConsole is a terminal shared by the pretty handlers writing to it,
+// so that both log streams line up and feed the same cycle summary
+type prettyConsole struct {
+	mu         sync.Mutex
+	out        io.Writer
+	color      bool
+	stackWidth int
+
+	// outcomes of the stacks seen since the last summary, in order
+	outcomes map[string]*stackOutcome
+	stacks   []string
+}
+
+var (
+	prettyConsolesMu sync.Mutex
+	prettyConsoles   = map[io.Writer]*prettyConsole{}
+)
+
+// prettyConsoleFor returns the console of a writer, colored when it's a
+// terminal and NO_COLOR isn't set
+func prettyConsoleFor(out io.Writer) *prettyConsole {
+	prettyConsolesMu.Lock()
+	defer prettyConsolesMu.Unlock()
+
+	if console, ok := prettyConsoles[out]; ok {
+		return console
+	}
+	console := &prettyConsole{out: out, color: isTerminal(out) && os.Getenv("NO_COLOR") == "", stackWidth: 8, outcomes: map[string]*stackOutcome{}}
+	prettyConsoles[out] = console
+	return console
+}
+
+// isTerminal reports whether a writer is a character device such as a tty
+func isTerminal(out io.Writer) bool {
+	file, ok := out.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func (c *prettyConsole) paint(color, text string) string {
+	if !c.color || text == "" {
+		return text
+	}
+	return color + text + colorReset
+}
+
+// pad right-pads a text to a width in runes
+func pad(text string, width int) string {
+	if n := utf8.RuneCountInString(text); n < width {
+		return text + strings.Repeat(" ", width-n)
+	}
+	return text
+}
+
+func levelColor(level slog.Level) string {
+	switch {
+	case level >= slog.LevelError:
+		return colorRed
+	case level >= slog.LevelWarn:
+		return colorYellow
+	case level >= slog.LevelInfo:
+		return colorBlue
+	}
+	return colorGray
+}
+
+func stackColor(stack string) string {
+	h := fnv.New32a()
+	h.Write([]byte(stack))
+	return stackColors[h.Sum32()%uint32(len(stackColors))]
+}
+
+// record remembers what a log record tells about a stack: its change, and
+// whether it was committed (a record with its hash), held (a warning with a
+// reason) or failed (an error)
+func (c *prettyConsole) record(level slog.Level, message, stack string, attrs map[string]string) {
+	outcome, ok := c.outcomes[stack]
+	if !ok {
+		outcome = &stackOutcome{result: "detected"}
+		c.outcomes[stack] = outcome
+		c.stacks = append(c.stacks, stack)
+	}
+	if change := attrs["change"]; change != "" {
+		outcome.change = change
+	}
+
+	switch {
+	case outcome.result == "committed":
+	case attrs["hash"] != "":
+		outcome.result, outcome.detail = "committed", attrs["hash"]
+		if branch := attrs["branch"]; branch != "" {
+			outcome.detail += " on " + branch
+		}
+	case level >= slog.LevelWarn && level < slog.LevelError && attrs["reason"] != "":
+		// Gates log why they refuse a change before it's held
+		outcome.result, outcome.detail = "held", attrs["reason"]
+	case level >= slog.LevelError && outcome.result != "held":
+		outcome.result, outcome.detail = "failed", firstOf(attrs["error"], message)
+	}
+}
+
+func firstOf(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// summarize prints the table of the stacks seen since the last summary, and
+// forgets them
+func (c *prettyConsole) summarize() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.stacks) == 0 {
+		return
+	}
+
+	// Columns are padded before coloring, so that colors don't count as width
+	widths := [3]int{len("STACK"), len("CHANGE"), len("committed")}
+	for _, stack := range c.stacks {
+		widths[0] = max(widths[0], utf8.RuneCountInString(stack))
+		widths[1] = max(widths[1], utf8.RuneCountInString(c.outcomes[stack].change))
+	}
+
+	counts := map[string]int{}
+	var b strings.Builder
+	fmt.Fprintf(&b, "  %s  %s  %s  %s\n", c.paint(colorDim, pad("STACK", widths[0])), c.paint(colorDim, pad("CHANGE", widths[1])), c.paint(colorDim, pad("RESULT", widths[2])), c.paint(colorDim, "DETAIL"))
+	for _, stack := range c.stacks {
+		outcome := c.outcomes[stack]
+		counts[outcome.result]++
+
+		result := pad(outcome.result, widths[2])
+		switch outcome.result {
+		case "committed":
+			result = c.paint(colorGreen, result)
+		case "held":
+			result = c.paint(colorYellow, result)
+		case "failed":
+			result = c.paint(colorRed, result)
+		}
+		detail := strings.ReplaceAll(outcome.detail, "\n", " ")
+		fmt.Fprintf(&b, "  %s  %s  %s  %s\n", c.paint(stackColor(stack), pad(stack, widths[0])), pad(outcome.change, widths[1]), result, detail)
+	}
+
+	fmt.Fprintf(c.out, "%s %d committed, %d held, %d failed\n%s", c.paint(colorDim, "── Cycle summary:"), counts["committed"], counts["held"], counts["failed"], b.String())
+	c.outcomes, c.stacks = map[string]*stackOutcome{}, nil
+}
+
+// printCycleSummary prints the summary table of the cycle that just ended on
+// every pretty console
+func printCycleSummary() {
+	prettyConsolesMu.Lock()
+	consoles := make([]*prettyConsole, 0, len(prettyConsoles))
+	for _, console := range prettyConsoles {
+		consoles = append(consoles, console)
+	}
+	prettyConsolesMu.Unlock()
+
+	for _, console := range consoles {
+		console.summarize()
+	}
+}
+
+// prettyHandler renders records for people watching a terminal, with
+// --log-format pretty: colored levels, aligned columns and the stack first
+//
+//	13:28:08 INFO  web       ✓ Created commit                     change=updated hash=c6b9c5a
+type prettyHandler struct {
+	console *prettyConsole
+	level   slog.Leveler
+	attrs   []slog.Attr
+	prefix  string
+}
+
+func newPrettyHandler(out io.Writer, level slog.Leveler) *prettyHandler {
+	return &prettyHandler{console: prettyConsoleFor(out), level: level}
+}
+
+func (h *prettyHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *prettyHandler) Handle(_ context.Context, r slog.Record) error {
+	// The stack gets its own column, and the ID of the cycle is left out as
+	// the cycle summary separates cycles
+	var stack string
+	values := map[string]string{}
+	var rest strings.Builder
+	add := func(prefix string, a slog.Attr) bool {
+		switch key := prefix + a.Key; key {
+		case "stack":
+			stack = a.Value.String()
+		case "run":
+		default:
+			values[key] = a.Value.String()
+			writeAttr(&rest, prefix, a)
+		}
+		return true
+	}
+	for _, a := range h.attrs {
+		add("", a)
+	}
+	r.Attrs(func(a slog.Attr) bool { return add(h.prefix, a) })
+
+	c := h.console
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if n := utf8.RuneCountInString(stack); n > c.stackWidth {
+		c.stackWidth = n
+	}
+	if stack != "" {
+		c.record(r.Level, r.Message, stack, values)
+	}
+
+	var b strings.Builder
+	b.WriteString(c.paint(colorGray, r.Time.Format("15:04:05")))
+	b.WriteByte(' ')
+	b.WriteString(c.paint(levelColor(r.Level), pad(r.Level.String(), 5)))
+	b.WriteByte(' ')
+	b.WriteString(c.paint(stackColor(stack), pad(stack, c.stackWidth)))
+	b.WriteByte(' ')
+	if rest.Len() == 0 {
+		b.WriteString(r.Message)
+	} else {
+		b.WriteString(pad(r.Message, prettyMessageWidth))
+		b.WriteString(c.paint(colorDim, rest.String()))
+	}
+	_, err := io.WriteString(c.out, b.String()+"\n")
+	return err
+}
+
+func (h *prettyHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append([]slog.Attr{}, h.attrs...)
+	for _, a := range attrs {
+		// Attributes added before, in groups since closed, keep their group
+		a.Key = h.prefix + a.Key
+		clone.attrs = append(clone.attrs, a)
+	}
+	return &clone
+}
+
+func (h *prettyHandler) WithGroup(name string) slog.Handler {
+	clone := *h
+	clone.prefix += name + "."
+	return &clone
+}
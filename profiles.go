@@ -0,0 +1,84 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/go-git/go-git/v6"
+)
+
+// defaultProfile stands for services without profiles, which compose always
+// starts
+const defaultProfile = "default"
+
+// changedServices returns the services a compose change adds, removes or
+// modifies
+func changedServices(before, after []byte) []string {
+	// Unparsable versions count as having no services
+	oldServices, _ := serviceDefinitions(before)
+	newServices, _ := serviceDefinitions(after)
+
+	changed := map[string]bool{}
+	for name, old := range oldServices {
+		if cur, ok := newServices[name]; !ok || !reflect.DeepEqual(old, cur) {
+			changed[name] = true
+		}
+	}
+	for name := range newServices {
+		if _, ok := oldServices[name]; !ok {
+			changed[name] = true
+		}
+	}
+	return sortedKeys(changed)
+}
+
+// affectedProfiles returns the profiles of the services a change touches,
+// in both versions, with "default" for services without profiles. It is empty
+// unless one of those services is behind a profile.
+func affectedProfiles(before, after []byte) []string {
+	old, _ := parseCompose(before)
+	cur, _ := parseCompose(after)
+
+	profiles := map[string]bool{}
+	gated := false
+	for _, name := range changedServices(before, after) {
+		for _, compose := range []*composeFile{old, cur} {
+			if compose == nil {
+				continue
+			}
+			service, ok := compose.Services[name]
+			if !ok {
+				continue
+			}
+			if len(service.Profiles) == 0 {
+				profiles[defaultProfile] = true
+			}
+			for _, profile := range service.Profiles {
+				profiles[profile] = true
+				gated = true
+			}
+		}
+	}
+
+	if !gated {
+		return nil
+	}
+	return sortedKeys(profiles)
+}
+
+// profilesBody returns the commit body line listing the profiles a stack
+// change affects, empty when its services don't use profiles
+func profilesBody(repo *git.Repository, repoPath string, change Change) string {
+	// Missing versions of created or deleted files count as empty
+	before, _ := headFileContents(repo, change.FilePath)
+	var after []byte
+	if change.ChangeType != Deleted {
+		after, _ = readWorktreeFile(repoPath, change.FilePath)
+	}
+
+	profiles := affectedProfiles(before, after)
+	if len(profiles) == 0 {
+		return ""
+	}
+	return "Affected profiles: " + strings.Join(profiles, ", ")
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/config"
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/filemode"
+	"github.com/go-git/go-git/v6/plumbing/object"
+	"github.com/go-git/go-git/v6/plumbing/transport"
+)
+
+// errDiverged is returned when the remote branch has commits that can't be
+// reconciled automatically with the local ones
+var errDiverged = errors.New("remote diverged")
+
+// encodable is an object that can be written to the object storage
+type encodable interface {
+	Encode(o plumbing.EncodedObject) error
+}
+
+// storeObject writes an object to the repository's storage
+func storeObject(repo *git.Repository, obj encodable) (plumbing.Hash, error) {
+	encoded := repo.Storer.NewEncodedObject()
+	if err := obj.Encode(encoded); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return repo.Storer.SetEncodedObject(encoded)
+}
+
+// treeFiles flattens a tree into its file entries, keyed by path
+func treeFiles(tree *object.Tree) (map[string]object.TreeEntry, error) {
+	files := map[string]object.TreeEntry{}
+	walker := object.NewTreeWalker(tree, true, nil)
+	defer walker.Close()
+	for {
+		name, entry, err := walker.Next()
+		if errors.Is(err, io.EOF) {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if entry.Mode != filemode.Dir {
+			files[name] = entry
+		}
+	}
+}
+
+// commitFiles returns the file entries of a commit's tree
+func commitFiles(commit *object.Commit) (map[string]object.TreeEntry, error) {
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	return treeFiles(tree)
+}
+
+// buildTree writes the trees holding a set of file entries and returns the
+// hash of the root one
+func buildTree(repo *git.Repository, files map[string]object.TreeEntry) (plumbing.Hash, error) {
+	subdirs := map[string]map[string]object.TreeEntry{}
+	tree := &object.Tree{}
+	for name, entry := range files {
+		dir, rest, nested := strings.Cut(name, "/")
+		if !nested {
+			entry.Name = name
+			tree.Entries = append(tree.Entries, entry)
+			continue
+		}
+		if subdirs[dir] == nil {
+			subdirs[dir] = map[string]object.TreeEntry{}
+		}
+		subdirs[dir][rest] = entry
+	}
+
+	for dir, subfiles := range subdirs {
+		hash, err := buildTree(repo, subfiles)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		tree.Entries = append(tree.Entries, object.TreeEntry{Name: dir, Mode: filemode.Dir, Hash: hash})
+	}
+
+	// Git orders directories as if their name ended with a slash
+	sortName := func(entry object.TreeEntry) string {
+		if entry.Mode == filemode.Dir {
+			return entry.Name + "/"
+		}
+		return entry.Name
+	}
+	sort.Slice(tree.Entries, func(i, j int) bool { return sortName(tree.Entries[i]) < sortName(tree.Entries[j]) })
+
+	return storeObject(repo, tree)
+}
+
+// sameEntry reports whether two optional tree entries hold the same file
+func sameEntry(a object.TreeEntry, inA bool, b object.TreeEntry, inB bool) bool {
+	if !inA || !inB {
+		return inA == inB
+	}
+	return a.Hash == b.Hash && a.Mode == b.Mode
+}
+
+// changedPaths returns the paths whose entries differ between two sets of
+// files
+func changedPaths(from, to map[string]object.TreeEntry) []string {
+	var paths []string
+	for name, entry := range from {
+		other, ok := to[name]
+		if !sameEntry(entry, true, other, ok) {
+			paths = append(paths, name)
+		}
+	}
+	for name := range to {
+		if _, ok := from[name]; !ok {
+			paths = append(paths, name)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// rebaseOnto replays the local commits since base on top of the remote
+// commit, file by file: a file changed by a local commit takes its new
+// version unless the remote changed it differently, which is a conflict.
+// Commits whose changes are already on the remote are dropped.
+func rebaseOnto(repo *git.Repository, base, local, remote *object.Commit) (*object.Commit, error) {
+	var pending []*object.Commit
+	for commit := local; commit.Hash != base.Hash; {
+		if commit.NumParents() != 1 {
+			return nil, fmt.Errorf("%w: %s is a merge commit, it can't be rebased", errDiverged, commit.Hash.String()[:7])
+		}
+		pending = append(pending, commit)
+		parent, err := commit.Parent(0)
+		if err != nil {
+			return nil, err
+		}
+		commit = parent
+	}
+
+	current, err := commitFiles(remote)
+	if err != nil {
+		return nil, err
+	}
+	tip := remote
+	for i := len(pending) - 1; i >= 0; i-- {
+		commit := pending[i]
+		parent, err := commit.Parent(0)
+		if err != nil {
+			return nil, err
+		}
+		before, err := commitFiles(parent)
+		if err != nil {
+			return nil, err
+		}
+		after, err := commitFiles(commit)
+		if err != nil {
+			return nil, err
+		}
+
+		var conflicts []string
+		for _, name := range changedPaths(before, after) {
+			cur, inCur := current[name]
+			old, inOld := before[name]
+			updated, inUpdated := after[name]
+			switch {
+			case sameEntry(cur, inCur, old, inOld):
+				if inUpdated {
+					current[name] = updated
+				} else {
+					delete(current, name)
+				}
+			case sameEntry(cur, inCur, updated, inUpdated):
+			default:
+				conflicts = append(conflicts, name)
+			}
+		}
+		if len(conflicts) > 0 {
+			return nil, fmt.Errorf("%w: %s changed both locally (%s) and on the remote", errDiverged, strings.Join(conflicts, ", "), commit.Hash.String()[:7])
+		}
+
+		treeHash, err := buildTree(repo, current)
+		if err != nil {
+			return nil, err
+		}
+		if treeHash == tip.TreeHash {
+			opsLog.Info("Dropping a commit already applied on the remote", "hash", commit.Hash.String()[:7])
+			continue
+		}
+
+		committer := watcherSignature(repo)
+		committer.When = time.Now()
+		rebased := &object.Commit{
+			Author:       commit.Author,
+			Committer:    committer,
+			Message:      commit.Message,
+			TreeHash:     treeHash,
+			ParentHashes: []plumbing.Hash{tip.Hash},
+		}
+		if err := signCommitObject(rebased); err != nil {
+			return nil, err
+		}
+		hash, err := storeObject(repo, rebased)
+		if err != nil {
+			return nil, err
+		}
+		if tip, err = repo.CommitObject(hash); err != nil {
+			return nil, err
+		}
+	}
+	return tip, nil
+}
+
+// moveTo moves the branch from one commit to another, updating the index and
+// the worktree files that differ between them. Files with uncommitted
+// changes keep their local version, which the next cycle commits on top.
+func moveTo(repo *git.Repository, from, to *object.Commit) error {
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return fmt.Errorf("failed to get worktree: %w", err)
+	}
+	root := worktree.Filesystem.Root()
+
+	before, err := commitFiles(from)
+	if err != nil {
+		return err
+	}
+	after, err := commitFiles(to)
+	if err != nil {
+		return err
+	}
+
+	paths := changedPaths(before, after)
+	for _, name := range paths {
+		hash, exists, err := hashWorktreeFile(root, name)
+		if err != nil {
+			return err
+		}
+		old, inOld := before[name]
+		if exists != inOld || (exists && hash != old.Hash) {
+			opsLog.Warn("Keeping the local version of a file also changed on the remote", "path", name)
+			continue
+		}
+
+		target := filepath.Join(root, filepath.FromSlash(name))
+		entry, ok := after[name]
+		if !ok {
+			if err := os.Remove(target); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+			continue
+		}
+		if entry.Mode == filemode.Submodule || entry.Mode == filemode.Symlink {
+			continue
+		}
+
+		blob, err := repo.BlobObject(entry.Hash)
+		if err != nil {
+			return err
+		}
+		reader, err := blob.Reader()
+		if err != nil {
+			return err
+		}
+		contents, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			return err
+		}
+		perm := os.FileMode(0o644)
+		if entry.Mode == filemode.Executable {
+			perm = 0o755
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(target, contents, perm); err != nil {
+			return err
+		}
+	}
+
+	// Without files, a reset would reset the whole index: only the branch
+	// moves
+	if len(paths) == 0 {
+		head, err := repo.Head()
+		if err != nil {
+			return err
+		}
+		return repo.Storer.SetReference(plumbing.NewHashReference(head.Name(), to.Hash))
+	}
+
+	// Move HEAD's branch and update the index entries of the changed files
+	return worktree.Reset(&git.ResetOptions{Commit: to.Hash, Mode: git.MixedReset, Files: paths})
+}
+
+// reconcileRemote implements --pull: the remote branch is fetched and the
+// local branch fast-forwarded to it, or its new commits rebased on top of it,
+// so that the push that follows isn't rejected
+func reconcileRemote(repo *git.Repository) error {
+	head, err := repo.Head()
+	if err != nil {
+		return fmt.Errorf("failed to resolve HEAD: %w", err)
+	}
+	if !head.Name().IsBranch() {
+		return nil
+	}
+	branch := head.Name().Short()
+	tracking := plumbing.NewRemoteReferenceName(git.DefaultRemoteName, branch)
+
+	refSpec := config.RefSpec(fmt.Sprintf("+%s:%s", head.Name(), tracking))
+	err = withAuth(repo, func(auth transport.AuthMethod) error {
+		return repo.Fetch(&git.FetchOptions{Auth: auth, RefSpecs: []config.RefSpec{refSpec}})
+	})
+	switch {
+	case errors.Is(err, transport.ErrEmptyRemoteRepository), errors.Is(err, git.ErrRemoteRefNotFound):
+		// The push creates the branch
+		return nil
+	case err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate):
+		return fmt.Errorf("failed to fetch %s: %w", branch, err)
+	}
+
+	remoteRef, err := repo.Reference(tracking, true)
+	if err != nil {
+		return nil
+	}
+	if remoteRef.Hash() == head.Hash() {
+		return nil
+	}
+
+	local, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return err
+	}
+	remote, err := repo.CommitObject(remoteRef.Hash())
+	if err != nil {
+		return err
+	}
+
+	if ahead, err := remote.IsAncestor(local); err != nil || ahead {
+		return err
+	}
+
+	if behind, err := local.IsAncestor(remote); err != nil {
+		return err
+	} else if behind {
+		if err := moveTo(repo, local, remote); err != nil {
+			return fmt.Errorf("failed to fast-forward to %s: %w", remote.Hash.String()[:7], err)
+		}
+		eventLog.Info("✓ Fast-forwarded to the remote branch", "branch", branch, "hash", remote.Hash.String()[:7])
+		return nil
+	}
+
+	if pullFlag == "ff" {
+		return fmt.Errorf("%w: %s and origin/%s have both new commits, which --pull ff can't reconcile", errDiverged, branch, branch)
+	}
+
+	bases, err := local.MergeBase(remote)
+	if err != nil {
+		return err
+	}
+	if len(bases) == 0 {
+		return fmt.Errorf("%w: %s and origin/%s have no common history", errDiverged, branch, branch)
+	}
+
+	rebased, err := rebaseOnto(repo, bases[0], local, remote)
+	if err != nil {
+		return err
+	}
+	if err := moveTo(repo, local, rebased); err != nil {
+		return fmt.Errorf("failed to move to the rebased commits: %w", err)
+	}
+	eventLog.Info("✓ Rebased local commits on the remote branch", "branch", branch, "onto", remote.Hash.String()[:7], "hash", rebased.Hash.String()[:7])
+	return nil
+}
+
+// pullBeforePush runs reconcileRemote, notifying once when the remote
+// diverged in a way that needs a human
+func pullBeforePush(repo *git.Repository) error {
+	err := reconcileRemote(repo)
+	if errors.Is(err, errDiverged) {
+		opsLog.Error("Remote diverged and can't be reconciled automatically, resolve it by hand", "error", err)
+		if !current.diverged {
+			notify(notifyDiverged, err.Error())
+		}
+		current.diverged = true
+		return err
+	}
+	if err == nil && current.diverged {
+		opsLog.Info("Remote divergence resolved")
+		current.diverged = false
+	}
+	return err
+}
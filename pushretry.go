@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/go-git/go-git/v6"
+)
+
+// pushToRemote pushes the commits to the remote repository, retrying a push
+// that failed on a transient error up to --push-retries times, waiting
+// --push-backoff and then twice as long before each new attempt, up to
+// --interval. A shutdown cuts the wait short, leaving the push to the next
+// run.
+func pushToRemote(repo *git.Repository) error {
+	backoff := pushBackoffFlag
+	for attempt := 1; ; attempt++ {
+		err := pushOnce(repo)
+		if err == nil || attempt > pushRetriesFlag || !retryablePush(err) {
+			return err
+		}
+		backoff = min(backoff, intervalFlag)
+		opsLog.Warn("Push failed, retrying", "attempt", attempt, "retries", pushRetriesFlag, "backoff", backoff, "error", err)
+		select {
+		case <-time.After(backoff):
+		case <-shuttingDown:
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+// retryablePush reports whether a push error may go away by itself, like a
+// network error, unlike rejected credentials or a diverged remote
+func retryablePush(err error) bool {
+	return !isAuthError(err) &&
+		!errors.Is(err, errDiverged) &&
+		!errors.Is(err, git.ErrRemoteNotFound) &&
+		!errors.Is(err, git.ErrForceNeeded)
+}
+
+// probeAndRetry probes the remote and, when it answers after a failed push,
+// pushes the commits left behind right away instead of on the next cycle
+func probeAndRetry(w *watchedRepo) {
+	if !probeRemote(w.repo) || !pushFlag || current.pushFailures == 0 {
+		return
+	}
+
+	opsLog.Info("Remote is reachable again, retrying the failed push", "failures", current.pushFailures)
+	if pushesDeferred() {
+		pushPending(w)
+		return
+	}
+	err := pushToRemote(w.repo)
+	if err != nil {
+		opsLog.Error("Failed to push to remote", "error", err)
+	}
+	recordPushResult(err)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing/transport"
+)
+
+// remoteHealth is the outcome of the last remote probe
+type remoteHealth struct {
+	mu        sync.Mutex
+	Probed    time.Time
+	Reachable bool
+	Latency   time.Duration
+	Error     string
+}
+
+var remoteStatus remoteHealth
+
+// probeRemote lists the remote's references, like git ls-remote, recording
+// whether it is reachable and how long it took to answer. It returns whether
+// the remote answered.
+func probeRemote(repo *git.Repository) bool {
+	remote, err := repo.Remote(git.DefaultRemoteName)
+	if err != nil {
+		opsLog.Warn("Remote probe skipped, no remote configured", "error", err)
+		return false
+	}
+
+	err = withAuth(repo, func(auth transport.AuthMethod) error {
+		start := time.Now()
+		_, err := remote.List(&git.ListOptions{Auth: auth})
+		if errors.Is(err, transport.ErrEmptyRemoteRepository) {
+			err = nil
+		}
+
+		remoteStatus.mu.Lock()
+		remoteStatus.Latency = time.Since(start)
+		remoteStatus.mu.Unlock()
+		return err
+	})
+
+	remoteStatus.mu.Lock()
+	remoteStatus.Probed = time.Now()
+	remoteStatus.Reachable = err == nil
+	remoteStatus.Error = ""
+	if err != nil {
+		remoteStatus.Error = err.Error()
+	}
+	latency := remoteStatus.Latency
+	remoteStatus.mu.Unlock()
+	current.metrics.setReachable(err == nil)
+
+	if err != nil {
+		opsLog.Warn("Remote is unreachable", "remote", git.DefaultRemoteName, "error", err)
+		recordAuthProbe(err)
+		if hint := compatHint(remoteProvider(repo), err); hint != "" {
+			opsLog.Warn("Probe failed on a remote with known quirks", "provider", remoteProvider(repo), "hint", hint)
+		}
+	} else {
+		opsLog.Info("Remote is reachable", "remote", git.DefaultRemoteName, "latency", latency.Round(time.Millisecond))
+	}
+
+	checkTokenExpiry()
+	return err == nil
+}
+
+// recordAuthProbe warns when a probe failed because of credentials, which
+// would make every future push fail as well
+func recordAuthProbe(err error) {
+	if isAuthError(err) {
+		opsLog.Error("Remote rejected the configured credentials", "error", err)
+	}
+}
+
+// checkTokenExpiry warns when the HTTPS token's known expiry date is within
+// --token-expiry-warn, or already passed
+func checkTokenExpiry() {
+	if tokenExpiryFlag == "" {
+		return
+	}
+
+	expiry, err := time.Parse("2006-01-02", tokenExpiryFlag)
+	if err != nil {
+		opsLog.Warn("Invalid --token-expiry date, expected 2006-01-02", "token_expiry", tokenExpiryFlag)
+		return
+	}
+
+	left := time.Until(expiry)
+	switch {
+	case left <= 0:
+		opsLog.Error("HTTPS token has expired", "expiry", tokenExpiryFlag)
+	case left <= tokenExpiryWarnFlag:
+		opsLog.Warn("HTTPS token expires soon", "expiry", tokenExpiryFlag, "left", left.Round(time.Hour))
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"path"
+	"sort"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/format/index"
+)
+
+// detectRenames turns the deletion of a compose file and the creation of
+// another one with the same contents, a stack directory moved or renamed,
+// into a single renamed change committing both paths. Stack files of both
+// directories are committed along.
+func detectRenames(repo *git.Repository, repoPath string, changes []Change) []Change {
+	// Pair in path order, so the same moves always give the same pairs
+	sort.Slice(changes, func(i, j int) bool { return changes[i].FilePath < changes[j].FilePath })
+
+	created := map[plumbing.Hash]int{}
+	for i, change := range changes {
+		if change.ChangeType != Created || !isComposeFile(change.FilePath) {
+			continue
+		}
+		hash, ok, err := hashWorktreeFile(repoPath, change.FilePath)
+		if err != nil || !ok {
+			continue
+		}
+		if _, seen := created[hash]; !seen {
+			created[hash] = i
+		}
+	}
+	if len(created) == 0 {
+		return changes
+	}
+
+	merged := map[int]bool{}
+	for i, change := range changes {
+		if change.ChangeType != Deleted || !isComposeFile(change.FilePath) {
+			continue
+		}
+		hash, ok := committedHash(repo, change.FilePath)
+		if !ok {
+			continue
+		}
+		j, found := created[hash]
+		if !found {
+			continue
+		}
+		delete(created, hash)
+
+		changes[j].ChangeType = Renamed
+		changes[j].OldPath = change.FilePath
+		changes[j].Extras = append(changes[j].Extras, change.Extras...)
+		sort.Strings(changes[j].Extras)
+		changes[j].Included = append(changes[j].Included, change.Included...)
+		sort.Strings(changes[j].Included)
+		merged[i] = true
+		opsLog.Info("Detected stack rename", "from", change.FilePath, "to", changes[j].FilePath)
+	}
+
+	kept := changes[:0]
+	for i, change := range changes {
+		if !merged[i] {
+			kept = append(kept, change)
+		}
+	}
+	return kept
+}
+
+// committedHash returns the blob hash a deleted file had in the index or,
+// once its deletion is staged, in HEAD
+func committedHash(repo *git.Repository, filePath string) (plumbing.Hash, bool) {
+	if idx, err := repo.Storer.Index(); err == nil {
+		if entry, err := idx.Entry(filePath); err == nil {
+			return entry.Hash, true
+		}
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return plumbing.ZeroHash, false
+	}
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return plumbing.ZeroHash, false
+	}
+	file, err := commit.File(filePath)
+	if err != nil {
+		return plumbing.ZeroHash, false
+	}
+	return file.Hash, true
+}
+
+// removeOldPath stages the removal of a renamed stack's old compose file,
+// unless it's already staged, as after 'git mv'
+func removeOldPath(repo *git.Repository, worktree *git.Worktree, filePath string) error {
+	idx, err := repo.Storer.Index()
+	if err != nil {
+		return err
+	}
+	if _, err := idx.Entry(filePath); errors.Is(err, index.ErrEntryNotFound) {
+		return nil
+	}
+	_, err = worktree.Remove(filePath)
+	return err
+}
+
+// renameLabel describes a renamed stack in commit subjects: "foo -> bar", or
+// its directories when only its parent changed
+func renameLabel(change Change) string {
+	oldStack := getStackName(change.OldPath)
+	if oldStack != change.StackName {
+		return oldStack + " -> " + change.StackName
+	}
+	return path.Dir(change.OldPath) + " -> " + path.Dir(change.FilePath)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/utils/merkletrie"
+)
+
+var (
+	reportSinceFlag  string
+	reportUntilFlag  string
+	reportFormatFlag string
+)
+
+func reportFlags(fs *flag.FlagSet) {
+	fs.StringVar(&reportSinceFlag, "since", "720h", "Start of the report window, as a date (2006-01-02) or a duration ago (720h)")
+	fs.StringVar(&reportUntilFlag, "until", "", "End of the report window, as a date or a duration ago (default: now)")
+	fs.StringVar(&reportFormatFlag, "report-format", "json", "Report format ('json' or 'csv')")
+}
+
+// stackReport summarizes the changes of a stack over the report window
+type stackReport struct {
+	Stack      string      `json:"stack"`
+	Commits    int         `json:"commits"`
+	Created    int         `json:"created"`
+	Updated    int         `json:"updated"`
+	Deleted    int         `json:"deleted"`
+	ImageBumps []imageBump `json:"image_bumps"`
+	commits    map[string]bool
+}
+
+// imageBump is an image change along with the commit that made it
+type imageBump struct {
+	imageChange
+	Commit string    `json:"commit"`
+	Date   time.Time `json:"date"`
+}
+
+// parseTimeArg parses a date, a timestamp or a duration before now
+func parseTimeArg(value string) (time.Time, error) {
+	if duration, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-duration), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, expected a date (2006-01-02) or a duration (720h)", value)
+}
+
+// runReport prints per-stack change counts and image bumps over a window
+func runReport(repo *git.Repository, args []string) error {
+	since, err := parseTimeArg(reportSinceFlag)
+	if err != nil {
+		return err
+	}
+	until := time.Now()
+	if reportUntilFlag != "" {
+		if until, err = parseTimeArg(reportUntilFlag); err != nil {
+			return err
+		}
+	}
+	if reportFormatFlag != "json" && reportFormatFlag != "csv" {
+		return fmt.Errorf("invalid --report-format %q, expected 'json' or 'csv'", reportFormatFlag)
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		return fmt.Errorf("failed to resolve HEAD: %w", err)
+	}
+
+	commits, err := repo.Log(&git.LogOptions{From: head.Hash(), Since: &since, Until: &until})
+	if err != nil {
+		return err
+	}
+	defer commits.Close()
+
+	reports := map[string]*stackReport{}
+	for {
+		commit, err := commits.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			if historyTruncated(repo, err) {
+				break
+			}
+			return err
+		}
+
+		changes, err := commitChanges(commit)
+		if err != nil {
+			if historyTruncated(repo, err) {
+				break
+			}
+			return err
+		}
+
+		for _, change := range changes {
+			filePath := changeName(change)
+			if !isWatchedFile(filePath) {
+				continue
+			}
+
+			name := getStackName(filePath)
+			report, ok := reports[name]
+			if !ok {
+				report = &stackReport{Stack: name, ImageBumps: []imageBump{}, commits: map[string]bool{}}
+				reports[name] = report
+			}
+			report.commits[commit.Hash.String()] = true
+
+			action, err := change.Action()
+			if err != nil {
+				return err
+			}
+			switch action {
+			case merkletrie.Insert:
+				report.Created++
+			case merkletrie.Delete:
+				report.Deleted++
+			default:
+				report.Updated++
+			}
+
+			before, after, err := changeContents(change)
+			if err != nil {
+				return err
+			}
+			old, err1 := parseCompose(before)
+			cur, err2 := parseCompose(after)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			for _, image := range imageChanges(old, cur) {
+				report.ImageBumps = append(report.ImageBumps, imageBump{imageChange: image, Commit: commit.Hash.String()[:7], Date: commit.Author.When})
+			}
+		}
+	}
+
+	names := make([]string, 0, len(reports))
+	for name, report := range reports {
+		report.Commits = len(report.commits)
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if reportFormatFlag == "csv" {
+		w := csv.NewWriter(os.Stdout)
+		w.Write([]string{"stack", "commits", "created", "updated", "deleted", "image_bumps"})
+		for _, name := range names {
+			r := reports[name]
+			w.Write([]string{r.Stack, strconv.Itoa(r.Commits), strconv.Itoa(r.Created), strconv.Itoa(r.Updated), strconv.Itoa(r.Deleted), strconv.Itoa(len(r.ImageBumps))})
+		}
+		w.Flush()
+		return w.Error()
+	}
+
+	output := struct {
+		Since  time.Time      `json:"since"`
+		Until  time.Time      `json:"until"`
+		Stacks []*stackReport `json:"stacks"`
+	}{Since: since, Until: until, Stacks: []*stackReport{}}
+	for _, name := range names {
+		output.Stacks = append(output.Stacks, reports[name])
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(output)
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v6"
+)
+
+// stringList collects the values of an option given several times or as a
+// comma separated list, like --repo
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+// repoState is what the watcher remembers about a repository between cycles
+type repoState struct {
+	// lastIntegrityCheck is when the repository was last verified
+	lastIntegrityCheck time.Time
+
+	// repoCorrupted is set while the last integrity check failed, so that
+	// every cycle re-checks the repository until it is repaired
+	repoCorrupted bool
+
+	// pushFailures counts consecutive failed pushes
+	pushFailures int
+
+	// diverged is set while --pull can't reconcile the remote branch, so
+	// that it's notified once
+	diverged bool
+
+	// pushPending is set while commits wait for the next --push-interval
+	// tick
+	pushPending bool
+
+	// lastCycle is when the last cycle ended
+	lastCycle time.Time
+
+	// cycleCommits counts the commits the last cycle created
+	cycleCommits int
+
+	// metrics are served on --metrics-addr
+	metrics *repoMetrics
+
+	// digest accumulates the counts of the next --digest-interval digest
+	digest digestCounts
+
+	// stackFiles are the stack files as of the last cycle, whose events
+	// --watch relays like those of the compose files
+	stackFiles stackFiles
+}
+
+var (
+	// cycleMu serializes the work on every watched repository, which shares
+	// the options, credentials and logging of the process
+	cycleMu sync.Mutex
+
+	// current is the state of the repository being worked on
+	current = &repoState{}
+
+	// shuttingDown is closed once the watcher is stopping, so that work
+	// waiting under cycleMu gives up instead of delaying the shutdown
+	shuttingDown = make(chan struct{})
+)
+
+// watchedRepo is a repository with its own schedule and state
+type watchedRepo struct {
+	path     string
+	label    string
+	repo     *git.Repository
+	openedAt time.Time
+	state    repoState
+
+	// log is the operational log of the repository's own goroutines, such
+	// as its filesystem watcher, labelled like its cycles
+	log *slog.Logger
+
+	// watcher relays the filesystem events of the repository under --watch
+	watcher *stackWatcher
+
+	// snapshot is state as of the last cycle, for the status API
+	snapshotMu sync.Mutex
+	snapshot   repoSnapshot
+}
+
+// do runs fn with the repository's state, one repository at a time
+func (w *watchedRepo) do(fn func()) {
+	cycleMu.Lock()
+	defer cycleMu.Unlock()
+
+	busySince.Store(time.Now().UnixNano())
+	defer busySince.Store(0)
+
+	current = &w.state
+	defer func() { current = &repoState{} }()
+	defer enterScope(logScope{repo: w.label})()
+	fn()
+	if digestIntervalFlag > 0 {
+		saveDigest(w.repo, w.state.digest)
+	}
+	w.takeSnapshot()
+}
+
+// openWatchedRepo opens a repository and recovers it from an interrupted
+// cycle. With several repositories, their log records are labelled with
+// their path.
+func openWatchedRepo(path string, several bool) (*watchedRepo, error) {
+	repo, err := openRepository(path)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &watchedRepo{path: path, repo: repo, openedAt: time.Now()}
+	w.state.metrics = newRepoMetrics()
+	// Commits left unpushed by a previous run go out on the first tick
+	w.state.pushPending = pushesDeferred()
+	if several {
+		w.label = path
+	}
+	w.log = withScope(opsLog, logScope{repo: w.label})
+
+	w.do(func() {
+		if digestIntervalFlag > 0 {
+			current.digest = loadDigest(repo)
+		}
+
+		// Recover a cycle interrupted by a crash
+		if err := recoverJournal(repo); err != nil {
+			opsLog.Error("Failed to recover interrupted cycle", "error", err)
+		}
+
+		unshallowOnDemand(repo)
+
+		if isLinkedWorktree(repo) {
+			opsLog.Info("Watching a linked worktree, pushes are limited to its branch")
+		}
+		if kind := cloneKind(repo); kind != "" {
+			opsLog.Info("Repository is an incomplete clone, history commands stop at its boundary", "clone", kind)
+		}
+		if provider := remoteProvider(repo); provider != providerGeneric {
+			opsLog.Info("Remote compatibility mode", "provider", provider)
+		}
+	})
+
+	return w, nil
+}
+
+// check runs a cycle on the repository
+func (w *watchedRepo) check() {
+	w.do(func() {
+		defer bundleCycleErrors(recentLogs.errorCount())
+		errorsBefore, start := recentLogs.errorCount(), time.Now()
+		defer func() {
+			w.state.metrics.observeCycle(time.Since(start), recentLogs.errorCount() > errorsBefore)
+		}()
+
+		w.reopenIfDue()
+		if sourceFlag != "" {
+			if err := syncSource(sourceFlag, w.path); err != nil {
+				opsLog.Error("Failed to copy the source directory into the clone", "source", sourceFlag, "error", err)
+				return
+			}
+		}
+		checkAndCommit(w.repo, w.path)
+		if w.watcher != nil {
+			w.watcher.follow(w.state.stackFiles)
+		}
+		w.state.lastCycle = time.Now()
+		sdNotify("STATUS=" + cycleStatus(w))
+	})
+}
+
+// watch runs the repository's cycles on its own schedule, forever
+func (w *watchedRepo) watch() {
+	defer notifyPanic()
+
+	// Create a ticker that fires every --interval
+	ticker := time.NewTicker(intervalFlag)
+	defer ticker.Stop()
+
+	// Probe the remote on its own schedule
+	var probeTicks <-chan time.Time
+	if probeIntervalFlag > 0 {
+		probeTicker := time.NewTicker(probeIntervalFlag)
+		defer probeTicker.Stop()
+		probeTicks = probeTicker.C
+		w.do(func() { probeAndRetry(w) })
+	}
+
+	// Check as soon as compose files change, polling what can't be watched
+	var watchTriggers <-chan struct{}
+	var watchPolls <-chan time.Time
+	if watchFlag {
+		w.do(func() {
+			var err error
+			root := w.path
+			if sourceFlag != "" {
+				root = sourceFlag
+			}
+			w.watcher, err = newStackWatcher(root, w.log)
+			if err != nil {
+				opsLog.Warn("Filesystem notifications unavailable, falling back to polling", "error", err)
+				return
+			}
+			opsLog.Info("Watching compose files for changes")
+		})
+		if w.watcher != nil {
+			defer w.watcher.Close()
+			watchTriggers = w.watcher.triggers
+			pollTicker := time.NewTicker(watchPollFlag)
+			defer pollTicker.Stop()
+			watchPolls = pollTicker.C
+		}
+	}
+
+	// Push the commits of several cycles together, on their own schedule
+	var pushTicks <-chan time.Time
+	if pushesDeferred() {
+		pushTicker := time.NewTicker(pushTick())
+		defer pushTicker.Stop()
+		pushTicks = pushTicker.C
+	}
+
+	// Run immediately on startup
+	w.check()
+
+	for {
+		select {
+		case <-ticker.C:
+			// Ticker fired - check for changes and commit
+			w.check()
+		case <-watchTriggers:
+			w.check()
+		case <-watchPolls:
+			if w.watcher.polling() {
+				w.check()
+			}
+		case <-pushTicks:
+			w.do(func() { pushPending(w) })
+		case <-probeTicks:
+			w.do(func() { probeAndRetry(w) })
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/go-git/go-git/v6"
+)
+
+// stackTemplate holds the default files of a new stack
+//
+//go:embed all:templates/new
+var stackTemplate embed.FS
+
+var newTemplateFlag string
+
+func newFlags(fs *flag.FlagSet) {
+	fs.StringVar(&newTemplateFlag, "template", "", "Directory of the files of a new stack, rendered with {{.Stack}} (default: embedded compose.yml, .env and README.md)")
+}
+
+// templateFiles returns the files to scaffold new stacks from
+func templateFiles() (fs.FS, error) {
+	if newTemplateFlag != "" {
+		return os.DirFS(newTemplateFlag), nil
+	}
+	return fs.Sub(stackTemplate, "templates/new")
+}
+
+// validStackName rejects names that aren't a single directory of the
+// repository root
+func validStackName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.HasPrefix(name, ".") || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("invalid stack name %q", name)
+	}
+	return nil
+}
+
+// scaffoldStack renders the template files into the stack's directory and
+// returns their repository paths
+func scaffoldStack(root string, stack string) ([]string, error) {
+	files, err := templateFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	var written []string
+	err = fs.WalkDir(files, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		data, err := fs.ReadFile(files, name)
+		if err != nil {
+			return err
+		}
+		tmpl, err := template.New(name).Option("missingkey=error").Parse(string(data))
+		if err != nil {
+			return fmt.Errorf("invalid template %s: %w", name, err)
+		}
+		var out bytes.Buffer
+		if err := tmpl.Execute(&out, struct{ Stack string }{stack}); err != nil {
+			return fmt.Errorf("failed to render %s: %w", name, err)
+		}
+
+		target := filepath.Join(root, stack, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(target, out.Bytes(), 0o644); err != nil {
+			return err
+		}
+		written = append(written, path.Join(stack, name))
+		return nil
+	})
+	return written, err
+}
+
+// runNew scaffolds a stack from the template, commits it as created and
+// pushes it when --push is set
+func runNew(repo *git.Repository, args []string) error {
+	if len(args) != 1 {
+		return errors.New("expected a stack name")
+	}
+	stack := args[0]
+	if err := validStackName(stack); err != nil {
+		return err
+	}
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return fmt.Errorf("failed to get worktree: %w", err)
+	}
+	root := worktree.Filesystem.Root()
+	if _, err := os.Stat(filepath.Join(root, stack)); err == nil {
+		return fmt.Errorf("stack %s already exists", stack)
+	}
+
+	files, err := scaffoldStack(root, stack)
+	if err != nil {
+		os.RemoveAll(filepath.Join(root, stack))
+		return err
+	}
+
+	composePath := ""
+	for _, file := range files {
+		if isComposeFile(file) {
+			composePath = file
+		}
+		if _, err := worktree.Add(file); err != nil {
+			return fmt.Errorf("failed to add %s: %w", file, err)
+		}
+	}
+	if composePath == "" {
+		return fmt.Errorf("template has no compose.yml or compose.yaml, stack %s was scaffolded without committing", stack)
+	}
+
+	change := Change{StackName: stack, FilePath: composePath, ChangeType: Created, Class: ClassDeployment}
+	commit, err := worktree.Commit(withTrailers(commitSubject(change), ownTrailer(change)), commitOptions())
+	if err != nil {
+		return fmt.Errorf("failed to commit: %w", err)
+	}
+	fmt.Printf("Created stack %s (%s): %s\n", stack, commit.String()[:7], strings.Join(files, ", "))
+
+	if pushFlag {
+		return pushToRemote(repo)
+	}
+	return nil
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing"
+	format "github.com/go-git/go-git/v6/plumbing/format/config"
+	"github.com/go-git/go-git/v6/plumbing/format/gitignore"
+	"github.com/go-git/go-git/v6/plumbing/object"
+)
+
+// errScanLimit stops the worktree walk once --max-files files were visited
+var errScanLimit = errors.New("scan limit reached")
+
+// scanTuned reports whether any scan knob is set, in which case the targeted
+// scan replaces go-git's full worktree status
+func scanTuned() bool {
+	return scanDepthFlag > 0 || maxFilesFlag > 0 || len(skipDirs()) > 0
+}
+
+// skipDirs returns the directory names excluded by --skip-dirs
+func skipDirs() map[string]bool {
+	dirs := map[string]bool{}
+	for _, name := range strings.Split(skipDirsFlag, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			dirs[name] = true
+		}
+	}
+	return dirs
+}
+
+// inScanScope reports whether a repository path is within --scan-depth and
+// outside the skipped directories and the archive
+func inScanScope(filePath string, skipped map[string]bool) bool {
+	if isArchived(filePath) {
+		return false
+	}
+	dirs := strings.Split(path.Dir(filePath), "/")
+	if dirs[0] == "." {
+		dirs = nil
+	}
+	if scanDepthFlag > 0 && len(dirs) > scanDepthFlag {
+		return false
+	}
+	for _, dir := range dirs {
+		if skipped[dir] {
+			return false
+		}
+	}
+	return true
+}
+
+// worktreeStatus returns the status of the worktree, limited to compose files
+// within the scan limits when they are tuned
+func worktreeStatus(repo *git.Repository, worktree *git.Worktree) (git.Status, error) {
+	var status git.Status
+	var err error
+	if scanTuned() {
+		status, err = scanStatus(repo, worktree)
+	} else {
+		status, err = worktree.Status()
+	}
+	if err != nil {
+		return nil, err
+	}
+	// The metadata is committed with the stacks, never as a change
+	for filePath := range status {
+		if isMetadataPath(filePath) {
+			delete(status, filePath)
+		}
+	}
+	return status, nil
+}
+
+// logStatus dumps every entry of the worktree status at debug level, with
+// its staging and worktree codes as in 'git status --porcelain'
+func logStatus(status git.Status) {
+	if !opsLog.Enabled(context.Background(), slog.LevelDebug) {
+		return
+	}
+	opsLog.Debug("Worktree status", "entries", len(status))
+	paths := make([]string, 0, len(status))
+	for path := range status {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+	for _, path := range paths {
+		file := status[path]
+		opsLog.Debug("Worktree status entry", "path", path, "status", fmt.Sprintf("%c%c", file.Staging, file.Worktree))
+	}
+}
+
+// scanStatus computes the status of compose files found by walking the
+// worktree within --scan-depth, --max-files and --skip-dirs, plus those known
+// to HEAD or the index, without hashing the rest of the tree. The stack files
+// of the compose files found are kept too, included files even outside of
+// the limits.
+func scanStatus(repo *git.Repository, worktree *git.Worktree) (git.Status, error) {
+	root := worktree.Filesystem.Root()
+	skipped := skipDirs()
+
+	idx, err := repo.Storer.Index()
+	if err != nil {
+		return nil, err
+	}
+
+	indexed := map[string]plumbing.Hash{}
+	for _, entry := range idx.Entries {
+		indexed[entry.Name] = entry.Hash
+	}
+
+	committed := map[string]plumbing.Hash{}
+	if head, err := repo.Head(); err == nil {
+		commit, err := repo.CommitObject(head.Hash())
+		if err != nil {
+			return nil, err
+		}
+		files, err := commit.Files()
+		if err != nil {
+			return nil, err
+		}
+		err = files.ForEach(func(f *object.File) error {
+			committed[f.Name] = f.Hash
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	patterns, _ := gitignore.ReadPatterns(worktree.Filesystem, nil)
+	ignore := gitignore.NewMatcher(append(patterns, worktree.Excludes...))
+
+	found := map[string]bool{}
+	visited := 0
+	err = filepath.WalkDir(root, func(osPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+
+		rel, _ := filepath.Rel(root, osPath)
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			return nil
+		}
+
+		if d.IsDir() {
+			depth := strings.Count(rel, "/") + 1
+			if d.Name() == ".git" || skipped[d.Name()] || rel == archiveDirFlag || (scanDepthFlag > 0 && depth > scanDepthFlag) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		visited++
+		if maxFilesFlag > 0 && visited > maxFilesFlag {
+			opsLog.Warn("Scan stopped at --max-files limit, some stacks may be missed", "max_files", maxFilesFlag)
+			return errScanLimit
+		}
+
+		found[rel] = true
+		return nil
+	})
+	if err != nil && !errors.Is(err, errScanLimit) {
+		return nil, err
+	}
+
+	// The stack files are known once the compose files are
+	dirs := map[string]string{}
+	for name := range indexed {
+		if isComposeFile(name) && inScanScope(name, skipped) {
+			dirs[path.Dir(name)] = name
+		}
+	}
+	for name := range found {
+		if isComposeFile(name) {
+			dirs[path.Dir(name)] = name
+		}
+	}
+	files := newStackFiles(root, dirs)
+
+	candidates := map[string]bool{}
+	for name := range found {
+		candidates[name] = true
+	}
+	for name := range indexed {
+		candidates[name] = true
+	}
+	for name := range committed {
+		candidates[name] = true
+	}
+	for name := range candidates {
+		if !inScanScope(name, skipped) || !isWatchedFile(name) && !files.contains(name) {
+			delete(candidates, name)
+		}
+	}
+	for name := range files.included {
+		candidates[name] = true
+	}
+
+	status := git.Status{}
+	for name := range candidates {
+		headHash, inHead := committed[name]
+		indexHash, inIndex := indexed[name]
+
+		fileStatus := &git.FileStatus{Staging: git.Unmodified, Worktree: git.Unmodified}
+		switch {
+		case !inHead && inIndex:
+			fileStatus.Staging = git.Added
+		case inHead && !inIndex:
+			fileStatus.Staging = git.Deleted
+		case inHead && !headHash.Equal(indexHash):
+			fileStatus.Staging = git.Modified
+		}
+
+		// Untracked files, such as the clutter of a stack, are never read
+		var worktreeHash plumbing.Hash
+		var exists bool
+		if inIndex {
+			worktreeHash, exists, err = hashWorktreeFile(root, name)
+		} else {
+			exists, err = worktreeFileExists(root, name)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case inIndex && !exists:
+			fileStatus.Worktree = git.Deleted
+		case !inIndex && exists:
+			if ignore.Match(strings.Split(name, "/"), false) {
+				break
+			}
+			// A file staged as deleted keeps its staging status, like git
+			fileStatus.Worktree = git.Untracked
+			if !inHead {
+				fileStatus.Staging = git.Untracked
+			}
+		case inIndex && !worktreeHash.Equal(indexHash):
+			fileStatus.Worktree = git.Modified
+		}
+
+		if fileStatus.Staging != git.Unmodified || fileStatus.Worktree != git.Unmodified {
+			status[name] = fileStatus
+		}
+	}
+
+	return status, nil
+}
+
+// worktreeFileExists reports whether a worktree file exists, without
+// following symlinks. A directory isn't a file.
+func worktreeFileExists(root string, name string) (bool, error) {
+	info, err := os.Lstat(filepath.Join(root, filepath.FromSlash(name)))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return !info.IsDir(), nil
+}
+
+// hashWorktreeFile computes the blob hash of a worktree file, or of the link
+// target for symlinks unless --symlinks follow reads through them, reporting
+// whether the file exists
+func hashWorktreeFile(root string, name string) (plumbing.Hash, bool, error) {
+	osPath := filepath.Join(root, filepath.FromSlash(name))
+
+	info, err := os.Lstat(osPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return plumbing.ZeroHash, false, nil
+	}
+	if err != nil {
+		return plumbing.ZeroHash, false, err
+	}
+
+	var data []byte
+	if info.Mode()&os.ModeSymlink != 0 && !followsSymlink(name) {
+		target, err := os.Readlink(osPath)
+		if err != nil {
+			return plumbing.ZeroHash, false, err
+		}
+		data = []byte(target)
+	} else {
+		data, err = os.ReadFile(osPath)
+		if err != nil {
+			return plumbing.ZeroHash, false, err
+		}
+	}
+
+	hasher := plumbing.NewHasher(format.DefaultObjectFormat, plumbing.BlobObject, int64(len(data)))
+	hasher.Write(data)
+	return hasher.Sum(), true, nil
+}
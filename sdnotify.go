@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// busySince is when the work holding cycleMu started, in Unix nanoseconds, or
+// 0 while idle. The systemd watchdog stops being pinged when it's too old.
+var busySince atomic.Int64
+
+// sdNotify sends a state such as "READY=1" to systemd when the watcher runs
+// as a Type=notify unit, and does nothing otherwise
+func sdNotify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+
+	// A leading @ is an abstract socket, which net handles on its own
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		opsLog.Debug("Failed to notify systemd", "socket", socket, "error", err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		opsLog.Debug("Failed to notify systemd", "socket", socket, "error", err)
+	}
+}
+
+// watchdogTimeout returns the WatchdogSec= of the unit, or 0 when systemd
+// doesn't watch this process
+func watchdogTimeout() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// watchdog pings the systemd watchdog twice per timeout, as long as no cycle,
+// probe or push has been running for longer than the timeout. systemd then
+// restarts a watcher whose work hangs, e.g. on a remote that never answers.
+func watchdog(timeout time.Duration) {
+	defer notifyPanic()
+
+	ticker := time.NewTicker(timeout / 2)
+	defer ticker.Stop()
+
+	stuck := false
+	for range ticker.C {
+		since := busySince.Load()
+		if since != 0 && time.Since(time.Unix(0, since)) > timeout {
+			if !stuck {
+				processLog.Error("Cycle hangs, no longer pinging the systemd watchdog", "since", time.Unix(0, since).Format(time.RFC3339), "watchdog", timeout)
+				stuck = true
+			}
+			continue
+		}
+		stuck = false
+		sdNotify("WATCHDOG=1")
+	}
+}
+
+// cycleStatus describes the last cycle of a repository for systemctl status
+func cycleStatus(w *watchedRepo) string {
+	status := fmt.Sprintf("Last cycle at %s: %d commit(s)", w.state.lastCycle.Format("15:04:05"), w.state.cycleCommits)
+	switch {
+	case w.state.diverged:
+		status += ", diverged from the remote"
+	case w.state.pushPending:
+		status += ", push pending"
+	case w.state.pushFailures > 0:
+		status += fmt.Sprintf(", %d failed push(es)", w.state.pushFailures)
+	}
+	if w.label != "" {
+		status = w.label + ": " + status
+	}
+	return status
+}
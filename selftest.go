@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/go-git/go-billy/v6/osfs"
+	"github.com/go-git/go-git/v6"
+	githttp "github.com/go-git/go-git/v6/backend/http"
+	"github.com/go-git/go-git/v6/config"
+	"github.com/go-git/go-git/v6/plumbing/transport"
+)
+
+// selftestStack is the stack created by the self-test
+const selftestStack = "selftest"
+
+// selftestCompose is the compose file of the self-test stack
+const selftestCompose = "services:\n  hello:\n    image: hello-world\n"
+
+// selftestStep runs one step of the self-test and prints its outcome
+func selftestStep(name string, fn func() error) error {
+	if err := fn(); err != nil {
+		fmt.Printf("✗ %s: %v\n", name, err)
+		return fmt.Errorf("%s failed: %w", name, err)
+	}
+	fmt.Printf("✓ %s\n", name)
+	return nil
+}
+
+// serveBareRepository serves the repositories under dir over HTTP on a
+// loopback port, like a git forge would, and returns its base URL
+func serveBareRepository(dir string) (string, func(), error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, err
+	}
+
+	server := &http.Server{Handler: githttp.NewBackend(transport.NewFilesystemLoader(osfs.New(dir), false))}
+	go server.Serve(listener)
+
+	return "http://" + listener.Addr().String(), func() { server.Close() }, nil
+}
+
+// runSelftest runs a full cycle, detect, commit, push and verify, in a
+// temporary repository whose remote is served in-process, so the pipeline
+// and the options can be checked on a host without touching real remotes.
+// The remote needs no credentials, so --auth isn't exercised.
+func runSelftest(_ *git.Repository, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("expected no arguments, got %d", len(args))
+	}
+	authMethodFlag, proxyUserFlag = "none", ""
+
+	dir, err := os.MkdirTemp("", "git-stack-watch-selftest-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		repo     *git.Repository
+		worktree *git.Worktree
+		remote   *git.Repository
+		changes  []Change
+		stop     func()
+	)
+	defer func() {
+		if stop != nil {
+			stop()
+		}
+	}()
+	root := filepath.Join(dir, "work")
+
+	steps := []struct {
+		name string
+		fn   func() error
+	}{
+		{"Serve a throwaway remote", func() error {
+			var err error
+			remote, err = git.PlainInit(filepath.Join(dir, "remote.git"), true)
+			if err != nil {
+				return err
+			}
+			var url string
+			url, stop, err = serveBareRepository(dir)
+			if err != nil {
+				return err
+			}
+
+			repo, err = git.PlainInit(root, false)
+			if err != nil {
+				return err
+			}
+			_, err = repo.CreateRemote(&config.RemoteConfig{Name: git.DefaultRemoteName, URLs: []string{url + "/remote.git"}})
+			return err
+		}},
+		{"Detect a new stack", func() error {
+			if err := os.MkdirAll(filepath.Join(root, selftestStack), 0o755); err != nil {
+				return err
+			}
+			if err := os.WriteFile(filepath.Join(root, selftestStack, "compose.yml"), []byte(selftestCompose), 0o644); err != nil {
+				return err
+			}
+
+			var err error
+			worktree, err = repo.Worktree()
+			if err != nil {
+				return err
+			}
+			status, err := worktreeStatus(repo, worktree)
+			if err != nil {
+				return err
+			}
+			changes = detectChanges(repo, root, status)
+			if len(changes) != 1 || changes[0].StackName != selftestStack || changes[0].ChangeType != Created {
+				return fmt.Errorf("expected the creation of stack %s, detected %v", selftestStack, changes)
+			}
+			return nil
+		}},
+		{"Commit the stack", func() error {
+			err := commitStackChange(worktree, repo, changes[0])
+			if errors.Is(err, git.ErrMissingAuthor) {
+				return fmt.Errorf("%w, set --author-name and --author-email or the global user.name and user.email", err)
+			}
+			return err
+		}},
+		{"Push to the remote", func() error {
+			return pushToRemote(repo)
+		}},
+		{"Verify the remote", func() error {
+			head, err := repo.Head()
+			if err != nil {
+				return err
+			}
+			pushed, err := remote.Reference(head.Name(), true)
+			if err != nil {
+				return fmt.Errorf("pushed branch not found: %w", err)
+			}
+			if pushed.Hash() != head.Hash() {
+				return fmt.Errorf("remote is at %s, expected %s", pushed.Hash().String()[:7], head.Hash().String()[:7])
+			}
+			commit, err := remote.CommitObject(pushed.Hash())
+			if err != nil {
+				return err
+			}
+			file, err := commit.File(selftestStack + "/compose.yml")
+			if err != nil {
+				return err
+			}
+			contents, err := file.Contents()
+			if err != nil {
+				return err
+			}
+			if contents != selftestCompose {
+				return errors.New("pushed compose file differs from the committed one")
+			}
+			return nil
+		}},
+	}
+
+	for _, step := range steps {
+		if err := selftestStep(step.name, step.fn); err != nil {
+			return err
+		}
+	}
+	fmt.Println("Self-test passed")
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/transport"
+)
+
+// infiniteDepth is the depth git itself requests for 'fetch --unshallow'
+const infiniteDepth = 0x7fffffff
+
+// cloneKind describes a repository that lacks part of its history: 'shallow'
+// when commits were cut at a depth, 'partial' when objects were filtered out,
+// and empty for a full clone
+func cloneKind(repo *git.Repository) string {
+	if shallows, _ := repo.Storer.Shallow(); len(shallows) > 0 {
+		return "shallow"
+	}
+
+	cfg, err := repo.Config()
+	if err != nil {
+		return ""
+	}
+	if cfg.Raw.Section("extensions").Option("partialClone") != "" {
+		return "partial"
+	}
+	for _, remote := range cfg.Raw.Section("remote").Subsections {
+		if remote.Option("promisor") == "true" {
+			return "partial"
+		}
+	}
+	return ""
+}
+
+// historyTruncated reports whether a history walk failed on an object left
+// out of a shallow or partial clone, warning that older commits are missing.
+// go-git can't fetch those objects on demand, so walks stop there.
+func historyTruncated(repo *git.Repository, err error) bool {
+	if !errors.Is(err, plumbing.ErrObjectNotFound) {
+		return false
+	}
+
+	kind := cloneKind(repo)
+	if kind == "" {
+		return false
+	}
+
+	opsLog.Warn("History is truncated by the clone, older commits are not shown", "clone", kind)
+	return true
+}
+
+// unshallow fetches the history missing from a shallow clone, then drops the
+// commits whose parents are now present from the shallow list, which go-git
+// doesn't update when deepening
+func unshallow(repo *git.Repository) error {
+	err := withAuth(repo, func(auth transport.AuthMethod) error {
+		return repo.Fetch(&git.FetchOptions{Auth: auth, Depth: infiniteDepth})
+	})
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return fmt.Errorf("failed to fetch history: %w", err)
+	}
+
+	shallows, err := repo.Storer.Shallow()
+	if err != nil {
+		return err
+	}
+
+	var remaining []plumbing.Hash
+	for _, hash := range shallows {
+		commit, err := repo.CommitObject(hash)
+		if err != nil {
+			remaining = append(remaining, hash)
+			continue
+		}
+		for _, parent := range commit.ParentHashes {
+			if _, err := repo.CommitObject(parent); err != nil {
+				remaining = append(remaining, hash)
+				break
+			}
+		}
+	}
+
+	if err := repo.Storer.SetShallow(remaining); err != nil {
+		return fmt.Errorf("failed to update shallow commits: %w", err)
+	}
+	if len(remaining) > 0 {
+		return fmt.Errorf("remote didn't send the parents of %d shallow commits", len(remaining))
+	}
+	return nil
+}
+
+// unshallowOnDemand completes a shallow clone when --unshallow is set
+func unshallowOnDemand(repo *git.Repository) {
+	if !unshallowFlag || cloneKind(repo) != "shallow" {
+		return
+	}
+
+	if err := unshallow(repo); err != nil {
+		opsLog.Error("Failed to unshallow repository", "error", err)
+		return
+	}
+	opsLog.Info("Fetched the full history of the shallow clone")
+}
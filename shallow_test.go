@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/config"
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/object"
+)
+
+// initTestRepo creates a repository in a temporary directory with a single
+// commit of a compose file, returning it with the commit's hash
+func initTestRepo(t *testing.T) (*git.Repository, plumbing.Hash) {
+	t.Helper()
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "compose.yml"), []byte("services: {}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := worktree.Add("compose.yml"); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := worktree.Commit("init", &git.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repo, hash
+}
+
+func TestCloneKind(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, repo *git.Repository, head plumbing.Hash)
+		want  string
+	}{
+		{
+			name:  "full",
+			setup: func(t *testing.T, repo *git.Repository, head plumbing.Hash) {},
+			want:  "",
+		},
+		{
+			name: "shallow",
+			setup: func(t *testing.T, repo *git.Repository, head plumbing.Hash) {
+				setShallow(t, repo, head)
+			},
+			want: "shallow",
+		},
+		{
+			name: "partial clone extension",
+			setup: func(t *testing.T, repo *git.Repository, head plumbing.Hash) {
+				setRawOption(t, repo, func(cfg *config.Config) {
+					cfg.Raw.Section("extensions").SetOption("partialClone", "origin")
+				})
+			},
+			want: "partial",
+		},
+		{
+			name: "promisor remote",
+			setup: func(t *testing.T, repo *git.Repository, head plumbing.Hash) {
+				_, err := repo.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{"https://example.com/stacks.git"}})
+				if err != nil {
+					t.Fatal(err)
+				}
+				setRawOption(t, repo, func(cfg *config.Config) {
+					cfg.Raw.Section("remote").Subsection("origin").SetOption("promisor", "true")
+				})
+			},
+			want: "partial",
+		},
+		{
+			name: "shallow and partial",
+			setup: func(t *testing.T, repo *git.Repository, head plumbing.Hash) {
+				setShallow(t, repo, head)
+				setRawOption(t, repo, func(cfg *config.Config) {
+					cfg.Raw.Section("extensions").SetOption("partialClone", "origin")
+				})
+			},
+			want: "shallow",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, head := initTestRepo(t)
+			tt.setup(t, repo, head)
+			if got := cloneKind(repo); got != tt.want {
+				t.Errorf("cloneKind() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHistoryTruncated(t *testing.T) {
+	missing := fmt.Errorf("failed to walk history: %w", plumbing.ErrObjectNotFound)
+	tests := []struct {
+		name    string
+		shallow bool
+		err     error
+		want    bool
+	}{
+		{"full clone, missing object", false, missing, false},
+		{"shallow clone, missing object", true, missing, true},
+		{"shallow clone, other error", true, errors.New("permission denied"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, head := initTestRepo(t)
+			if tt.shallow {
+				setShallow(t, repo, head)
+			}
+			if got := historyTruncated(repo, tt.err); got != tt.want {
+				t.Errorf("historyTruncated() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func setShallow(t *testing.T, repo *git.Repository, hashes ...plumbing.Hash) {
+	t.Helper()
+	if err := repo.Storer.SetShallow(hashes); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func setRawOption(t *testing.T, repo *git.Repository, set func(cfg *config.Config)) {
+	t.Helper()
+	cfg, err := repo.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	set(cfg)
+	if err := repo.SetConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/object"
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	// signKey is the decrypted --gpg-key entity signing the watcher's commits
+	signKey *openpgp.Entity
+	// commitSigner signs the watcher's commits through gpg-agent or with an
+	// SSH key, taking precedence over signKey
+	commitSigner git.Signer
+)
+
+// gpgAgentSigner signs commits with the gpg binary, which gets the key and its
+// passphrase from gpg-agent
+type gpgAgentSigner struct {
+	keyID string
+}
+
+func (s gpgAgentSigner) Sign(message io.Reader) ([]byte, error) {
+	cmd := exec.Command("gpg", "--batch", "--no-tty", "--armor", "--detach-sign", "--local-user", s.keyID)
+	cmd.Stdin = message
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	signature, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("gpg failed to sign with %s: %w: %s", s.keyID, err, strings.TrimSpace(stderr.String()))
+	}
+	return signature, nil
+}
+
+// sshSigner signs commits in git's SSH signature format (SSHSIG, "gpg.format
+// ssh"), which GitHub and GitLab verify against the user's signing keys
+type sshSigner struct {
+	signer ssh.Signer
+}
+
+func (s sshSigner) Sign(message io.Reader) ([]byte, error) {
+	const namespace, hashAlgorithm = "git", "sha512"
+
+	hash := sha512.New()
+	if _, err := io.Copy(hash, message); err != nil {
+		return nil, err
+	}
+
+	signed := append([]byte("SSHSIG"), ssh.Marshal(struct {
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Hash          string
+	}{namespace, "", hashAlgorithm, string(hash.Sum(nil))})...)
+
+	var signature *ssh.Signature
+	var err error
+	if algorithmSigner, ok := s.signer.(ssh.AlgorithmSigner); ok && s.signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+		// ssh-rsa signatures use SHA-1, which verifiers reject
+		signature, err = algorithmSigner.SignWithAlgorithm(rand.Reader, signed, ssh.KeyAlgoRSASHA512)
+	} else {
+		signature, err = s.signer.Sign(rand.Reader, signed)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign with the SSH key: %w", err)
+	}
+
+	blob := append([]byte("SSHSIG"), ssh.Marshal(struct {
+		Version       uint32
+		PublicKey     string
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Signature     string
+	}{1, string(s.signer.PublicKey().Marshal()), namespace, "", hashAlgorithm, string(ssh.Marshal(signature))})...)
+
+	encoded := base64.StdEncoding.EncodeToString(blob)
+	var armored strings.Builder
+	armored.WriteString("-----BEGIN SSH SIGNATURE-----\n")
+	for len(encoded) > 70 {
+		armored.WriteString(encoded[:70] + "\n")
+		encoded = encoded[70:]
+	}
+	armored.WriteString(encoded + "\n-----END SSH SIGNATURE-----\n")
+	return []byte(armored.String()), nil
+}
+
+// sshSignKeyPath returns the key signing commits with --ssh-sign:
+// --ssh-sign-key, or the key used to push
+func sshSignKeyPath() string {
+	if sshSignKeyFlag != "" {
+		return sshSignKeyFlag
+	}
+	return sshKeyPath()
+}
+
+// readSignKey reads an armored or binary OpenPGP private key and decrypts it
+// with the passphrase, if it's protected
+func readSignKey(path string, passphrase string) (*openpgp.Entity, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	if err != nil {
+		entities, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("not an OpenPGP key: %w", err)
+	}
+
+	entity := entities[0]
+	if entity.PrivateKey == nil {
+		return nil, errors.New("the file holds a public key, a private key is required")
+	}
+	if entity.PrivateKey.Encrypted {
+		if passphrase == "" {
+			return nil, errors.New("the key is protected, set --gpg-passphrase or GPG_PASSPHRASE")
+		}
+		if err := entity.DecryptPrivateKeys([]byte(passphrase)); err != nil {
+			return nil, fmt.Errorf("failed to decrypt the key: %w", err)
+		}
+	}
+	return entity, nil
+}
+
+// setupSigning loads the key signing the watcher's commits, from --gpg-key,
+// through gpg-agent with --gpg-agent-key, or an SSH key with --ssh-sign
+func setupSigning() error {
+	sshSign := sshSignFlag || sshSignKeyFlag != ""
+
+	switch {
+	case gpgKeyFlag != "" && gpgAgentKeyFlag != "":
+		return errors.New("--gpg-key and --gpg-agent-key can't be used together")
+	case sshSign && (gpgKeyFlag != "" || gpgAgentKeyFlag != ""):
+		return errors.New("commits can't be signed with both a GPG and an SSH key")
+	case sshSign:
+		path := sshSignKeyPath()
+		signer, err := readSSHKey(path)
+		if err != nil {
+			return err
+		}
+		commitSigner = sshSigner{signer: signer}
+		opsLog.Info("Signing commits with SSH key", "path", path, "fingerprint", ssh.FingerprintSHA256(signer.PublicKey()))
+	case gpgKeyFlag != "":
+		entity, err := readSignKey(gpgKeyFlag, secretValue(gpgPassphraseFlag, "GPG_PASSPHRASE"))
+		if err != nil {
+			return fmt.Errorf("%s: %w", gpgKeyFlag, err)
+		}
+		signKey = entity
+		opsLog.Info("Signing commits with GPG key", "key", entity.PrimaryKey.KeyIdString())
+	case gpgAgentKeyFlag != "":
+		if _, err := exec.LookPath("gpg"); err != nil {
+			return fmt.Errorf("--gpg-agent-key needs the gpg binary: %w", err)
+		}
+		commitSigner = gpgAgentSigner{keyID: gpgAgentKeyFlag}
+		opsLog.Info("Signing commits with gpg-agent", "key", gpgAgentKeyFlag)
+	}
+	return nil
+}
+
+// signCommitObject signs a commit built by hand, like rebased commits, with
+// the key set up for the watcher's commits, if any
+func signCommitObject(commit *object.Commit) error {
+	if commitSigner == nil && signKey == nil {
+		return nil
+	}
+
+	encoded := &plumbing.MemoryObject{}
+	if err := commit.EncodeWithoutSignature(encoded); err != nil {
+		return err
+	}
+	reader, err := encoded.Reader()
+	if err != nil {
+		return err
+	}
+
+	if commitSigner != nil {
+		signature, err := commitSigner.Sign(reader)
+		if err != nil {
+			return err
+		}
+		commit.PGPSignature = string(signature)
+		return nil
+	}
+
+	var signature bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&signature, signKey, reader, nil); err != nil {
+		return err
+	}
+	commit.PGPSignature = signature.String()
+	return nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bluekeyes/go-gitdiff/gitdiff"
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/config"
+)
+
+var simulatePatchFlag string
+
+func simulateFlags(fs *flag.FlagSet) {
+	fs.StringVar(&simulatePatchFlag, "patch", "", "Unified diff to simulate, as written by 'git diff' ('-' for stdin)")
+}
+
+// readPatch returns the contents of --patch
+func readPatch() ([]byte, error) {
+	if simulatePatchFlag == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(simulatePatchFlag)
+}
+
+// applyPatch applies a unified diff to the worktree rooted at root
+func applyPatch(root string, patch []byte) error {
+	files, _, err := gitdiff.Parse(bytes.NewReader(patch))
+	if err != nil {
+		return fmt.Errorf("failed to parse patch: %w", err)
+	}
+	if len(files) == 0 {
+		return errors.New("patch changes no file")
+	}
+
+	for _, file := range files {
+		oldPath := filepath.Join(root, filepath.FromSlash(file.OldName))
+		newPath := filepath.Join(root, filepath.FromSlash(file.NewName))
+
+		var src []byte
+		if !file.IsNew {
+			src, err = os.ReadFile(oldPath)
+			if err != nil {
+				return fmt.Errorf("%s: %w", file.OldName, err)
+			}
+		}
+
+		if file.IsDelete {
+			if err := os.Remove(oldPath); err != nil {
+				return fmt.Errorf("%s: %w", file.OldName, err)
+			}
+			continue
+		}
+
+		var dst bytes.Buffer
+		if err := gitdiff.Apply(&dst, bytes.NewReader(src), file); err != nil {
+			return fmt.Errorf("%s: %w", file.NewName, err)
+		}
+
+		perm := os.FileMode(0o644)
+		if file.NewMode&0o111 != 0 {
+			perm = 0o755
+		}
+		if err := os.MkdirAll(filepath.Dir(newPath), 0o755); err != nil {
+			return err
+		}
+		if file.IsRename {
+			if err := os.Remove(oldPath); err != nil {
+				return fmt.Errorf("%s: %w", file.OldName, err)
+			}
+		}
+		if err := os.WriteFile(newPath, dst.Bytes(), perm); err != nil {
+			return err
+		}
+		if err := os.Chmod(newPath, perm); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// cloneForSimulation clones HEAD of the repository into a temporary
+// directory, with its commit identity, and returns the clone and its root
+func cloneForSimulation(repo *git.Repository) (*git.Repository, string, error) {
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get worktree: %w", err)
+	}
+
+	dir, err := os.MkdirTemp("", "git-stack-watch-simulate-")
+	if err != nil {
+		return nil, "", err
+	}
+
+	clone, err := git.PlainClone(dir, &git.CloneOptions{URL: worktree.Filesystem.Root(), SingleBranch: true})
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, "", fmt.Errorf("failed to clone repository: %w", err)
+	}
+
+	// Commits of the clone use the identity of the repository
+	if source, err := repo.ConfigScoped(config.GlobalScope); err == nil {
+		cfg, err := clone.Config()
+		if err == nil {
+			cfg.User, cfg.Author, cfg.Committer = source.User, source.Author, source.Committer
+			err = clone.SetConfig(cfg)
+		}
+		if err != nil {
+			os.RemoveAll(dir)
+			return nil, "", fmt.Errorf("failed to configure clone: %w", err)
+		}
+	}
+
+	return clone, dir, nil
+}
+
+// runSimulate applies a patch to a throwaway copy of HEAD and runs the
+// cycle on it, reporting how each change would be classified, grouped,
+// described and gated. It fails when a change would be held or fail, so it
+// can gate CI.
+func runSimulate(repo *git.Repository, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("expected no arguments, got %d", len(args))
+	}
+	if simulatePatchFlag == "" {
+		return errors.New("--patch is required")
+	}
+
+	patch, err := readPatch()
+	if err != nil {
+		return fmt.Errorf("failed to read patch: %w", err)
+	}
+
+	clone, root, err := cloneForSimulation(repo)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(root)
+
+	if err := applyPatch(root, patch); err != nil {
+		return fmt.Errorf("failed to apply patch: %w", err)
+	}
+
+	worktree, err := clone.Worktree()
+	if err != nil {
+		return fmt.Errorf("failed to get worktree: %w", err)
+	}
+	status, err := worktree.Status()
+	if err != nil {
+		return fmt.Errorf("failed to get status: %w", err)
+	}
+
+	changes := detectChanges(clone, root, status)
+	if len(changes) == 0 {
+		fmt.Println("No stack change would be committed")
+		return nil
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].FilePath < changes[j].FilePath
+	})
+
+	blocked := 0
+	for _, change := range changes {
+		fmt.Printf("%s: %s %s (%s)\n", change.StackName, change.ChangeType, change.FilePath, change.Class)
+		for _, extra := range change.Extras {
+			fmt.Printf("  with %s\n", extra)
+		}
+
+		err := commitStackChange(worktree, clone, change)
+		switch {
+		case errors.Is(err, errCommitHeld):
+			blocked++
+			fmt.Printf("  held: %v\n", err)
+			continue
+		case err != nil:
+			blocked++
+			fmt.Printf("  failed: %v\n", err)
+			continue
+		}
+
+		head, err := clone.Head()
+		if err != nil {
+			return err
+		}
+		commit, err := clone.CommitObject(head.Hash())
+		if err != nil {
+			return err
+		}
+		fmt.Println("  commit:")
+		for _, line := range strings.Split(strings.TrimRight(commit.Message, "\n"), "\n") {
+			fmt.Println("    " + line)
+		}
+	}
+
+	if blocked > 0 {
+		return fmt.Errorf("%d of %d changes would not be committed", blocked, len(changes))
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/config"
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/filemode"
+	"github.com/go-git/go-git/v6/plumbing/object"
+)
+
+// stackBranch returns the branch a stack's changes are committed to with
+// --stack-branch-prefix, such as refs/heads/stacks/komodo
+func stackBranch(stack string) plumbing.ReferenceName {
+	return plumbing.NewBranchReferenceName(stackBranchFlag + stack)
+}
+
+// stackBranchTip returns the last commit of a stack's branch, or the commit
+// of HEAD the branch starts from when it doesn't exist yet
+func stackBranchTip(repo *git.Repository, stack string) (*object.Commit, error) {
+	ref, err := repo.Reference(stackBranch(stack), true)
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		ref, err = repo.Head()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return repo.CommitObject(ref.Hash())
+}
+
+// stackBranchFileContents returns the contents of a file as committed at the
+// tip of a stack's branch
+func stackBranchFileContents(repo *git.Repository, stack string, filePath string) ([]byte, error) {
+	tip, err := stackBranchTip(repo, stack)
+	if err != nil {
+		return nil, err
+	}
+	file, err := tip.File(filePath)
+	if err != nil {
+		return nil, err
+	}
+	contents, err := file.Contents()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(contents), nil
+}
+
+// worktreeEntry stores a worktree file as a blob and returns its tree entry,
+// or false when the file doesn't exist
+func worktreeEntry(repo *git.Repository, root string, name string) (object.TreeEntry, bool, error) {
+	osPath := filepath.Join(root, filepath.FromSlash(name))
+	info, err := os.Lstat(osPath)
+	if err == nil && info.Mode()&os.ModeSymlink != 0 && followsSymlink(name) {
+		info, err = os.Stat(osPath)
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return object.TreeEntry{}, false, nil
+	}
+	if err != nil {
+		return object.TreeEntry{}, false, err
+	}
+
+	mode := filemode.Regular
+	var data []byte
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		mode = filemode.Symlink
+		target, err := os.Readlink(osPath)
+		if err != nil {
+			return object.TreeEntry{}, false, err
+		}
+		data = []byte(target)
+	default:
+		if info.Mode()&0o111 != 0 {
+			mode = filemode.Executable
+		}
+		if data, err = os.ReadFile(osPath); err != nil {
+			return object.TreeEntry{}, false, err
+		}
+	}
+
+	blob := repo.Storer.NewEncodedObject()
+	blob.SetType(plumbing.BlobObject)
+	writer, err := blob.Writer()
+	if err != nil {
+		return object.TreeEntry{}, false, err
+	}
+	if _, err := writer.Write(data); err != nil {
+		return object.TreeEntry{}, false, err
+	}
+	if err := writer.Close(); err != nil {
+		return object.TreeEntry{}, false, err
+	}
+	hash, err := repo.Storer.SetEncodedObject(blob)
+	if err != nil {
+		return object.TreeEntry{}, false, err
+	}
+	return object.TreeEntry{Mode: mode, Hash: hash}, true, nil
+}
+
+// filterOnStackBranches drops the changes already committed to their stack's
+// branch: the checked out branch never gets them, so they stay changed in
+// the worktree until the stack branch is merged
+func filterOnStackBranches(repo *git.Repository, repoPath string, changes []Change) []Change {
+	var kept []Change
+	for _, change := range changes {
+		tip, err := stackBranchTip(repo, change.StackName)
+		if err != nil {
+			kept = append(kept, change)
+			continue
+		}
+		files, err := commitFiles(tip)
+		if err != nil {
+			kept = append(kept, change)
+			continue
+		}
+
+		committed := true
+		for _, name := range change.files() {
+			hash, exists, err := hashWorktreeFile(repoPath, name)
+			entry, inBranch := files[name]
+			if err != nil || exists != inBranch || (exists && hash != entry.Hash) {
+				committed = false
+				break
+			}
+		}
+		if committed {
+			opsLog.Debug("Change already on its stack branch", "stack", change.StackName, "path", change.FilePath, "branch", stackBranch(change.StackName).Short())
+			continue
+		}
+		kept = append(kept, change)
+	}
+	return kept
+}
+
+// commitToStackBranch commits a change to its stack's branch, created from
+// HEAD on the stack's first change, without touching the checked out branch,
+// its index or its worktree, and returns the commit's hash
+func commitToStackBranch(repo *git.Repository, root string, change Change, message string) (plumbing.Hash, error) {
+	branch := stackBranch(change.StackName)
+	tip, err := stackBranchTip(repo, change.StackName)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to resolve %s: %w", branch.Short(), err)
+	}
+	files, err := commitFiles(tip)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	var found []string
+	for _, name := range change.files() {
+		entry, exists, err := worktreeEntry(repo, root, name)
+		if err != nil {
+			return plumbing.ZeroHash, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		if !exists {
+			delete(files, name)
+			continue
+		}
+		files[name] = entry
+
+		// Never commit an unresolved merge
+		if entry.Mode != filemode.Symlink {
+			data, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(name)))
+			if err != nil {
+				return plumbing.ZeroHash, err
+			}
+			if lines := conflictMarkers(data); len(lines) > 0 {
+				eventLog.Error("Conflict markers found, refusing to commit", "stack", change.StackName, "path", name, "lines", lines)
+				found = append(found, name)
+			}
+		}
+	}
+	if len(found) > 0 {
+		return plumbing.ZeroHash, fmt.Errorf("%w: conflict markers in %s", errCommitHeld, strings.Join(found, ", "))
+	}
+
+	treeHash, err := buildTree(repo, files)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	if treeHash == tip.TreeHash {
+		return plumbing.ZeroHash, fmt.Errorf("nothing to commit on %s: %w", branch.Short(), git.ErrEmptyCommit)
+	}
+
+	author := watcherSignature(repo)
+	author.When = time.Now()
+	commit := &object.Commit{
+		Author:       author,
+		Committer:    author,
+		Message:      message,
+		TreeHash:     treeHash,
+		ParentHashes: []plumbing.Hash{tip.Hash},
+	}
+	if err := signCommitObject(commit); err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to sign: %w", err)
+	}
+	hash, err := storeObject(repo, commit)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to commit: %w", err)
+	}
+	if err := repo.Storer.SetReference(plumbing.NewHashReference(branch, hash)); err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to update %s: %w", branch.Short(), err)
+	}
+
+	eventLog.Info("✓ Created commit", "stack", change.StackName, "change", change.ChangeType, "branch", branch.Short(), "hash", hash.String()[:7], "message", message)
+	return hash, nil
+}
+
+// stackBranchRefSpecs pushes every stack branch, without the checked out one
+func stackBranchRefSpecs() []config.RefSpec {
+	pattern := plumbing.NewBranchReferenceName(stackBranchFlag + "*")
+	return []config.RefSpec{config.RefSpec(pattern + ":" + pattern)}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/format/index"
+	"github.com/go-git/go-git/v6/plumbing/object"
+)
+
+// files returns the repository paths committed by a stack change
+func (c Change) files() []string {
+	files := append([]string{c.FilePath}, c.Extras...)
+	files = append(files, c.Included...)
+	if c.OldPath != "" {
+		files = append(files, c.OldPath)
+	}
+	return files
+}
+
+// stackDirs returns the directory of every compose file known to the index
+// or changed in the worktree, keyed by directory with the compose file as
+// value
+func stackDirs(repo *git.Repository, status git.Status) map[string]string {
+	dirs := map[string]string{}
+	if idx, err := repo.Storer.Index(); err == nil {
+		for _, entry := range idx.Entries {
+			if isComposeFile(entry.Name) && !isArchived(entry.Name) {
+				dirs[path.Dir(entry.Name)] = entry.Name
+			}
+		}
+	}
+	for filePath, fileStatus := range status {
+		if isComposeFile(filePath) && !isArchived(filePath) && fileStatus.Worktree != git.Deleted && fileStatus.Staging != git.Deleted {
+			dirs[path.Dir(filePath)] = filePath
+		}
+	}
+	return dirs
+}
+
+// stackFiles are the files other than the watched ones whose status a cycle
+// needs: those of the stack directories under --stack-dir, or --clutter-size
+// for their untracked files, and those the compose files include
+type stackFiles struct {
+	dirs     map[string]string
+	included map[string]bool
+}
+
+// newStackFiles returns the stack files of the stacks of dirs, keyed by
+// directory like stackDirs returns them
+func newStackFiles(repoPath string, dirs map[string]string) stackFiles {
+	files := stackFiles{included: map[string]bool{}}
+	if stackDirFlag || clutterSizeFlag > 0 {
+		files.dirs = dirs
+	}
+	for _, composePath := range dirs {
+		for _, name := range composeIncludes(repoPath, composePath) {
+			if !isWatchedFile(name) {
+				files.included[name] = true
+			}
+		}
+	}
+	return files
+}
+
+// contains reports whether a repository path is a stack file
+func (f stackFiles) contains(filePath string) bool {
+	if f.included[filePath] {
+		return true
+	}
+	if f.dirs == nil {
+		return false
+	}
+	_, ok := owningStack(filePath, f.dirs)
+	return ok
+}
+
+// owningStack returns the directory of the innermost stack containing a
+// file. The root stack only owns the files next to its compose file, not
+// the whole repository.
+func owningStack(filePath string, dirs map[string]string) (string, bool) {
+	dir := path.Dir(filePath)
+	if _, ok := dirs[dir]; ok {
+		return dir, true
+	}
+	for dir != "." {
+		dir = path.Dir(dir)
+		if _, ok := dirs[dir]; ok && dir != "." {
+			return dir, true
+		}
+	}
+	return "", false
+}
+
+// withStackFiles implements --stack-dir: the other changed files of a stack
+// directory (.env, configuration files...) are committed with its compose
+// file, and a stack whose compose file didn't change is still committed as
+// updated when those files did
+func withStackFiles(repo *git.Repository, status git.Status, changes []Change) []Change {
+	dirs := stackDirs(repo, status)
+	for _, change := range changes {
+		dirs[path.Dir(change.FilePath)] = change.FilePath
+	}
+
+	extras := map[string][]string{}
+	for filePath, fileStatus := range status {
+		if isWatchedFile(filePath) || isArchived(filePath) {
+			continue
+		}
+		if fileStatus.Staging == git.Unmodified && fileStatus.Worktree == git.Unmodified {
+			continue
+		}
+		if dir, ok := owningStack(filePath, dirs); ok {
+			extras[dir] = append(extras[dir], filePath)
+		}
+	}
+
+	for i, change := range changes {
+		dir := path.Dir(change.FilePath)
+		changes[i].Extras = extras[dir]
+		delete(extras, dir)
+	}
+
+	stacks := make([]string, 0, len(extras))
+	for dir := range extras {
+		stacks = append(stacks, dir)
+	}
+	sort.Strings(stacks)
+	for _, dir := range stacks {
+		composePath := dirs[dir]
+		changes = append(changes, Change{
+			StackName:  getStackName(composePath),
+			FilePath:   composePath,
+			ChangeType: Updated,
+			Extras:     extras[dir],
+		})
+	}
+
+	for i := range changes {
+		sort.Strings(changes[i].Extras)
+	}
+	return changes
+}
+
+// stagedChangeDiffers reports whether the staged version of a change's
+// files differs from HEAD
+func stagedChangeDiffers(repo *git.Repository, change Change) (bool, error) {
+	idx, err := repo.Storer.Index()
+	if err != nil {
+		return false, err
+	}
+	head, err := repo.Head()
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return false, err
+	}
+
+	for _, filePath := range change.files() {
+		entry, err := idx.Entry(filePath)
+		if err != nil && !errors.Is(err, index.ErrEntryNotFound) {
+			return false, err
+		}
+		file, err := commit.File(filePath)
+		if err != nil && !errors.Is(err, object.ErrFileNotFound) {
+			return false, err
+		}
+		switch {
+		case entry == nil && file == nil:
+		case entry == nil || file == nil:
+			return true, nil
+		case entry.Hash != file.Hash || entry.Mode != file.Mode:
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// stageStackFiles stages the other files of a stack change and the files it
+// includes, removing those deleted from the worktree
+func stageStackFiles(worktree *git.Worktree, change Change) error {
+	root := worktree.Filesystem.Root()
+	for _, filePath := range append(slices.Clone(change.Extras), change.Included...) {
+		_, err := os.Lstat(filepath.Join(root, filepath.FromSlash(filePath)))
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			_, err = worktree.Remove(filePath)
+		case err == nil:
+			_, err = worktree.Add(filePath)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stackFilesBody lists the other files committed with a stack change
+func stackFilesBody(change Change) string {
+	if len(change.Extras) == 0 {
+		return ""
+	}
+	return "Stack files: " + strings.Join(change.Extras, ", ")
+}
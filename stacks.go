@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing/object"
+)
+
+// stackInfo describes a stack of the repository
+type stackInfo struct {
+	Name           string     `json:"name"`
+	Path           string     `json:"path"`
+	File           string     `json:"file"`
+	LastCommit     string     `json:"last_commit,omitempty"`
+	LastCommitTime *time.Time `json:"last_commit_time,omitempty"`
+	LastChange     ChangeType `json:"last_change,omitempty"`
+	Drift          string     `json:"drift"`
+}
+
+var stacksJSONFlag bool
+
+func stacksFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&stacksJSONFlag, "json", false, "Print the stacks as JSON")
+}
+
+// stackCommitPattern matches the messages of the watcher's own stack commits,
+// with or without a gitmoji prefix
+var stackCommitPattern = regexp.MustCompile(`^(?:\S+ )?(created|updated|deleted|renamed|archived) (?:\S+ -> )?(\S+)$`)
+
+// parseStackCommit extracts the change type and stack name of an auto-commit,
+// from its Stack-Watch trailer or, for older commits, its subject
+func parseStackCommit(message string) (ChangeType, string, bool) {
+	if value, ok := ownTrailerValue(message); ok {
+		changeType, name, ok := strings.Cut(value, " ")
+		return ChangeType(changeType), name, ok
+	}
+
+	subject, _, _ := strings.Cut(message, "\n")
+	match := stackCommitPattern.FindStringSubmatch(strings.TrimSpace(subject))
+	if match == nil {
+		return "", "", false
+	}
+	return ChangeType(match[1]), match[2], true
+}
+
+// listStacks finds every stack committed in HEAD or present in the worktree,
+// along with its last auto-commit and uncommitted state
+func listStacks(repo *git.Repository) ([]*stackInfo, error) {
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get worktree: %w", err)
+	}
+
+	status, err := worktreeStatus(repo, worktree)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get status: %w", err)
+	}
+
+	files := map[string]bool{}
+	for filePath := range status {
+		if isWatchedFile(filePath) && !isArchived(filePath) {
+			files[filePath] = true
+		}
+	}
+
+	head, err := repo.Head()
+	if err == nil {
+		commit, err := repo.CommitObject(head.Hash())
+		if err != nil {
+			return nil, err
+		}
+		tree, err := commit.Files()
+		if err != nil {
+			return nil, err
+		}
+		err = tree.ForEach(func(f *object.File) error {
+			if isWatchedFile(f.Name) && inScanScope(f.Name, skipDirs()) {
+				files[f.Name] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var stacks []*stackInfo
+	byName := map[string][]*stackInfo{}
+	for filePath := range files {
+		stack := &stackInfo{
+			Name:  getStackName(filePath),
+			Path:  path.Dir(filePath),
+			File:  filePath,
+			Drift: driftStatus(status[filePath]),
+		}
+		stacks = append(stacks, stack)
+		byName[stack.Name] = append(byName[stack.Name], stack)
+	}
+
+	sort.Slice(stacks, func(i, j int) bool { return stacks[i].Path < stacks[j].Path })
+
+	if head == nil {
+		return stacks, nil
+	}
+
+	// Walk the history for the last auto-commit of each stack
+	commits, err := repo.Log(&git.LogOptions{From: head.Hash()})
+	if err != nil {
+		return nil, err
+	}
+	defer commits.Close()
+
+	remaining := len(byName)
+	for remaining > 0 {
+		commit, err := commits.Next()
+		if err != nil {
+			break
+		}
+
+		changeType, name, ok := parseStackCommit(commit.Message)
+		if !ok || len(byName[name]) == 0 || byName[name][0].LastCommit != "" {
+			continue
+		}
+
+		when := commit.Author.When
+		for _, stack := range byName[name] {
+			stack.LastCommit = commit.Hash.String()[:7]
+			stack.LastCommitTime = &when
+			stack.LastChange = changeType
+		}
+		remaining--
+	}
+
+	return stacks, nil
+}
+
+// driftStatus describes the uncommitted state of a stack's compose file
+func driftStatus(fileStatus *git.FileStatus) string {
+	switch {
+	case fileStatus == nil:
+		return "clean"
+	case fileStatus.Worktree == git.Untracked:
+		return "untracked"
+	case fileStatus.Worktree == git.Deleted || fileStatus.Staging == git.Deleted:
+		return "deleted"
+	case fileStatus.Worktree == git.Modified:
+		return "modified"
+	case fileStatus.Staging != git.Unmodified:
+		return "staged"
+	default:
+		return "clean"
+	}
+}
+
+// runStacks prints the stack inventory as a table or JSON
+func runStacks(repo *git.Repository, args []string) error {
+	stacks, err := listStacks(repo)
+	if err != nil {
+		return err
+	}
+
+	if stacksJSONFlag {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if stacks == nil {
+			stacks = []*stackInfo{}
+		}
+		return encoder.Encode(stacks)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tPATH\tLAST COMMIT\tDATE\tCHANGE\tDRIFT")
+	for _, stack := range stacks {
+		commit, date, change := "-", "-", "-"
+		if stack.LastCommit != "" {
+			commit = stack.LastCommit
+			date = stack.LastCommitTime.Format("2006-01-02 15:04")
+			change = string(stack.LastChange)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", stack.Name, stack.Path, commit, date, change, stack.Drift)
+	}
+	return w.Flush()
+}
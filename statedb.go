@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "modernc.org/sqlite"
+)
+
+// stateTimeout bounds every query of the --state-store database, so that an
+// unreachable server fails the cycle instead of hanging it
+const stateTimeout = 10 * time.Second
+
+// stateDB is the database of --state-store, shared by the watchers of a fleet,
+// which keeps the documents of every repository in one table
+type stateDB struct {
+	db       *sql.DB
+	postgres bool
+}
+
+// stateDatabase is the opened --state-store database, nil for the file store
+var stateDatabase *stateDB
+
+// sqlStore keeps the documents of a repository in the --state-store database,
+// under the scope of the watcher and repository
+type sqlStore struct {
+	db    *stateDB
+	scope string
+}
+
+// openStateDatabase opens and prepares the database of --state-store:
+// sqlite:<path> or a postgres:// URL. The file store needs none.
+func openStateDatabase() error {
+	store := secretValue(stateStoreFlag, "STATE_STORE")
+	var driver, dsn string
+	switch {
+	case store == "" || store == "file":
+		return nil
+	case strings.HasPrefix(store, "sqlite:"):
+		driver, dsn = "sqlite", strings.TrimPrefix(store, "sqlite:")
+		if dsn == "" {
+			return errors.New("sqlite: needs the path of the database file")
+		}
+	case strings.HasPrefix(store, "postgres://"), strings.HasPrefix(store, "postgresql://"):
+		driver, dsn = "pgx", store
+	default:
+		return errors.New("expected file, sqlite:<path> or a postgres:// URL")
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return err
+	}
+	// SQLite serializes writers, which a single connection never makes wait
+	if driver == "sqlite" {
+		db.SetMaxOpenConns(1)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), stateTimeout)
+	defer cancel()
+	_, err = db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS stack_watch_state (
+		scope TEXT NOT NULL,
+		name TEXT NOT NULL,
+		document TEXT NOT NULL,
+		PRIMARY KEY (scope, name)
+	)`)
+	if err != nil {
+		db.Close()
+		return fmt.Errorf("failed to create the state table: %w", err)
+	}
+
+	stateDatabase = &stateDB{db: db, postgres: driver == "pgx"}
+	return nil
+}
+
+// stateScope returns the scope of a repository's documents: --state-scope,
+// or the hostname, and the repository's git directory
+func stateScope(dir string) string {
+	scope := stateScopeFlag
+	if scope == "" {
+		scope, _ = os.Hostname()
+	}
+	return scope + ":" + dir
+}
+
+// query rewrites the ? placeholders of a query as $1, $2... for Postgres
+func (d *stateDB) query(query string) string {
+	if !d.postgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (s sqlStore) load(key string, v any) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), stateTimeout)
+	defer cancel()
+
+	var document string
+	err := s.db.db.QueryRowContext(ctx, s.db.query("SELECT document FROM stack_watch_state WHERE scope = ? AND name = ?"), s.scope, key).Scan(&document)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal([]byte(document), v)
+}
+
+func (s sqlStore) save(key string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), stateTimeout)
+	defer cancel()
+	_, err = s.db.db.ExecContext(ctx, s.db.query(`INSERT INTO stack_watch_state (scope, name, document) VALUES (?, ?, ?)
+		ON CONFLICT (scope, name) DO UPDATE SET document = excluded.document`), s.scope, key, string(data))
+	return err
+}
+
+func (s sqlStore) remove(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), stateTimeout)
+	defer cancel()
+	_, err := s.db.db.ExecContext(ctx, s.db.query("DELETE FROM stack_watch_state WHERE scope = ? AND name = ?"), s.scope, key)
+	return err
+}
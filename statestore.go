@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v6"
+)
+
+// stateStore persists the watcher's state between runs, as JSON documents
+// under a key. load reports false when there is no document for the key.
+type stateStore interface {
+	load(key string, v any) (bool, error)
+	save(key string, v any) error
+	remove(key string) error
+}
+
+// fileStore keeps each document in a file of a directory, <prefix><key>.json
+type fileStore struct {
+	dir    string
+	prefix string
+}
+
+// openStateStore returns the state store of a repository on this machine:
+// files in its git directory, which never get committed, or its scope of
+// the --state-store database
+func openStateStore(repo *git.Repository) (stateStore, error) {
+	dir, err := gitDir(repo)
+	if err != nil {
+		return nil, err
+	}
+	if stateDatabase != nil {
+		return sqlStore{db: stateDatabase, scope: stateScope(dir)}, nil
+	}
+	return fileStore{dir: dir, prefix: "stack-watch-"}, nil
+}
+
+// metadataDir is the worktree directory of the repository metadata with
+// --metadata ignored or committed
+const metadataDir = ".stack-watch"
+
+// isMetadataPath reports whether a repository path is in metadataDir
+func isMetadataPath(filePath string) bool {
+	return filePath == metadataDir || strings.HasPrefix(filePath, metadataDir+"/")
+}
+
+// openMetadataStore returns the store of the state that belongs with the
+// repository rather than the machine: stack aliases, sequences and held
+// changes. --metadata keeps it in the git directory with the rest, or in
+// metadataDir in the worktree, gitignored or committed with the stack
+// commits so that it travels with the repository.
+func openMetadataStore(repo *git.Repository) (stateStore, error) {
+	if metadataFlag == "git" {
+		return openStateStore(repo)
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(worktree.Filesystem.Root(), metadataDir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	if metadataFlag == "ignored" {
+		ignore := filepath.Join(dir, ".gitignore")
+		if _, err := os.Stat(ignore); errors.Is(err, os.ErrNotExist) {
+			if err := os.WriteFile(ignore, []byte("*\n"), 0o644); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return fileStore{dir: dir}, nil
+}
+
+// parseMetadata validates --metadata
+func parseMetadata() error {
+	switch metadataFlag {
+	case "git", "ignored", "committed":
+	default:
+		return fmt.Errorf("invalid --metadata %q, expected git, ignored or committed", metadataFlag)
+	}
+	if metadataFlag == "committed" && stackBranchFlag != "" {
+		return errors.New("--metadata committed can't be used with --stack-branch-prefix")
+	}
+	return nil
+}
+
+// stageMetadata stages the metadata files with --metadata committed, for
+// them to go in the next commit
+func stageMetadata(worktree *git.Worktree) error {
+	if metadataFlag != "committed" {
+		return nil
+	}
+	entries, err := os.ReadDir(filepath.Join(worktree.Filesystem.Root(), metadataDir))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			if _, err := worktree.Add(path.Join(metadataDir, entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// snapshotMetadata returns the files of metadataDir by repository path, for
+// restoreMetadata to roll back a commit that failed
+func snapshotMetadata(worktree *git.Worktree) (map[string][]byte, error) {
+	saved := map[string][]byte{}
+	dir := filepath.Join(worktree.Filesystem.Root(), metadataDir)
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return saved, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		saved[path.Join(metadataDir, entry.Name())] = data
+	}
+	return saved, nil
+}
+
+// restoreMetadata puts the metadata files back as snapshotMetadata saved
+// them and unstages them, so that the next commit doesn't carry the
+// metadata of a change that wasn't committed
+func restoreMetadata(worktree *git.Worktree, saved map[string][]byte) {
+	current, err := snapshotMetadata(worktree)
+	if err != nil {
+		opsLog.Warn("Failed to roll back the metadata", "error", err)
+		return
+	}
+
+	root := worktree.Filesystem.Root()
+	var files []string
+	for name := range current {
+		files = append(files, name)
+		if _, ok := saved[name]; !ok {
+			if err := os.Remove(filepath.Join(root, filepath.FromSlash(name))); err != nil {
+				opsLog.Warn("Failed to roll back the metadata", "path", name, "error", err)
+			}
+		}
+	}
+	for name, data := range saved {
+		if err := os.WriteFile(filepath.Join(root, filepath.FromSlash(name)), data, 0o644); err != nil {
+			opsLog.Warn("Failed to roll back the metadata", "path", name, "error", err)
+		}
+	}
+	if len(files) == 0 {
+		return
+	}
+	if err := worktree.Restore(&git.RestoreOptions{Staged: true, Files: files}); err != nil {
+		opsLog.Warn("Failed to unstage the metadata", "error", err)
+	}
+}
+
+func (s fileStore) path(key string) string {
+	return filepath.Join(s.dir, s.prefix+key+".json")
+}
+
+func (s fileStore) load(key string, v any) (bool, error) {
+	data, err := os.ReadFile(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal(data, v)
+}
+
+func (s fileStore) save(key string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path(key), data, 0o644)
+}
+
+func (s fileStore) remove(key string) error {
+	if err := os.Remove(s.path(key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing/object"
+)
+
+// openTestDatabase opens the --state-store database of a test, closing it
+// once the test is done
+func openTestDatabase(t *testing.T, store string) {
+	t.Helper()
+	flag := stateStoreFlag
+	stateStoreFlag = store
+	t.Cleanup(func() {
+		if stateDatabase != nil {
+			stateDatabase.db.Close()
+		}
+		stateStoreFlag, stateDatabase = flag, nil
+	})
+	if err := openStateDatabase(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStateStores(t *testing.T) {
+	type document struct {
+		Stack string `json:"stack"`
+	}
+
+	tests := []struct {
+		name string
+		open func(t *testing.T) (stateStore, stateStore)
+	}{
+		{
+			name: "file",
+			open: func(t *testing.T) (stateStore, stateStore) {
+				return fileStore{dir: t.TempDir()}, fileStore{dir: t.TempDir()}
+			},
+		},
+		{
+			name: "sqlite",
+			open: func(t *testing.T) (stateStore, stateStore) {
+				openTestDatabase(t, "sqlite:"+filepath.Join(t.TempDir(), "state.db"))
+				return sqlStore{db: stateDatabase, scope: "a"}, sqlStore{db: stateDatabase, scope: "b"}
+			},
+		},
+		{
+			name: "postgres",
+			open: func(t *testing.T) (stateStore, stateStore) {
+				url := os.Getenv("TEST_POSTGRES_URL")
+				if url == "" {
+					t.Skip("TEST_POSTGRES_URL is not set")
+				}
+				openTestDatabase(t, url)
+				scope := t.Name() + t.TempDir()
+				return sqlStore{db: stateDatabase, scope: scope + "a"}, sqlStore{db: stateDatabase, scope: scope + "b"}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, other := tt.open(t)
+
+			var got document
+			if found, err := store.load("journal", &got); err != nil || found {
+				t.Fatalf("load() of a missing key = %v, %v, want false, nil", found, err)
+			}
+
+			for _, stack := range []string{"web", "db"} {
+				if err := store.save("journal", document{Stack: stack}); err != nil {
+					t.Fatal(err)
+				}
+				found, err := store.load("journal", &got)
+				if err != nil || !found || got.Stack != stack {
+					t.Fatalf("load() = %v, %v, %+v, want true, nil, %q", found, err, got, stack)
+				}
+			}
+
+			if found, err := other.load("journal", &got); err != nil || found {
+				t.Errorf("load() of another scope = %v, %v, want false, nil", found, err)
+			}
+
+			if err := store.remove("journal"); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.remove("journal"); err != nil {
+				t.Errorf("remove() of a missing key = %v, want nil", err)
+			}
+			if found, err := store.load("journal", &got); err != nil || found {
+				t.Errorf("load() after remove() = %v, %v, want false, nil", found, err)
+			}
+		})
+	}
+}
+
+func TestOpenStateDatabase(t *testing.T) {
+	tests := []struct {
+		store   string
+		opened  bool
+		wantErr bool
+	}{
+		{store: "", opened: false},
+		{store: "file", opened: false},
+		{store: "sqlite:" + filepath.Join(t.TempDir(), "state.db"), opened: true},
+		{store: "sqlite:", wantErr: true},
+		{store: "mysql://db/stacks", wantErr: true},
+		{store: "/var/lib/state.db", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.store, func(t *testing.T) {
+			flag := stateStoreFlag
+			stateStoreFlag = tt.store
+			t.Cleanup(func() {
+				if stateDatabase != nil {
+					stateDatabase.db.Close()
+				}
+				stateStoreFlag, stateDatabase = flag, nil
+			})
+
+			err := openStateDatabase()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("openStateDatabase() error = %v, want error %v", err, tt.wantErr)
+			}
+			if opened := stateDatabase != nil; opened != tt.opened {
+				t.Errorf("database opened = %v, want %v", opened, tt.opened)
+			}
+		})
+	}
+}
+
+func TestStateDBQuery(t *testing.T) {
+	query := "SELECT document FROM stack_watch_state WHERE scope = ? AND name = ?"
+	tests := []struct {
+		postgres bool
+		want     string
+	}{
+		{false, query},
+		{true, "SELECT document FROM stack_watch_state WHERE scope = $1 AND name = $2"},
+	}
+
+	for _, tt := range tests {
+		db := &stateDB{postgres: tt.postgres}
+		if got := db.query(query); got != tt.want {
+			t.Errorf("query() with postgres %v = %q, want %q", tt.postgres, got, tt.want)
+		}
+	}
+}
+
+func TestRestoreMetadata(t *testing.T) {
+	repo, _ := initTestRepo(t)
+	worktree, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := worktree.Filesystem.Root()
+	dir := filepath.Join(root, metadataDir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	write := func(name string, data string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("stacks.json", `{"web":{"sequence":1}}`)
+	if _, err := worktree.Add(metadataDir + "/stacks.json"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := worktree.Commit("metadata", &git.CommitOptions{Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()}}); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := snapshotMetadata(worktree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write("stacks.json", `{"web":{"sequence":2}}`)
+	write("aliases.json", `{}`)
+	metadata := metadataFlag
+	metadataFlag = "committed"
+	t.Cleanup(func() { metadataFlag = metadata })
+	if err := stageMetadata(worktree); err != nil {
+		t.Fatal(err)
+	}
+
+	restoreMetadata(worktree, saved)
+
+	data, err := os.ReadFile(filepath.Join(dir, "stacks.json"))
+	if err != nil || string(data) != `{"web":{"sequence":1}}` {
+		t.Errorf("stacks.json = %q, %v, want the saved version", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "aliases.json")); !os.IsNotExist(err) {
+		t.Errorf("aliases.json still exists: %v", err)
+	}
+	status, err := worktree.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.IsClean() {
+		t.Errorf("status after restoreMetadata() = %v, want clean", status)
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v6"
+)
+
+// startedAt is when the watcher started, reported by the status API
+var startedAt = time.Now()
+
+// statusRepos are the watched repositories reported by the status API
+var statusRepos []*watchedRepo
+
+// repoSnapshot is the state of a watched repository as of its last cycle
+type repoSnapshot struct {
+	Path         string    `json:"path"`
+	LastCycle    time.Time `json:"last_cycle"`
+	PushFailures int       `json:"push_failures"`
+	PushPending  bool      `json:"push_pending"`
+	Diverged     bool      `json:"diverged"`
+	Corrupted    bool      `json:"corrupted"`
+}
+
+// remoteSnapshot is the outcome of the last remote probe
+type remoteSnapshot struct {
+	Probed    time.Time `json:"probed"`
+	Reachable bool      `json:"reachable"`
+	LatencyMs int64     `json:"latency_ms"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// statusReport is the document served on /status
+type statusReport struct {
+	Started      time.Time      `json:"started"`
+	Interval     string         `json:"interval"`
+	Push         bool           `json:"push"`
+	Repositories []repoSnapshot `json:"repositories"`
+	Remote       remoteSnapshot `json:"remote"`
+}
+
+// takeSnapshot saves the state of the repository for the status API, which
+// reads it without waiting for the cycle in progress
+func (w *watchedRepo) takeSnapshot() {
+	w.snapshotMu.Lock()
+	defer w.snapshotMu.Unlock()
+	w.snapshot = repoSnapshot{
+		Path:         w.path,
+		LastCycle:    w.state.lastCycle,
+		PushFailures: w.state.pushFailures,
+		PushPending:  w.state.pushPending,
+		Diverged:     w.state.diverged,
+		Corrupted:    w.state.repoCorrupted,
+	}
+}
+
+// currentStatus assembles the status of the watcher
+func currentStatus() statusReport {
+	report := statusReport{Started: startedAt, Interval: intervalFlag.String(), Push: pushFlag}
+	for _, w := range statusRepos {
+		w.snapshotMu.Lock()
+		report.Repositories = append(report.Repositories, w.snapshot)
+		w.snapshotMu.Unlock()
+	}
+
+	remoteStatus.mu.Lock()
+	report.Remote = remoteSnapshot{
+		Probed:    remoteStatus.Probed,
+		Reachable: remoteStatus.Reachable,
+		LatencyMs: remoteStatus.Latency.Milliseconds(),
+		Error:     remoteStatus.Error,
+	}
+	remoteStatus.mu.Unlock()
+	return report
+}
+
+func handleStatus(rw http.ResponseWriter, _ *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(currentStatus())
+}
+
+// handleLogs serves the last log lines kept in memory, all of them or the
+// last ?lines=N
+func handleLogs(rw http.ResponseWriter, r *http.Request) {
+	lines := recentLogs.snapshot()
+	if value := r.URL.Query().Get("lines"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			http.Error(rw, "lines must be a positive number", http.StatusBadRequest)
+			return
+		}
+		if n < len(lines) {
+			lines = lines[len(lines)-n:]
+		}
+	}
+
+	rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, line := range lines {
+		io.WriteString(rw, line+"\n")
+	}
+}
+
+// serveStatus serves the status API in the background: /status, the state
+// of every repository as JSON, /logs, the recent log lines, /approvals and
+// /approve, the deletions waiting for approval, and /openapi.json, the
+// description of the API, which takes no token
+func serveStatus(addr string) error {
+	listener, err := apiListen(addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", handleStatus)
+	mux.HandleFunc("GET /logs", handleLogs)
+	mux.HandleFunc("GET /approvals", handleApprovals)
+
+	// Approvers authenticate with their own token rather than an API one
+	public := http.NewServeMux()
+	public.HandleFunc("POST /approve", handleApprove)
+	public.HandleFunc("GET /openapi.json", handleOpenAPI)
+	public.Handle("/", protectAPI(mux, roleViewer))
+
+	go func() {
+		if err := http.Serve(listener, protectAPI(public, roleNone)); err != nil {
+			processLog.Error("Status API stopped", "error", err)
+		}
+	}()
+
+	if loopbackAddr(addr) != nil && !apiSecured() {
+		opsLog.Warn("Status API is reachable from other hosts without authentication, and its logs may reveal paths and remotes", "addr", addr)
+	}
+	opsLog.Info("Serving the status API", "url", apiURL(listener, "/status"))
+	return nil
+}
+
+var (
+	statusURLFlag   string
+	statusLinesFlag int
+	statusCAFlag    string
+	statusCertFlag  string
+	statusKeyFlag   string
+)
+
+func statusFlags(fs *flag.FlagSet) {
+	fs.StringVar(&statusURLFlag, "url", "http://localhost:8080", "Base URL of the status API of the watcher, see --status-addr")
+	fs.IntVar(&statusLinesFlag, "lines", 20, "Number of recent log lines to show (0 for none)")
+	fs.StringVar(&statusCAFlag, "tls-ca", "", "CA certificate the watcher's certificate is checked against, for https URLs (default: system roots)")
+	fs.StringVar(&statusCertFlag, "tls-cert", "", "Client certificate presented to a watcher set up with --api-client-ca")
+	fs.StringVar(&statusKeyFlag, "tls-key", "", "Private key of --tls-cert")
+}
+
+// statusClient returns the HTTP client of the status command, with the CA
+// and client certificate of its options
+func statusClient() (*http.Client, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if statusCAFlag != "" {
+		data, err := os.ReadFile(statusCAFlag)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(data) {
+			return nil, errors.New("no PEM certificate in --tls-ca")
+		}
+	}
+	if statusCertFlag != "" {
+		cert, err := tls.LoadX509KeyPair(statusCertFlag, statusKeyFlag)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return &http.Client{Timeout: 10 * time.Second, Transport: &http.Transport{TLSClientConfig: config}}, nil
+}
+
+// fetchStatus gets a document of the status API, with the --api-token or,
+// as reading the status is all it does, the --api-viewer-token
+func fetchStatus(path string) ([]byte, error) {
+	client, err := statusClient()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(statusURLFlag, "/")+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token := firstOf(secretValue(apiTokenFlag, "API_TOKEN"), secretValue(apiViewerTokenFlag, "API_VIEWER_TOKEN")); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s: %s", path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+// runStatus prints the state and the recent logs of a running watcher,
+// possibly on another host, from its status API
+func runStatus(_ *git.Repository, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("expected no arguments, got %d", len(args))
+	}
+
+	body, err := fetchStatus("/status")
+	if err != nil {
+		return err
+	}
+	var report statusReport
+	if err := json.Unmarshal(body, &report); err != nil {
+		return fmt.Errorf("invalid status: %w", err)
+	}
+
+	fmt.Printf("Running since %s, checking every %s, push %t\n", report.Started.Format("2006-01-02 15:04"), report.Interval, report.Push)
+	for _, repo := range report.Repositories {
+		last := "never"
+		if !repo.LastCycle.IsZero() {
+			last = repo.LastCycle.Format("2006-01-02 15:04:05")
+		}
+		fmt.Printf("  %s: last cycle %s, push failures %d, push pending %t, diverged %t, corrupted %t\n", repo.Path, last, repo.PushFailures, repo.PushPending, repo.Diverged, repo.Corrupted)
+	}
+	if !report.Remote.Probed.IsZero() {
+		fmt.Printf("  remote: reachable %t, latency %dms, probed %s", report.Remote.Reachable, report.Remote.LatencyMs, report.Remote.Probed.Format("2006-01-02 15:04:05"))
+		if report.Remote.Error != "" {
+			fmt.Printf(", error: %s", report.Remote.Error)
+		}
+		fmt.Println()
+	}
+
+	if statusLinesFlag == 0 {
+		return nil
+	}
+	logs, err := fetchStatus("/logs?lines=" + strconv.Itoa(statusLinesFlag))
+	if err != nil {
+		return err
+	}
+	if len(logs) == 0 {
+		return errors.New("the watcher kept no log lines")
+	}
+	fmt.Printf("\nRecent log lines:\n%s", logs)
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/go-git/go-git/v6"
+)
+
+// portSpecs renders a service's 'ports' entries as in the short syntax,
+// such as "127.0.0.1:8080:80/udp", in the order of the file
+func portSpecs(entries any) []string {
+	list, _ := entries.([]any)
+
+	var specs []string
+	for _, entry := range list {
+		switch entry := entry.(type) {
+		case map[string]any:
+			spec := fmt.Sprint(orEmpty(entry["target"]))
+			if published := fmt.Sprint(orEmpty(entry["published"])); published != "" {
+				spec = published + ":" + spec
+			}
+			if ip := fmt.Sprint(orEmpty(entry["host_ip"])); ip != "" {
+				spec = ip + ":" + spec
+			}
+			if protocol := fmt.Sprint(orEmpty(entry["protocol"])); protocol != "" && protocol != "tcp" {
+				spec += "/" + protocol
+			}
+			specs = append(specs, spec)
+		default:
+			specs = append(specs, fmt.Sprint(entry))
+		}
+	}
+	return specs
+}
+
+// orNone joins values for a summary line, "none" when there are none
+func orNone(values []string) string {
+	if len(values) == 0 {
+		return "none"
+	}
+	return strings.Join(values, ", ")
+}
+
+// nonEmpty returns a value as a list, empty for an empty value
+func nonEmpty(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return []string{value}
+}
+
+// composeSummary sums up the differences between two versions of a compose
+// file, one line each for the services added and removed, the images
+// changed and the ports modified. Unparsable versions have no summary.
+func composeSummary(before, after []byte) []string {
+	oldServices, err := serviceDefinitions(before)
+	if err != nil {
+		return nil
+	}
+	newServices, err := serviceDefinitions(after)
+	if err != nil {
+		return nil
+	}
+
+	var added, removed, images, ports []string
+	names := keySet(oldServices)
+	for name := range newServices {
+		names[name] = true
+	}
+	for _, name := range sortedKeys(names) {
+		old, hadService := oldServices[name]
+		cur, hasService := newServices[name]
+		switch {
+		case !hadService:
+			added = append(added, name)
+			continue
+		case !hasService:
+			removed = append(removed, name)
+			continue
+		}
+
+		oldImage, _ := serviceField(old, "image").(string)
+		newImage, _ := serviceField(cur, "image").(string)
+		if oldImage != newImage {
+			images = append(images, fmt.Sprintf("%s %s -> %s", name, orNone(nonEmpty(oldImage)), orNone(nonEmpty(newImage))))
+		}
+
+		oldPorts, newPorts := portSpecs(serviceField(old, "ports")), portSpecs(serviceField(cur, "ports"))
+		if !slices.Equal(oldPorts, newPorts) {
+			ports = append(ports, fmt.Sprintf("%s %s -> %s", name, orNone(oldPorts), orNone(newPorts)))
+		}
+	}
+
+	var lines []string
+	if len(added) > 0 {
+		lines = append(lines, "Services added: "+strings.Join(added, ", "))
+	}
+	if len(removed) > 0 {
+		lines = append(lines, "Services removed: "+strings.Join(removed, ", "))
+	}
+	if len(images) > 0 {
+		lines = append(lines, "Images: "+strings.Join(images, "; "))
+	}
+	if len(ports) > 0 {
+		lines = append(lines, "Ports: "+strings.Join(ports, "; "))
+	}
+	return lines
+}
+
+// summaryBody sums up what changed in a stack's compose file since HEAD, or
+// the tip of its branch under --stack-branch-prefix, so that 'git log' alone
+// tells: services added and removed, images and ports. A renamed stack is
+// compared with its old compose file.
+func summaryBody(repo *git.Repository, repoPath string, change Change) string {
+	headPath := change.FilePath
+	if change.ChangeType == Renamed {
+		headPath = change.OldPath
+	}
+	// Missing versions of created or deleted files count as empty
+	var before []byte
+	if stackBranchFlag != "" {
+		before, _ = stackBranchFileContents(repo, change.StackName, headPath)
+	} else {
+		before, _ = headFileContents(repo, headPath)
+	}
+	var after []byte
+	if change.ChangeType != Deleted {
+		after, _ = readWorktreeFile(repoPath, change.FilePath)
+	}
+	return strings.Join(composeSummary(before, after), "\n")
+}
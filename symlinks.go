@@ -0,0 +1,99 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing/filemode"
+)
+
+// followsSymlink reports whether a symlinked file is tracked by the content
+// of its target rather than as a link, with --symlinks follow
+func followsSymlink(name string) bool {
+	return symlinksFlag == "follow" && isWatchedFile(name)
+}
+
+// isSymlink reports whether a repository path is a symlink in the worktree
+func isSymlink(root string, name string) bool {
+	info, err := os.Lstat(filepath.Join(root, filepath.FromSlash(name)))
+	return err == nil && info.Mode()&os.ModeSymlink != 0
+}
+
+// followSymlinks fixes the status of symlinked watched files for --symlinks
+// follow: git compares the link itself, so a file is modified when its
+// target's content differs from the index, whatever the link says
+func followSymlinks(repo *git.Repository, repoPath string, status git.Status) {
+	idx, err := repo.Storer.Index()
+	if err != nil {
+		opsLog.Warn("Failed to read the index, symlinks are compared as links", "error", err)
+		return
+	}
+
+	for _, entry := range idx.Entries {
+		if !isWatchedFile(entry.Name) || isArchived(entry.Name) || !isSymlink(repoPath, entry.Name) {
+			continue
+		}
+		hash, exists, err := hashWorktreeFile(repoPath, entry.Name)
+		if err != nil || !exists {
+			// A dangling link is left to git: it's committed as a change
+			opsLog.Warn("Symlinked file has no readable target", "path", entry.Name, "error", err)
+			continue
+		}
+		if hash == entry.Hash && entry.Mode != filemode.Symlink {
+			delete(status, entry.Name)
+			continue
+		}
+		status[entry.Name] = &git.FileStatus{Staging: git.Unmodified, Worktree: git.Modified}
+	}
+}
+
+// skippedSymlinks are the symlinked files already warned about with
+// --symlinks skip
+var (
+	skippedSymlinksMu sync.Mutex
+	skippedSymlinks   = map[string]bool{}
+)
+
+// filterSymlinks drops the changes of symlinked files with --symlinks skip,
+// warning once per file
+func filterSymlinks(repoPath string, changes []Change) []Change {
+	var kept []Change
+	for _, change := range changes {
+		if change.ChangeType == Deleted || !isSymlink(repoPath, change.FilePath) {
+			kept = append(kept, change)
+			continue
+		}
+
+		skippedSymlinksMu.Lock()
+		warned := skippedSymlinks[change.FilePath]
+		skippedSymlinks[change.FilePath] = true
+		skippedSymlinksMu.Unlock()
+		if !warned {
+			target, _ := os.Readlink(filepath.Join(repoPath, filepath.FromSlash(change.FilePath)))
+			eventLog.Warn("Symlinked file, not committing it", "stack", change.StackName, "path", change.FilePath, "target", target)
+		}
+	}
+	return kept
+}
+
+// stageSymlinkTarget replaces the staged link of a symlinked file by its
+// target's content, with --symlinks follow
+func stageSymlinkTarget(repo *git.Repository, root string, name string) error {
+	entry, exists, err := worktreeEntry(repo, root, name)
+	if err != nil || !exists {
+		return err
+	}
+
+	idx, err := repo.Storer.Index()
+	if err != nil {
+		return err
+	}
+	staged, err := idx.Entry(name)
+	if err != nil {
+		return err
+	}
+	staged.Hash, staged.Mode = entry.Hash, entry.Mode
+	return repo.Storer.SetIndex(idx)
+}
@@ -0,0 +1,32 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"log/slog"
+	"log/syslog"
+)
+
+// newSyslogHandler creates a handler writing to the local syslog daemon (or
+// journald through /dev/log), mapping log levels to syslog priorities
+func newSyslogHandler(level slog.Leveler) (slog.Handler, error) {
+	writer, err := syslog.New(syslog.LOG_DAEMON|syslog.LOG_INFO, "git-stack-watch")
+	if err != nil {
+		return nil, err
+	}
+
+	write := func(level slog.Level, line string) error {
+		switch {
+		case level >= slog.LevelError:
+			return writer.Err(line)
+		case level >= slog.LevelWarn:
+			return writer.Warning(line)
+		case level >= slog.LevelInfo:
+			return writer.Info(line)
+		default:
+			return writer.Debug(line)
+		}
+	}
+
+	return &plainHandler{write: write, level: level}, nil
+}
@@ -0,0 +1,13 @@
+//go:build windows || plan9
+
+package main
+
+import (
+	"errors"
+	"log/slog"
+)
+
+// newSyslogHandler reports that syslog is unavailable on this platform
+func newSyslogHandler(level slog.Leveler) (slog.Handler, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// commitTemplate is the parsed --commit-template, nil for the default
+// "<change> <stack>" subject
+var commitTemplate *template.Template
+
+// commitTemplateData holds the variables available to --commit-template
+type commitTemplateData struct {
+	Stack      string
+	ChangeType ChangeType
+	FilePath   string
+	Class      string
+	Gitmoji    string
+	Hostname   string
+	Timestamp  time.Time
+
+	// OldStack and OldPath are set for renamed stacks
+	OldStack string
+	OldPath  string
+
+	// Sequence numbers the stack's commits, from 1
+	Sequence int
+}
+
+// parseCommitTemplate parses --commit-template
+func parseCommitTemplate() error {
+	if commitTemplateFlag == "" {
+		return nil
+	}
+	tmpl, err := template.New("commit").Parse(commitTemplateFlag)
+	if err != nil {
+		return err
+	}
+	commitTemplate = tmpl
+
+	// Catch unknown variables now rather than on the first commit
+	_, err = renderCommitTemplate(Change{StackName: "stack", FilePath: "stack/compose.yml", ChangeType: Updated})
+	return err
+}
+
+// renderCommitTemplate renders --commit-template for a change
+func renderCommitTemplate(change Change) (string, error) {
+	hostname, _ := os.Hostname()
+	data := commitTemplateData{
+		Stack:      change.StackName,
+		ChangeType: change.ChangeType,
+		FilePath:   change.FilePath,
+		Class:      change.Class,
+		Gitmoji:    gitmojis[change.ChangeType],
+		Hostname:   hostname,
+		Timestamp:  time.Now(),
+		OldPath:    change.OldPath,
+		Sequence:   max(change.Sequence, 1),
+	}
+	if change.OldPath != "" {
+		data.OldStack = getStackName(change.OldPath)
+	}
+
+	var out bytes.Buffer
+	if err := commitTemplate.Execute(&out, data); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out.String()), nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v6"
+)
+
+// stackIntervals are the parsed --stack-min-interval values, by stack name
+var stackIntervals map[string]time.Duration
+
+// parseStackIntervals parses the comma separated stack=duration pairs of
+// --stack-min-interval
+func parseStackIntervals() error {
+	stackIntervals = map[string]time.Duration{}
+	for _, item := range strings.Split(stackIntervalFlag, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		stack, value, ok := strings.Cut(item, "=")
+		if !ok || strings.TrimSpace(stack) == "" {
+			return fmt.Errorf("invalid interval %q, expected stack=duration", item)
+		}
+		interval, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || interval <= 0 {
+			return fmt.Errorf("invalid interval %q, expected a positive duration like 1h", item)
+		}
+		stackIntervals[strings.TrimSpace(stack)] = interval
+	}
+	return nil
+}
+
+// stackInterval returns the --stack-min-interval of a change's stack, given
+// under its name or one of its past names
+func stackInterval(change Change) (time.Duration, bool) {
+	for _, name := range append([]string{change.StackName}, change.Aliases...) {
+		if interval, ok := stackIntervals[name]; ok {
+			return interval, true
+		}
+	}
+	return 0, false
+}
+
+// lastStackCommitWithin returns when a stack was last committed under one of
+// its names, if it was within the interval. Only the commits of the interval
+// are walked.
+func lastStackCommitWithin(repo *git.Repository, stack string, aliases []string, interval time.Duration) (time.Time, bool, error) {
+	head, err := repo.Head()
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	from := head.Hash()
+	if stackBranchFlag != "" {
+		tip, err := stackBranchTip(repo, stack)
+		if err != nil {
+			return time.Time{}, false, err
+		}
+		from = tip.Hash
+	}
+
+	commits, err := repo.Log(&git.LogOptions{From: from})
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	defer commits.Close()
+
+	since := time.Now().Add(-interval)
+	for {
+		commit, err := commits.Next()
+		if err != nil || commit.Committer.When.Before(since) {
+			return time.Time{}, false, nil
+		}
+		if _, name, ok := parseStackCommit(commit.Message); ok && (name == stack || slices.Contains(aliases, name)) {
+			return commit.Committer.When, true, nil
+		}
+	}
+}
+
+// filterThrottled holds the changes of stacks committed less than their
+// --stack-min-interval ago. They stay in the worktree, so the next commit
+// once the interval elapsed has the stack's final state.
+func filterThrottled(repo *git.Repository, changes []Change) []Change {
+	var kept []Change
+	for _, change := range changes {
+		interval, ok := stackInterval(change)
+		if !ok {
+			kept = append(kept, change)
+			continue
+		}
+
+		last, recent, err := lastStackCommitWithin(repo, change.StackName, change.Aliases, interval)
+		if err != nil {
+			opsLog.Warn("Failed to find the stack's last commit, not holding it", "stack", change.StackName, "error", err)
+		}
+		if !recent {
+			kept = append(kept, change)
+			continue
+		}
+		eventLog.Info("Holding change until the stack's minimum interval elapses", "stack", change.StackName, "path", change.FilePath, "last_commit", last.Format(time.RFC3339), "next", last.Add(interval).Format(time.RFC3339))
+	}
+	return kept
+}
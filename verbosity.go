@@ -0,0 +1,14 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyVerbosity relays SIGUSR2, which toggles debug logging
+func notifyVerbosity(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGUSR2)
+}
@@ -0,0 +1,8 @@
+//go:build windows || plan9
+
+package main
+
+import "os"
+
+// notifyVerbosity does nothing, SIGUSR2 doesn't exist on this platform
+func notifyVerbosity(c chan<- os.Signal) {}
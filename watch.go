@@ -0,0 +1,194 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchDebounce groups the events of a single save, or of a batch of edits,
+// into one cycle
+const watchDebounce = 2 * time.Second
+
+// stackWatcher triggers cycles as soon as compose files change, using
+// filesystem notifications. Directories that can't be watched, once the
+// inotify watch limit is reached, are left to polling.
+type stackWatcher struct {
+	watcher  *fsnotify.Watcher
+	root     string
+	log      *slog.Logger
+	triggers chan struct{}
+
+	mu     sync.Mutex
+	polled []string
+	files  stackFiles
+}
+
+// newStackWatcher watches every directory of the repository within the scan
+// limits, logging to the repository's log
+func newStackWatcher(root string, log *slog.Logger) (*stackWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	w := &stackWatcher{watcher: watcher, root: root, log: log, triggers: make(chan struct{}, 1)}
+	if err := w.addTree(root); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	go w.run()
+	return w, nil
+}
+
+// addTree watches a directory and its subdirectories. On the watch limit,
+// the directory is polled instead and its subdirectories are skipped.
+func (w *stackWatcher) addTree(dir string) error {
+	skipped := skipDirs()
+	return filepath.WalkDir(dir, func(osPath string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+
+		rel, _ := filepath.Rel(w.root, osPath)
+		rel = filepath.ToSlash(rel)
+		if rel != "." {
+			depth := strings.Count(rel, "/") + 1
+			if d.Name() == ".git" || skipped[d.Name()] || rel == archiveDirFlag || (scanDepthFlag > 0 && depth > scanDepthFlag) {
+				return filepath.SkipDir
+			}
+		}
+
+		err = w.watcher.Add(osPath)
+		switch {
+		case errors.Is(err, syscall.ENOSPC):
+			w.poll(rel)
+			return filepath.SkipDir
+		case errors.Is(err, os.ErrNotExist):
+			return filepath.SkipDir
+		case err != nil:
+			return err
+		}
+		return nil
+	})
+}
+
+// poll records a subtree left to polling, with guidance on the first one
+func (w *stackWatcher) poll(rel string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.polled) == 0 {
+		w.log.Warn("Inotify watch limit reached, polling the remaining directories instead. Raise it with 'sysctl fs.inotify.max_user_watches=524288' (persist it in /etc/sysctl.d) or narrow the tree with --skip-dirs and --scan-depth",
+			"poll_interval", watchPollFlag)
+	}
+	w.log.Warn("Directory is polled instead of watched", "path", rel)
+	w.polled = append(w.polled, rel)
+}
+
+// polling reports whether some directories are left to polling
+func (w *stackWatcher) polling() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.polled) > 0
+}
+
+// follow relays the events of the stack files too, watching the directories
+// of the included files that the tree left out
+func (w *stackWatcher) follow(files stackFiles) {
+	w.mu.Lock()
+	w.files = files
+	w.mu.Unlock()
+
+	for name := range files.included {
+		// Watching a directory again is harmless, one that can't be watched
+		// is checked by the next cycle
+		w.watcher.Add(filepath.Join(w.root, filepath.FromSlash(path.Dir(name))))
+	}
+}
+
+// follows reports whether a repository path is a stack file committed with
+// its stack, leaving out the clutter --clutter-size looks at
+func (w *stackWatcher) follows(rel string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.files.included[rel] {
+		return true
+	}
+	return stackDirFlag && w.files.contains(rel)
+}
+
+// trigger requests a cycle, unless one is already pending
+func (w *stackWatcher) trigger() {
+	select {
+	case w.triggers <- struct{}{}:
+	default:
+	}
+}
+
+// run relays the events of compose and stack files as debounced cycle
+// triggers, and watches
+// directories created after startup
+func (w *stackWatcher) run() {
+	var debounce *time.Timer
+	for {
+		select {
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+
+			rel, _ := filepath.Rel(w.root, event.Name)
+			rel = filepath.ToSlash(rel)
+			if rel == ".git" || strings.HasPrefix(rel, ".git/") || isArchived(rel) {
+				continue
+			}
+
+			isDir := false
+			if event.Has(fsnotify.Create) {
+				if info, err := os.Lstat(event.Name); err == nil && info.IsDir() {
+					isDir = true
+					if err := w.addTree(event.Name); err != nil {
+						w.log.Warn("Failed to watch new directory", "path", rel, "error", err)
+					}
+				}
+			}
+			if !isDir && !isWatchedFile(rel) && !w.follows(rel) && !event.Has(fsnotify.Rename) && !event.Has(fsnotify.Remove) {
+				continue
+			}
+
+			w.log.Debug("Filesystem event", "path", rel, "op", event.Op.String())
+			if debounce == nil {
+				debounce = time.AfterFunc(watchDebounce, w.trigger)
+			} else {
+				debounce.Reset(watchDebounce)
+			}
+
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				w.log.Warn("Filesystem events were lost, checking the whole repository", "error", err)
+				w.trigger()
+				continue
+			}
+			w.log.Warn("Filesystem watch error", "error", err)
+		}
+	}
+}
+
+// Close stops watching
+func (w *stackWatcher) Close() error {
+	return w.watcher.Close()
+}
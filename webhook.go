@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Events posted to --webhook-url
+const (
+	webhookCommit       = "commit"
+	webhookPushFailed   = "push_failed"
+	webhookStackDeleted = "stack_deleted"
+
+	// webhookDigest is posted every --digest-interval, whatever the
+	// --webhook-events and --chat-events
+	webhookDigest = "digest"
+)
+
+// webhookTimeout bounds how long a webhook may take to answer, so a slow
+// receiver doesn't hold the cycle
+const webhookTimeout = 10 * time.Second
+
+// webhookPayload is the JSON document posted to --webhook-url
+type webhookPayload struct {
+	Event   string     `json:"event"`
+	Time    time.Time  `json:"time"`
+	Host    string     `json:"host"`
+	Repo    string     `json:"repo"`
+	Stack   string     `json:"stack,omitempty"`
+	Change  ChangeType `json:"change,omitempty"`
+	Path    string     `json:"path,omitempty"`
+	Hash    string     `json:"hash,omitempty"`
+	Message string     `json:"message,omitempty"`
+	Error   string     `json:"error,omitempty"`
+
+	// Aliases are the past names of a renamed stack
+	Aliases []string `json:"aliases,omitempty"`
+
+	// Failures counts the consecutive push failures of a push_failed event
+	Failures int `json:"failures,omitempty"`
+
+	// Since and Digest are the period and counts of a digest
+	Since  time.Time    `json:"since,omitzero"`
+	Digest []repoDigest `json:"digest,omitempty"`
+}
+
+// listedEvents returns the events of a comma separated list
+func listedEvents(list string) map[string]bool {
+	events := map[string]bool{}
+	for _, event := range strings.Split(list, ",") {
+		if event = strings.TrimSpace(event); event != "" {
+			events[event] = true
+		}
+	}
+	return events
+}
+
+// parseWebhookEvents validates the events listed in --webhook-events and
+// --chat-events
+func parseWebhookEvents(list string) error {
+	for event := range listedEvents(list) {
+		switch event {
+		case webhookCommit, webhookPushFailed, webhookStackDeleted:
+		default:
+			return fmt.Errorf("unknown event %q, expected '%s', '%s' or '%s'", event, webhookCommit, webhookPushFailed, webhookStackDeleted)
+		}
+	}
+	return nil
+}
+
+// chatMessage formats an event for people reading a chat channel, e.g.
+// "✅ updated web — c6b9c5a"
+func chatMessage(payload webhookPayload) string {
+	var message string
+	switch payload.Event {
+	case webhookCommit:
+		message = fmt.Sprintf("✅ %s %s — %s", payload.Change, payload.Stack, shortHash(payload.Hash))
+	case webhookStackDeleted:
+		message = fmt.Sprintf("🔥 %s %s — %s", payload.Change, payload.Stack, shortHash(payload.Hash))
+	case webhookPushFailed:
+		message = fmt.Sprintf("❌ push failed on %s: %s", payload.Host, payload.Error)
+	}
+	if len(repoFlag) > 1 {
+		message += " (" + payload.Repo + ")"
+	}
+	return message
+}
+
+func shortHash(hash string) string {
+	return hash[:min(7, len(hash))]
+}
+
+// postWebhook posts an event to --webhook-url as a JSON payload, and to the
+// chat webhooks and push notification servers as a message, where it's
+// enabled. Failures are logged, the cycle goes on.
+func postWebhook(payload webhookPayload) {
+	payload.Time = time.Now().UTC()
+	payload.Host, _ = os.Hostname()
+	payload.Repo = firstOf(currentScope().repo, repoFlag.String())
+
+	if listedEvents(webhookEventsFlag)[payload.Event] {
+		postJSON("webhook", payload.Event, secretValue(webhookURLFlag, "WEBHOOK_URL"), "", payload)
+	}
+	if listedEvents(chatEventsFlag)[payload.Event] {
+		postChat(payload.Event, chatMessage(payload), eventPriority(payload))
+	}
+}
+
+// postChat posts a message to the Discord and Slack webhooks and the ntfy and
+// Gotify servers that are configured
+func postChat(event string, message string, priority int) {
+	postJSON("discord", event, secretValue(discordWebhookFlag, "DISCORD_WEBHOOK_URL"), "", map[string]string{"content": message})
+	postJSON("slack", event, secretValue(slackWebhookFlag, "SLACK_WEBHOOK_URL"), "", map[string]string{"text": message})
+	postNtfy(event, message, priority)
+	postGotify(event, message, priority)
+}
+
+// postJSON posts a document to a webhook URL, with token as a bearer token
+// when set, doing nothing without a URL
+func postJSON(target string, event string, url string, token string, document any) {
+	if url == "" {
+		return
+	}
+	body, err := json.Marshal(document)
+	if err != nil {
+		opsLog.Warn("Webhook failed", "target", target, "event", event, "error", err)
+		return
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		opsLog.Warn("Webhook failed", "target", target, "event", event, "error", redact(err.Error(), []string{url}))
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	client := &http.Client{Timeout: webhookTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		opsLog.Warn("Webhook failed", "target", target, "event", event, "error", redact(err.Error(), []string{url}))
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		opsLog.Warn("Webhook failed", "target", target, "event", event, "status", resp.Status)
+		return
+	}
+	opsLog.Debug("Webhook sent", "target", target, "event", event)
+}
+
+// postCommitWebhook posts a created stack commit, as stack_deleted when the
+// stack was removed
+func postCommitWebhook(change Change, hash string, message string) {
+	event := webhookCommit
+	if change.ChangeType == Deleted {
+		event = webhookStackDeleted
+	}
+	postWebhook(webhookPayload{Event: event, Stack: change.StackName, Change: change.ChangeType, Path: change.FilePath, Hash: hash, Message: message, Aliases: change.Aliases})
+}
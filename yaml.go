@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/go-git/go-git/v6"
+	"gopkg.in/yaml.v3"
+)
+
+// normalizeYAML parses every document in data and re-serializes it, so content
+// that only differs by key order, quoting, comments or indentation normalizes
+// to the same bytes
+func normalizeYAML(data []byte) ([]byte, error) {
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var doc any
+		err := decoder.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := encoder.Encode(doc); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
+
+// decodeDocuments decodes every document in data into out in turn, so a
+// multi-document file reads as the union of its documents, later ones
+// overriding the keys of earlier ones
+func decodeDocuments(data []byte, out any) error {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		err := decoder.Decode(out)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// headFileContents returns the contents of a file as committed in HEAD
+func headFileContents(repo *git.Repository, filePath string) ([]byte, error) {
+	head, err := repo.Head()
+	if err != nil {
+		return nil, err
+	}
+
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := commit.File(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	contents, err := file.Contents()
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(contents), nil
+}
+
+// isFormattingOnly reports whether an updated compose file normalizes to the
+// same content as its HEAD version
+func isFormattingOnly(repo *git.Repository, repoPath string, change Change) (bool, error) {
+	if change.ChangeType != Updated || !isComposeFile(change.FilePath) || len(change.Extras) > 0 {
+		return false, nil
+	}
+
+	before, err := headFileContents(repo, change.FilePath)
+	if err != nil {
+		return false, fmt.Errorf("failed to read HEAD version: %w", err)
+	}
+
+	after, err := readWorktreeFile(repoPath, change.FilePath)
+	if err != nil {
+		return false, fmt.Errorf("failed to read worktree version: %w", err)
+	}
+
+	normalizedBefore, err := normalizeYAML(before)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse HEAD version: %w", err)
+	}
+
+	normalizedAfter, err := normalizeYAML(after)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse worktree version: %w", err)
+	}
+
+	return bytes.Equal(normalizedBefore, normalizedAfter), nil
+}
+
+// filterFormattingOnly drops updates that don't change the normalized YAML
+// content. Changes that can't be compared are kept.
+func filterFormattingOnly(repo *git.Repository, repoPath string, changes []Change) []Change {
+	var kept []Change
+	for _, change := range changes {
+		formattingOnly, err := isFormattingOnly(repo, repoPath, change)
+		if err != nil {
+			opsLog.Warn("Failed to normalize, keeping change", "path", change.FilePath, "error", err)
+		}
+		if formattingOnly {
+			eventLog.Info("Ignoring formatting-only change", "stack", change.StackName, "path", change.FilePath)
+			continue
+		}
+		kept = append(kept, change)
+	}
+	return kept
+}
+
+// formatYAML re-serializes every document in data with the given indent,
+// keeping key order and comments intact
+func formatYAML(data []byte, indent int) ([]byte, error) {
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(indent)
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var doc yaml.Node
+		err := decoder.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		blockStyle(&doc)
+		if err := encoder.Encode(&doc); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
+
+// blockStyle switches flow mappings and sequences to block style, leaving
+// scalar quoting untouched. Merge keys lose their resolved tag, which the
+// encoder would otherwise print as "!!merge <<".
+func blockStyle(node *yaml.Node) {
+	if node.Kind == yaml.MappingNode || node.Kind == yaml.SequenceNode {
+		node.Style &^= yaml.FlowStyle
+	}
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!merge" {
+		node.Tag = ""
+	}
+	for _, child := range node.Content {
+		blockStyle(child)
+	}
+}
+
+// formatComposeFile rewrites a compose file in the worktree with the canonical
+// formatting, leaving it untouched when it is already formatted
+func formatComposeFile(repoPath string, filePath string) error {
+	path := filepath.Join(repoPath, filepath.FromSlash(filePath))
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	formatted, err := formatYAML(data, formatIndentFlag)
+	if err != nil {
+		return fmt.Errorf("failed to parse: %w", err)
+	}
+
+	if bytes.Equal(data, formatted) {
+		return nil
+	}
+
+	return os.WriteFile(path, formatted, info.Mode().Perm())
+}
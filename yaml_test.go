@@ -0,0 +1,138 @@
+package main
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestServiceDefinitions(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want map[string]any
+	}{
+		{
+			name: "single document",
+			data: "services:\n  web:\n    image: nginx\n",
+			want: map[string]any{"web": map[string]any{"image": "nginx"}},
+		},
+		{
+			name: "later documents override earlier ones",
+			data: "services:\n  web:\n    image: nginx\n---\nservices:\n  web:\n    image: nginx:2\n  db:\n    image: postgres\n",
+			want: map[string]any{"web": map[string]any{"image": "nginx:2"}, "db": map[string]any{"image": "postgres"}},
+		},
+		{
+			name: "document without services",
+			data: "services:\n  web:\n    image: nginx\n---\nvolumes:\n  data: {}\n",
+			want: map[string]any{"web": map[string]any{"image": "nginx"}},
+		},
+		{
+			name: "merge keys",
+			data: "x-common: &common\n  restart: always\nservices:\n  web:\n    <<: *common\n    image: nginx\n",
+			want: map[string]any{"web": map[string]any{"restart": "always", "image": "nginx"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := serviceDefinitions([]byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("serviceDefinitions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInterpolatedVarsDocuments(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []string
+	}{
+		{"single document", "services:\n  web:\n    image: nginx:${TAG}\n", []string{"TAG"}},
+		{"every document", "services:\n  web:\n    image: nginx:${TAG}\n---\nservices:\n  db:\n    image: postgres:${PG_TAG}\n", []string{"TAG", "PG_TAG"}},
+		{"repeated variable", "services:\n  web:\n    image: ${TAG}\n---\nservices:\n  db:\n    image: ${TAG}\n", []string{"TAG"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := interpolatedVars([]byte(tt.data)); !slices.Equal(got, tt.want) {
+				t.Errorf("interpolatedVars() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatYAML(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		contains []string
+		excludes []string
+	}{
+		{
+			name:     "merge keys",
+			data:     "x-common: &common\n  restart: always\nservices:\n  web:\n    <<: *common\n    image: nginx\n",
+			contains: []string{"<<: *common"},
+			excludes: []string{"!!merge"},
+		},
+		{
+			name:     "every document",
+			data:     "services: {web: {image: nginx}}\n---\nservices: {db: {image: postgres}}\n",
+			contains: []string{"web:\n", "db:\n", "---\n"},
+			excludes: []string{"{"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := formatYAML([]byte(tt.data), 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range tt.contains {
+				if !strings.Contains(string(got), s) {
+					t.Errorf("formatYAML() = %q, want it to contain %q", got, s)
+				}
+			}
+			for _, s := range tt.excludes {
+				if strings.Contains(string(got), s) {
+					t.Errorf("formatYAML() = %q, want it not to contain %q", got, s)
+				}
+			}
+		})
+	}
+}
+
+func TestNormalizeYAMLDocuments(t *testing.T) {
+	tests := []struct {
+		name  string
+		a, b  string
+		equal bool
+	}{
+		{"reformatted second document", "a: 1\n---\nb: 2\n", "a: 1\n---\nb:   2 # two\n", true},
+		{"changed second document", "a: 1\n---\nb: 2\n", "a: 1\n---\nb: 3\n", false},
+		{"dropped document", "a: 1\n---\nb: 2\n", "a: 1\n", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := normalizeYAML([]byte(tt.a))
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := normalizeYAML([]byte(tt.b))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := string(a) == string(b); got != tt.equal {
+				t.Errorf("normalized %q and %q equal = %v, want %v", tt.a, tt.b, got, tt.equal)
+			}
+		})
+	}
+}